@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptStore 登录失败记录（防暴力破解）
+// key为"account:<provider>:<账号>"或"ip:<IP>"
+// 多实例部署时，应使用共享的存储（如基于redis自行实现），
+// 否则攻击者可以轮流请求不同的实例
+type AttemptStore interface {
+	// Get 查询记录，没有记录则返回零值
+	Get(key string) (Attempt, error)
+	// Fail 记录一次失败，并返回更新后的记录
+	// 上次失败在window之前的，重新计数
+	Fail(key string, at time.Time, window time.Duration) (Attempt, error)
+	// Lock 锁定到until
+	Lock(key string, until time.Time) error
+	// Reset 清除记录（登录成功后）
+	Reset(key string) error
+	// Cleanup 清理before之前的记录（最后失败、锁定都早于before）
+	Cleanup(before time.Time) error
+}
+
+// Attempt 登录失败记录
+type Attempt struct {
+	Failures     int       // 连续失败次数
+	LastFailedAt time.Time // 最后失败时间
+	LockedUntil  time.Time // 锁定到
+}
+
+// LoginThrottle 防暴力破解策略
+// 连续失败FreeAttempts次之后，每次尝试前须等待Delay，逐次翻倍，最多MaxDelay；
+// 连续失败达到LockoutAfter（同一账号）或IPLockoutAfter（同一IP）次，锁定LockoutDuration
+type LoginThrottle struct {
+	FreeAttempts    int
+	Delay           time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	IPLockoutAfter  int
+	LockoutDuration time.Duration
+	Window          time.Duration // 距上次失败超过Window，重新计数
+}
+
+// DefaultLoginThrottle 默认防暴力破解策略
+func DefaultLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		FreeAttempts:    3,
+		Delay:           1 * time.Second,
+		MaxDelay:        30 * time.Second,
+		LockoutAfter:    10,
+		IPLockoutAfter:  100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          1 * time.Hour,
+	}
+}
+
+// delay 连续失败failures次之后，须等待的时间
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures < t.FreeAttempts {
+		return 0
+	}
+	delay := t.Delay
+	for i := t.FreeAttempts; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay
+}
+
+// NewMemoryAttemptStore 内存记录（仅限单实例，重启后丢失）
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{attempts: map[string]Attempt{}}
+}
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempt
+}
+
+func (s *memoryAttemptStore) Get(key string) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *memoryAttemptStore) Fail(key string, at time.Time, window time.Duration) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt := s.attempts[key]
+	if attempt.LastFailedAt.Add(window).Before(at) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = at
+	s.attempts[key] = attempt
+	return attempt, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt := s.attempts[key]
+	attempt.LockedUntil = until
+	s.attempts[key] = attempt
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *memoryAttemptStore) Cleanup(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, attempt := range s.attempts {
+		if attempt.LastFailedAt.Before(before) && attempt.LockedUntil.Before(before) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
@@ -1,18 +1,112 @@
 package auth
 
 import (
-	"github.com/go-chi/jwtauth"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres" // postgres
 )
 
 // New 返回Auth类
 func New(config Config) *Auth {
+	storage := config.Storage
+	if storage == nil {
+		if config.DB != nil {
+			storage = NewGormStorage(config.DB)
+		} else {
+			storage = NewMemoryStorage()
+		}
+	}
+	revocations := config.Revocations
+	if revocations == nil {
+		if config.DB != nil {
+			revocations = NewGormRevocationStore(config.DB)
+		} else {
+			revocations = NewMemoryRevocationStore()
+		}
+	}
 	auth := &Auth{
-		secretKey: config.SecretKey,
-		gormDB:    config.DB,
-		jwtauth:   jwtauth.New("HS256", config.SecretKey, nil),
+		tokenKeys:           newTokenKeys(config.SecretKey, config.TokenKeys),
+		storage:             storage,
+		revocations:         revocations,
+		rotateRefreshTokens: config.RotateRefreshTokens,
+		reuseGrace:          config.RefreshTokenReuseGrace,
+		clientIPHeader:      config.ClientIPHeader,
+		jwtKeys:             newJWTKeys(config.SecretKey, config.JWTKeys),
+		tokenLife:           config.TokenLife,
+		refreshTokenLife:    config.RefreshTokenLife,
+		cleanupInterval:     config.CleanupInterval,
+		jwtCookie:           config.JWTCookie,
+		refreshTokenCookie:  config.RefreshTokenCookie,
+		csrfCookie:          config.CSRFCookie,
+		cookieDomain:        config.CookieDomain,
+		cookieSecure:        config.CookieSecure,
+		cookieSameSite:      config.CookieSameSite,
+		now:                 config.Now,
+		loginAttempts:       config.LoginAttempts,
+		loginThrottle:       config.LoginThrottle,
+		twoFactorIssuer:     config.TwoFactorIssuer,
+		challengeLife:       config.ChallengeLife,
+		onMergeUsers:        config.OnMergeUsers,
+		enrichClaims:        config.EnrichClaims,
+		impersonationLife:   config.ImpersonationLife,
+		oauthConsent:        config.OAuthConsent,
+		oauthLoginURL:       config.OAuthLoginURL,
+		oauthCodeLife:       config.AuthorizationCodeLife,
+		stop:                make(chan struct{}),
+	}
+	if auth.tokenLife == 0 {
+		auth.tokenLife = DefaultTokenLife
+	}
+	if auth.refreshTokenLife == 0 {
+		auth.refreshTokenLife = DefaultRefreshTokenLife
+	}
+	if auth.reuseGrace == 0 {
+		auth.reuseGrace = DefaultRefreshTokenReuseGrace
+	}
+	if auth.cleanupInterval == 0 {
+		auth.cleanupInterval = DefaultCleanupInterval
+	}
+	if auth.jwtCookie == "" {
+		auth.jwtCookie = DefaultJWTCookie
+	}
+	if auth.refreshTokenCookie == "" {
+		auth.refreshTokenCookie = DefaultRefreshTokenCookie
+	}
+	if auth.csrfCookie == "" {
+		auth.csrfCookie = DefaultCSRFCookie
+	}
+	if auth.cookieSameSite == 0 {
+		auth.cookieSameSite = http.SameSiteLaxMode
+	}
+	if auth.loginAttempts == nil {
+		auth.loginAttempts = NewMemoryAttemptStore()
+	}
+	if auth.loginThrottle == nil {
+		auth.loginThrottle = DefaultLoginThrottle()
+	}
+	if auth.challengeLife == 0 {
+		auth.challengeLife = DefaultChallengeLife
+	}
+	if auth.oauthCodeLife == 0 {
+		auth.oauthCodeLife = DefaultAuthorizationCodeLife
 	}
+	if auth.impersonationLife == 0 {
+		auth.impersonationLife = DefaultImpersonationLife
+	}
+	if auth.now == nil {
+		auth.now = time.Now
+	}
+	auth.jwtKeys.now = auth.now
+	auth.twoFactorKeys = newTokenKeys(config.SecretKey, config.TwoFactorKeys).derive("totp secret")
+	auth.challengeKeys = auth.tokenKeys.derive("2fa challenge")
+	auth.jwtKeys.issuer = config.Issuer
+	auth.jwtKeys.audience = config.Audience
 	auth.Repository = newRepository(auth)
 	auth.Service = newService(auth)
 	auth.Handler = newHandler(auth)
@@ -23,9 +117,120 @@ func New(config Config) *Auth {
 // Config 配置
 type Config struct {
 	SecretKey []byte
-	DB        *gorm.DB
+	// Storage 存储后端，优先使用
+	// 若为空，有DB则使用NewGormStorage(DB)，否则使用NewMemoryStorage()
+	Storage Storage
+	DB      *gorm.DB
+	// Revocations JWT注销记录，多实例部署时须共享
+	// 若为空，有DB则使用NewGormRevocationStore(DB)，否则使用NewMemoryRevocationStore()
+	Revocations RevocationStore
+	// RotateRefreshTokens 每次续约都轮换RefreshToken
+	// 已轮换的RefreshToken再次使用时，视为被盗用，吊销同一次登录（family）的所有RefreshToken
+	// 注意：客户端须保存每次续约返回的新RefreshToken
+	RotateRefreshTokens bool
+	// RefreshTokenReuseGrace 轮换后的宽限期，为空则使用DefaultRefreshTokenReuseGrace
+	// 宽限期内旧的RefreshToken再次使用（如浏览器并发的请求同时续约），只颁发jwt，不视为盗用
+	RefreshTokenReuseGrace time.Duration
+	// ClientIPHeader 记录日志时，从哪个请求头获取客户端IP（如"X-Real-IP"）
+	// 只有部署在可信的反向代理后面才应设置，否则客户端可以伪造
+	// 为空则使用RemoteAddr
+	ClientIPHeader string
+	// JWTKeys JWT非对称签名密钥（RS256、ES256、EdDSA……），按kid区分，支持多个
+	// 第一个带PrivateKey的用于签发，其余的只用于验证（轮换期间旧key继续有效）
+	// 公钥通过Handler.HandleJWKS发布，下游服务无需持有可以签发jwt的密钥
+	// 为空时使用SecretKey做HS256签名
+	JWTKeys []JWTKey
+	// TokenKeys refresh token的加密密钥环，第一个用于加密新token
+	// 解密时按token里的key ID选择密钥，轮换时把新key放到第一位，
+	// 旧key留着，等到计划的时间再移除（其加密的token随之失效）
+	// 为空时使用SecretKey
+	TokenKeys []TokenKey
+	// TwoFactorKeys 两步验证TOTP密钥的加密密钥环，第一个用于加密
+	// 与TokenKeys不同，旧key不能移除：其加密的TOTP密钥将无法解密，用户无法再两步验证登录
+	// 轮换时把新key放到第一位，用户两步验证成功后自动用新key重新加密
+	// 为空时使用SecretKey
+	TwoFactorKeys []TokenKey
+	// TokenLife JWT有效时长，为空则使用DefaultTokenLife
+	TokenLife time.Duration
+	// Issuer、Audience jwt的iss、aud，设置后签发时写入，验证时检查（aud须包含Audience）
+	// 为空则不写入、不检查；新设置后，之前签发的jwt会失效（有refresh token的会自动续约）
+	Issuer   string
+	Audience string
+	// ImpersonationLife 模拟登录（Service.Impersonate）jwt有效时长，为空则使用DefaultImpersonationLife
+	// 比TokenLife长的，注销记录也要保留这么久（结束模拟后jwt才能一直失效）
+	ImpersonationLife time.Duration
+	// EnrichClaims 签发登录jwt时调用，返回要额外写入的claims（如角色、租户、用户名），
+	// 下游可以直接从ContextRepository.Claims读取，不用再查数据库；jwt有效期内不会更新
+	// 保留的claims（iat、exp、nbf、sub、sid、iss、aud、jti、client_id、scope、act）不能覆盖，会被忽略
+	// 返回错误则登录（续约）失败；OAuth access token不调用
+	EnrichClaims func(user *User) (map[string]interface{}, error)
+	// RefreshTokenLife refresh token有效时长，为空则使用DefaultRefreshTokenLife
+	RefreshTokenLife time.Duration
+	// CleanupInterval 清理注销记录的间隔，为空则使用DefaultCleanupInterval
+	CleanupInterval time.Duration
+	// JWTCookie、RefreshTokenCookie cookie名称，为空则使用"jwt"、"refresh_token"
+	JWTCookie          string
+	RefreshTokenCookie string
+	// CookieDomain、CookieSecure、CookieSameSite cookie属性
+	// 生产环境（https）应设置CookieSecure；CookieSameSite为空则使用http.SameSiteLaxMode
+	CookieDomain   string
+	CookieSecure   bool
+	CookieSameSite http.SameSite
+	// CSRFCookie Middleware.CSRF使用的cookie名称，为空则使用"csrf_token"
+	CSRFCookie string
+	// LoginAttempts 登录失败记录（防暴力破解），为空则使用NewMemoryAttemptStore()
+	// 多实例部署时须共享
+	LoginAttempts AttemptStore
+	// LoginThrottle 防暴力破解策略，为空则使用DefaultLoginThrottle()
+	// 只对实现了ThrottledProvider的登录方式（如password）生效
+	LoginThrottle *LoginThrottle
+	// TwoFactorIssuer 两步验证App里显示的发行方（如网站名称）
+	TwoFactorIssuer string
+	// ChallengeLife 两步验证challenge有效时长，为空则使用DefaultChallengeLife
+	ChallengeLife time.Duration
+	// OnMergeUsers 合并用户（Service.MergeUsers）前调用，
+	// 应用可以在这里把自己数据表里引用source.ID的外键改成target.ID
+	// 返回错误则取消合并；合并失败时可能已经调用过，须能重复执行
+	OnMergeUsers func(target, source *User) error
+	// OAuthConsent OAuth授权确认（Handler.OAuthAuthorize），Trusted的client不调用
+	// 返回用户同意的scope（可以比申请的少），返回错误或者空则视为拒绝（access_denied）
+	// 应用可以先显示确认页面，用户同意后再带上原参数访问授权页，在这里检查
+	// 为空则只有Trusted的client可以使用授权码，其他的一律拒绝（access_denied）
+	OAuthConsent func(r *http.Request, user *User, client *OAuthClient, scopes []string) (granted []string, err error)
+	// OAuthLoginURL 授权页发现用户未登录时跳转的登录页，会带上?redirect=原授权页地址
+	// 为空则返回401
+	OAuthLoginURL string
+	// AuthorizationCodeLife OAuth授权码有效时长，为空则使用DefaultAuthorizationCodeLife
+	AuthorizationCodeLife time.Duration
+	// Now 时钟，用于签发和判断过期，为空则使用time.Now
+	// 测试时可以注入假时钟
+	Now func() time.Time
 }
 
+// 默认配置
+const (
+	// DefaultTokenLife 默认JWT token有效时长
+	DefaultTokenLife = 1 * time.Hour // 1 Hour
+	// DefaultRefreshTokenLife 默认refresh token有效时长
+	DefaultRefreshTokenLife = 365 * 24 * time.Hour // 1 Year
+	// DefaultRefreshTokenReuseGrace 默认RefreshToken轮换后的宽限期
+	DefaultRefreshTokenReuseGrace = 10 * time.Second
+	// DefaultCleanupInterval 默认清理注销记录间隔
+	DefaultCleanupInterval = 10 * time.Second
+	// DefaultJWTCookie 默认jwt的cookie名称
+	DefaultJWTCookie = "jwt"
+	// DefaultRefreshTokenCookie 默认refresh token的cookie名称
+	DefaultRefreshTokenCookie = "refresh_token"
+	// DefaultChallengeLife 默认两步验证challenge有效时长
+	DefaultChallengeLife = 5 * time.Minute
+	// DefaultCSRFCookie 默认csrf token的cookie名称
+	DefaultCSRFCookie = "csrf_token"
+	// DefaultAuthorizationCodeLife 默认OAuth授权码有效时长
+	DefaultAuthorizationCodeLife = 1 * time.Minute
+	// DefaultImpersonationLife 默认模拟登录jwt有效时长
+	DefaultImpersonationLife = 15 * time.Minute
+)
+
 // Auth 认证类
 type Auth struct {
 	Repository *Repository
@@ -33,14 +238,91 @@ type Auth struct {
 	Handler    *Handler
 	Middleware *Middleware
 
-	secretKey []byte
-	gormDB    *gorm.DB
-	jwtauth   *jwtauth.JWTAuth
+	tokenKeys           *tokenKeys
+	twoFactorKeys       *tokenKeys // 加密TOTP密钥，由Config.TwoFactorKeys派生
+	challengeKeys       *tokenKeys // 加密两步验证的challenge，由tokenKeys派生
+	storage             Storage
+	revocations         RevocationStore
+	rotateRefreshTokens bool
+	reuseGrace          time.Duration
+	clientIPHeader      string
+	jwtKeys             *jwtKeys
+	tokenLife           time.Duration
+	refreshTokenLife    time.Duration
+	cleanupInterval     time.Duration
+	jwtCookie           string
+	refreshTokenCookie  string
+	csrfCookie          string
+	cookieDomain        string
+	cookieSecure        bool
+	cookieSameSite      http.SameSite
+	now                 func() time.Time
+	loginAttempts       AttemptStore
+	loginThrottle       *LoginThrottle
+	twoFactorIssuer     string
+	challengeLife       time.Duration
+	onMergeUsers        func(target, source *User) error
+	enrichClaims        func(user *User) (map[string]interface{}, error)
+	impersonationLife   time.Duration
+	oauthConsent        func(r *http.Request, user *User, client *OAuthClient, scopes []string) ([]string, error)
+	oauthLoginURL       string
+	oauthCodeLife       time.Duration
+
+	// 后台任务
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Now 当前时间（Config.Now），LoginProvider也应使用这个时钟
+func (auth *Auth) Now() time.Time {
+	return auth.now()
 }
 
-func (auth *Auth) db() *gorm.DB {
-	if auth.gormDB == nil {
-		panic("Auth 缺少有效的*gorm.DB对象")
+// Close 停止所有后台任务，并等待其退出
+// 可以重复调用；关闭后仍可处理请求，只是不再定期清理
+func (auth *Auth) Close() error {
+	return auth.Shutdown(context.Background())
+}
+
+// Shutdown 停止所有后台任务，等待其退出，直到ctx结束
+func (auth *Auth) Shutdown(ctx context.Context) error {
+	auth.closeOnce.Do(func() {
+		close(auth.stop)
+	})
+	done := make(chan struct{})
+	go func() {
+		auth.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// goBackground 启动后台任务，Close时等待其退出
+func (auth *Auth) goBackground(task func(stop <-chan struct{})) {
+	auth.wg.Add(1)
+	go func() {
+		defer auth.wg.Done()
+		task(auth.stop)
+	}()
+}
+
+// clientIP 获取客户端IP
+func (auth *Auth) clientIP(r *http.Request) string {
+	if auth.clientIPHeader != "" {
+		// X-Forwarded-For: client, proxy1, proxy2
+		if ip := strings.TrimSpace(strings.Split(r.Header.Get(auth.clientIPHeader), ",")[0]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return auth.gormDB
+	return host
 }
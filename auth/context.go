@@ -3,6 +3,8 @@ package auth
 import (
 	"context"
 	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
 )
 
 // NewContext 新建context装饰类
@@ -51,13 +53,194 @@ func (r *ContextRepository) WithUser(user *User) *ContextRepository {
 	return r
 }
 
+// SessionID 在context里获取当前会话（refresh token）的ID，没有则为0
+func (r *ContextRepository) SessionID() uint64 {
+	sessionID, _ := r.context.Value(contextKeySession).(uint64)
+	return sessionID
+}
+
+// WithSessionID 在context里带上当前会话ID
+func (r *ContextRepository) WithSessionID(sessionID uint64) *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeySession, sessionID)
+	return r
+}
+
+// Roles 在context里获取角色（nil表示尚未加载）
+func (r *ContextRepository) Roles() []string {
+	roles, _ := r.context.Value(contextKeyRoles).([]string)
+	return roles
+}
+
+// WithRoles 在context里带上角色
+func (r *ContextRepository) WithRoles(roles ...string) *ContextRepository {
+	if roles == nil {
+		roles = []string{}
+	}
+	r.context = context.WithValue(r.context, contextKeyRoles, roles)
+	return r
+}
+
+// HasRole 是否拥有其中任一角色
+func (r *ContextRepository) HasRole(roles ...string) bool {
+	for _, owned := range r.Roles() {
+		for _, role := range roles {
+			if owned == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions 在context里获取权限（nil表示尚未加载）
+func (r *ContextRepository) Permissions() []string {
+	permissions, _ := r.context.Value(contextKeyPermissions).([]string)
+	return permissions
+}
+
+// WithPermissions 在context里带上权限
+func (r *ContextRepository) WithPermissions(permissions ...string) *ContextRepository {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	r.context = context.WithValue(r.context, contextKeyPermissions, permissions)
+	return r
+}
+
+// Can 是否拥有全部权限
+func (r *ContextRepository) Can(permissions ...string) bool {
+	owned := map[string]bool{}
+	for _, permission := range r.Permissions() {
+		owned[permission] = true
+	}
+	for _, permission := range permissions {
+		if !owned[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// ActorID 在context里获取模拟登录的管理员ID（Service.Impersonate），不是模拟登录则为0
+// 此时UserID、User是被模拟的用户
+func (r *ContextRepository) ActorID() uint64 {
+	actorID, _ := r.context.Value(contextKeyActor).(uint64)
+	return actorID
+}
+
+// WithActorID 在context里带上模拟登录的管理员ID
+func (r *ContextRepository) WithActorID(actorID uint64) *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeyActor, actorID)
+	return r
+}
+
+// RealUserID 在context里获取实际操作的用户ID（模拟登录时为管理员，否则即UserID）
+func (r *ContextRepository) RealUserID() uint64 {
+	if actorID := r.ActorID(); actorID != 0 {
+		return actorID
+	}
+	return r.UserID()
+}
+
+// ClientID 在context里获取OAuth客户端ID（OAuth access token才有，否则为空）
+func (r *ContextRepository) ClientID() string {
+	clientID, _ := r.context.Value(contextKeyClient).(string)
+	return clientID
+}
+
+// WithClientID 在context里带上OAuth客户端ID
+func (r *ContextRepository) WithClientID(clientID string) *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeyClient, clientID)
+	return r
+}
+
+// APIKeyID 在context里获取当前API key的ID（用API key访问才有，否则为0）
+func (r *ContextRepository) APIKeyID() uint64 {
+	id, _ := r.context.Value(contextKeyAPIKey).(uint64)
+	return id
+}
+
+// WithAPIKeyID 在context里带上当前API key的ID
+func (r *ContextRepository) WithAPIKeyID(id uint64) *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeyAPIKey, id)
+	return r
+}
+
+// scoped Middleware.Scoped是否已检查过scope
+func (r *ContextRepository) scoped() bool {
+	scoped, _ := r.context.Value(contextKeyScoped).(bool)
+	return scoped
+}
+
+// withScoped 标记Middleware.Scoped已检查过scope
+func (r *ContextRepository) withScoped() *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeyScoped, true)
+	return r
+}
+
+// Claims 在context里获取已验证的jwt claims（包括Config.EnrichClaims添加的）
+// 数字为float64；没有jwt（未登录、API key）则为nil
+func (r *ContextRepository) Claims() jwt.MapClaims {
+	claims, _ := r.context.Value(contextKeyClaims).(jwt.MapClaims)
+	return claims
+}
+
+// WithClaims 在context里带上已验证的jwt claims
+func (r *ContextRepository) WithClaims(claims jwt.MapClaims) *ContextRepository {
+	r.context = context.WithValue(r.context, contextKeyClaims, claims)
+	return r
+}
+
+// Scopes 在context里获取授权的scope（nil表示不受限，如用户自己登录颁发的jwt）
+// OAuth access token、API key只有授权的scope
+func (r *ContextRepository) Scopes() []string {
+	scopes, _ := r.context.Value(contextKeyScopes).([]string)
+	return scopes
+}
+
+// WithScopes 在context里带上授权的scope
+func (r *ContextRepository) WithScopes(scopes ...string) *ContextRepository {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	r.context = context.WithValue(r.context, contextKeyScopes, scopes)
+	return r
+}
+
+// HasScope 是否授权了全部scope（不受限的总是true）
+func (r *ContextRepository) HasScope(scopes ...string) bool {
+	granted := r.Scopes()
+	if granted == nil {
+		return true
+	}
+	owned := map[string]bool{}
+	for _, scope := range granted {
+		owned[scope] = true
+	}
+	for _, scope := range scopes {
+		if !owned[scope] {
+			return false
+		}
+	}
+	return true
+}
+
 // AttachRequest 返回 Request
 func (r *ContextRepository) AttachRequest(req *http.Request) *http.Request {
 	return req.WithContext(r.context)
 }
 
 var (
-	contextKeyUser = &contextKey{"user"}
+	contextKeyUser        = &contextKey{"user"}
+	contextKeySession     = &contextKey{"session"}
+	contextKeyRoles       = &contextKey{"roles"}
+	contextKeyPermissions = &contextKey{"permissions"}
+	contextKeyClient      = &contextKey{"client"}
+	contextKeyScopes      = &contextKey{"scopes"}
+	contextKeyAPIKey      = &contextKey{"api_key"}
+	contextKeyClaims      = &contextKey{"claims"}
+	contextKeyActor       = &contextKey{"actor"}
+	contextKeyScoped      = &contextKey{"scoped"}
 )
 
 // contextKey is a value for use with context.WithValue. It's used as
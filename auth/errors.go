@@ -2,7 +2,109 @@ package auth
 
 import (
 	"errors"
+
+	"github.com/jinzhu/gorm"
 )
 
 // ErrInvalidToken 无效的 RefreshToken
 var ErrInvalidToken = errors.New("无效的 RefreshToken")
+
+// ErrTokenReused RefreshToken 已被轮换过又再次使用（可能被盗用）
+var ErrTokenReused = errors.New("RefreshToken 已被使用，请重新登录")
+
+// ErrSessionNotFound 会话不存在（或不属于该用户）
+var ErrSessionNotFound = errors.New("会话不存在")
+
+// ErrAPIKeyNotFound API key不存在（或不属于该用户）
+var ErrAPIKeyNotFound = errors.New("API key不存在")
+
+// ErrLoginThrottled 登录失败次数过多，须等待一段时间再试
+var ErrLoginThrottled = errors.New("登录失败次数过多，请稍后再试")
+
+// ErrLoginLocked 登录失败次数过多，已被临时锁定
+var ErrLoginLocked = errors.New("登录失败次数过多，已被临时锁定")
+
+// ErrInvalidChallenge 无效或已过期的两步验证challenge
+var ErrInvalidChallenge = errors.New("两步验证已过期，请重新登录")
+
+// ErrInvalidTwoFactorCode 验证码（或恢复码）错误
+var ErrInvalidTwoFactorCode = errors.New("无效的验证码")
+
+// ErrTwoFactorEnabled 已经启用了两步验证
+var ErrTwoFactorEnabled = errors.New("已启用两步验证")
+
+// ErrTwoFactorNotEnabled 没有启用两步验证
+var ErrTwoFactorNotEnabled = errors.New("未启用两步验证")
+
+// ErrProviderNotLinkable 登录方式没有实现IdentityProvider，不支持绑定
+var ErrProviderNotLinkable = errors.New("该登录方式不支持绑定")
+
+// ErrIdentityTaken 登录凭证已绑定其他用户
+var ErrIdentityTaken = errors.New("该账号已绑定其他用户")
+
+// ErrIdentityLinked 用户已经绑定了该登录方式
+var ErrIdentityLinked = errors.New("已绑定该登录方式")
+
+// ErrIdentityNotFound 用户没有绑定该登录方式
+var ErrIdentityNotFound = errors.New("未绑定该登录方式")
+
+// ErrIdentityChanged 登录凭证在读取后被修改过（并发修改），须重试
+var ErrIdentityChanged = errors.New("登录凭证已被修改，请重试")
+
+// ErrLastIdentity 不能解绑唯一的登录方式
+var ErrLastIdentity = errors.New("不能解绑唯一的登录方式")
+
+// ErrMergeSameUser 不能合并同一个用户
+var ErrMergeSameUser = errors.New("不能合并同一个用户")
+
+// ErrMergeConflict 两个用户有相同的登录方式（如都设置了密码），无法合并
+var ErrMergeConflict = errors.New("两个用户有相同的登录方式，无法合并")
+
+// ErrImpersonateSelf 不能模拟自己
+var ErrImpersonateSelf = errors.New("不能模拟登录为自己")
+
+// ErrNotImpersonating 当前不是模拟登录
+var ErrNotImpersonating = errors.New("当前不是模拟登录")
+
+// OAuthError OAuth错误（RFC 6749），Code即响应里的error字段
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Description
+}
+
+// ErrInvalidClient client不存在或secret错误
+var ErrInvalidClient = &OAuthError{"invalid_client", "无效的client"}
+
+// ErrInvalidRedirectURI redirect_uri未登记（此时不能跳转回客户端）
+var ErrInvalidRedirectURI = &OAuthError{"invalid_request", "无效的redirect_uri"}
+
+// ErrPKCERequired 授权码流程须使用PKCE（code_challenge_method=S256）
+var ErrPKCERequired = &OAuthError{"invalid_request", "须使用PKCE（S256）"}
+
+// ErrInvalidGrant 授权码无效、已过期、已使用，或者code_verifier、redirect_uri不匹配
+var ErrInvalidGrant = &OAuthError{"invalid_grant", "无效的授权码"}
+
+// ErrInvalidScope 申请了client不允许的scope
+var ErrInvalidScope = &OAuthError{"invalid_scope", "无效的scope"}
+
+// ErrUnauthorizedClient client不允许使用该授权方式
+var ErrUnauthorizedClient = &OAuthError{"unauthorized_client", "该client不允许使用此授权方式"}
+
+// ErrUnsupportedGrantType 不支持的grant_type
+var ErrUnsupportedGrantType = &OAuthError{"unsupported_grant_type", "不支持的grant_type"}
+
+// ErrUnsupportedResponseType 不支持的response_type（只支持code）
+var ErrUnsupportedResponseType = &OAuthError{"unsupported_response_type", "不支持的response_type"}
+
+// ErrAccessDenied 用户拒绝授权
+var ErrAccessDenied = &OAuthError{"access_denied", "用户拒绝授权"}
+
+// ErrRecordNotFound 找不到记录（与gorm.ErrRecordNotFound是同一个值，便于兼容）
+var ErrRecordNotFound = gorm.ErrRecordNotFound
+
+// ErrDuplicateKey 记录已存在（由MemoryStorage返回）
+var ErrDuplicateKey = errors.New("记录已存在")
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"text/template"
+	"time"
 
 	"github.com/goodwong/go-x/auth/templates"
 )
@@ -40,16 +42,27 @@ func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 登陆逻辑
-	tokens, err := h.auth.Service.Login(provider, payload, remember, device)
+	tokens, err := h.auth.Service.WithRequest(r).Login(provider, payload, remember, device)
+	if err == ErrLoginThrottled || err == ErrLoginLocked {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
 		return
 	}
 
+	// 启用了两步验证，只返回challenge，
+	// 前端再提交验证码到HandleTwoFactorLogin
+	if tokens.Challenge != "" {
+		respondJSON(w, tokens, http.StatusOK)
+		return
+	}
+
 	// 设置cookie
-	setCookie(w, "jwt", tokens.Token, tokens.TokenExpires)
+	h.auth.setCookie(w, h.auth.jwtCookie, tokens.Token, tokens.TokenExpires)
 	if tokens.RefreshToken != nil {
-		setCookie(w, "refresh_token", *tokens.RefreshToken, *tokens.RefreshTokenExpires)
+		h.auth.setCookie(w, h.auth.refreshTokenCookie, *tokens.RefreshToken, *tokens.RefreshTokenExpires)
 	}
 
 	// 返回
@@ -73,22 +86,40 @@ func (h *Handler) HandleRenew(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 续约
-	_, tokens, err := h.auth.Service.Renew(params.RefreshToken)
+	_, tokens, err := h.auth.Service.WithRequest(r).Renew(params.RefreshToken)
 	if err != nil {
 		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
 		return
 	}
 
 	// 设置cookie
-	setCookie(w, "jwt", tokens.Token, tokens.TokenExpires)
+	h.auth.setCookie(w, h.auth.jwtCookie, tokens.Token, tokens.TokenExpires)
+	if tokens.RefreshToken != nil {
+		h.auth.setCookie(w, h.auth.refreshTokenCookie, *tokens.RefreshToken, *tokens.RefreshTokenExpires)
+	}
 
 	// 返回
 	respondJSON(w, tokens, http.StatusOK)
 }
 
 // HandleLogout 登出
+// 不能用API key、OAuth access token登出（会吊销用户全部的jwt）
+// 模拟登录中登出只结束模拟，不能登出被模拟的用户
 func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	userID := NewContext(r.Context()).UserID()
+	ctx := NewContext(r.Context())
+	if ctx.APIKeyID() != 0 || ctx.ClientID() != "" {
+		respondJSON(
+			w,
+			map[string]string{"error": http.StatusText(http.StatusForbidden)},
+			http.StatusForbidden,
+		)
+		return
+	}
+	if ctx.ActorID() != 0 {
+		h.HandleImpersonationEnd(w, r)
+		return
+	}
+	userID := ctx.UserID()
 	if userID == 0 {
 		respondJSON(w, "无需登出!", http.StatusOK)
 		return
@@ -97,17 +128,80 @@ func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// 清理数据库
 	user := &User{ID: userID}
 	device := r.URL.Query().Get("device")
-	h.auth.Service.Logout(user, device)
+	h.auth.Service.WithRequest(r).Logout(user, device)
 
 	// 清理cookie
-	deleteCookie(w, "jwt")
-	deleteCookie(w, "refresh_token")
+	h.auth.deleteCookie(w, h.auth.jwtCookie)
+	h.auth.deleteCookie(w, h.auth.refreshTokenCookie)
 
 	// 返回
 	respondJSON(w, "登出成功!", http.StatusOK)
 	return
 }
 
+// HandleLogs 查询用户行为记录（管理后台用）
+// 参数：user_id、action、since、until（RFC3339）、offset、limit（默认20，最大100）
+// 出于安全考虑，没有加入Mux，请自行添加并做好权限控制，如：
+// r.With(auths.Middleware.Authorized("admin")).Get("/api/admin/user-logs", auths.Handler.HandleLogs)
+func (h *Handler) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	// 参数
+	params := r.URL.Query()
+	query := LogQuery{Action: params.Get("action"), Limit: 20}
+	var err error
+	if v := params.Get("user_id"); v != "" {
+		if query.UserID, err = strconv.ParseUint(v, 10, 64); err != nil {
+			respondJSON(w, map[string]string{"error": "无效的user_id"}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("since"); v != "" {
+		if query.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			respondJSON(w, map[string]string{"error": "无效的since"}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if query.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			respondJSON(w, map[string]string{"error": "无效的until"}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if query.Offset, err = strconv.Atoi(v); err != nil || query.Offset < 0 {
+			respondJSON(w, map[string]string{"error": "无效的offset"}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit <= 0 || query.Limit > 100 {
+			respondJSON(w, map[string]string{"error": "无效的limit（1~100）"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 查询
+	logs, total, err := h.auth.Repository.ListLogs(query)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// 返回
+	respondJSON(w, map[string]interface{}{
+		"total": total,
+		"logs":  logs,
+	}, http.StatusOK)
+}
+
+// HandleJWKS 发布JWT公钥（JSON Web Key Set）
+// 下游服务可以据此验证jwt，如：
+// r.Get("/.well-known/jwks.json", auths.Handler.HandleJWKS)
+// 使用HS256（没有配置Config.JWTKeys）时，返回空的keys
+func (h *Handler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, h.auth.jwtKeys.JWKS(), http.StatusOK)
+}
+
 // Mux 返回多路复用器
 func (h *Handler) Mux() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HandleAPIKeys 列出我的API key
+func (h *Handler) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := &User{ID: NewContext(r.Context()).UserID()}
+	keys, err := h.auth.Service.APIKeys(user)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, keys, http.StatusOK)
+}
+
+// HandleAPIKeyCreate 创建API key
+// 参数：body: {"name": "CI", "scope": "repo:read", "expires_in": 2592000}（秒，0为永久有效）
+// 返回：{"key": {...}, "secret": "ak_..."}，secret只显示这一次
+func (h *Handler) HandleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+	var params struct {
+		Name      string `json:"name"`
+		Scope     string `json:"scope"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(payload, &params); err != nil || params.ExpiresIn < 0 {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+
+	// 创建
+	user := &User{ID: NewContext(r.Context()).UserID()}
+	expiresIn := time.Duration(params.ExpiresIn) * time.Second
+	key, secret, err := h.auth.Service.WithRequest(r).CreateAPIKey(user, params.Name, params.Scope, expiresIn)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// 返回
+	respondJSON(w, map[string]interface{}{"key": key, "secret": secret}, http.StatusOK)
+}
+
+// HandleAPIKeyRevoke 吊销API key
+// 参数：?id=API key的ID
+func (h *Handler) HandleAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "无效的id"}, http.StatusBadRequest)
+		return
+	}
+	user := &User{ID: NewContext(r.Context()).UserID()}
+	err = h.auth.Service.WithRequest(r).RevokeAPIKey(user, id)
+	if err == ErrAPIKeyNotFound {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, "吊销成功!", http.StatusOK)
+}
+
+// APIKeysMux 返回“我的API key”多路复用器（已包含ParseToken、Authenticated）
+// 只能由用户自己登录操作，不能用API key、OAuth access token、模拟登录来管理API key
+//
+//	GET    列出
+//	POST   创建
+//	DELETE ?id= 吊销
+func (h *Handler) APIKeysMux() http.Handler {
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context())
+		if ctx.APIKeyID() != 0 || ctx.ClientID() != "" || ctx.ActorID() != 0 {
+			respondJSON(
+				w,
+				map[string]string{"error": http.StatusText(http.StatusForbidden)},
+				http.StatusForbidden,
+			)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			h.HandleAPIKeys(w, r)
+
+		case "POST":
+			h.HandleAPIKeyCreate(w, r)
+
+		case "DELETE":
+			h.HandleAPIKeyRevoke(w, r)
+
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return h.auth.Middleware.ParseToken(h.auth.Middleware.Authenticated(mux))
+}
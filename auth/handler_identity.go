@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+)
+
+// IdentitiesMux 返回“绑定登录方式”多路复用器（已包含ParseToken、AuthenticatedWithUser）
+//
+//	GET    列出已绑定的登录方式
+//	POST   ?provider= 绑定，body与登录时的一样（如小程序的{"code"}）
+//	DELETE ?provider= 解绑（至少保留一种）
+//
+// 模拟登录不能修改登录方式
+func (h *Handler) IdentitiesMux() http.Handler {
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context())
+		if ctx.ActorID() != 0 {
+			respondJSON(
+				w,
+				map[string]string{"error": http.StatusText(http.StatusForbidden)},
+				http.StatusForbidden,
+			)
+			return
+		}
+		user := ctx.User()
+		service := h.auth.Service.WithRequest(r)
+		provider := r.URL.Query().Get("provider")
+
+		switch r.Method {
+		case "GET":
+			identities, err := service.Identities(user)
+			if err != nil {
+				respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, identities, http.StatusOK)
+
+		case "POST":
+			payload, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+				return
+			}
+			if err := service.Link(user, provider, payload); err != nil {
+				respondIdentityError(w, err)
+				return
+			}
+			respondJSON(w, "绑定成功!", http.StatusOK)
+
+		case "DELETE":
+			if err := service.Unlink(user, provider); err != nil {
+				respondIdentityError(w, err)
+				return
+			}
+			respondJSON(w, "解绑成功!", http.StatusOK)
+
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return h.auth.Middleware.ParseToken(h.auth.Middleware.AuthenticatedWithUser(mux))
+}
+
+// respondIdentityError 绑定、解绑的错误响应
+func respondIdentityError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrIdentityTaken, ErrIdentityLinked:
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusConflict)
+	case ErrIdentityNotFound:
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+	default:
+		// 凭证无效、不支持绑定、唯一的登录方式……
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// HandleImpersonate 管理员模拟登录为某个用户
+// 参数：?user_id=被模拟的用户ID
+// 返回jwt（没有refresh token），并设置jwt cookie；管理员的refresh token cookie保留，用于结束后恢复
+// 出于安全考虑，没有加入Mux，请自行添加并做好权限控制，如：
+// r.With(auths.Middleware.Authorized("admin")).Post("/api/admin/impersonate", auths.Handler.HandleImpersonate)
+func (h *Handler) HandleImpersonate(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r.Context())
+	// 模拟登录中不能再模拟；API key、OAuth access token也不能发起模拟
+	if ctx.UserID() == 0 || ctx.ActorID() != 0 || ctx.APIKeyID() != 0 || ctx.ClientID() != "" {
+		respondJSON(
+			w,
+			map[string]string{"error": http.StatusText(http.StatusForbidden)},
+			http.StatusForbidden,
+		)
+		return
+	}
+
+	// 参数
+	userID, err := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "无效的user_id"}, http.StatusBadRequest)
+		return
+	}
+	target, err := h.auth.Repository.Find(userID)
+	if err == ErrRecordNotFound {
+		respondJSON(w, map[string]string{"error": "用户不存在"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// 模拟
+	tokens, err := h.auth.Service.WithRequest(r).Impersonate(&User{ID: ctx.UserID()}, target)
+	if err == ErrImpersonateSelf {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// 返回
+	h.auth.setCookie(w, h.auth.jwtCookie, tokens.Token, tokens.TokenExpires)
+	respondJSON(w, tokens, http.StatusOK)
+}
+
+// HandleImpersonationEnd 结束模拟登录
+// 模拟的jwt立即失效，并删除jwt cookie，之后用管理员的refresh token续约回管理员自己
+// 被模拟的用户不一定是管理员，所以只需要ParseToken，如：
+// r.With(auths.Middleware.ParseToken).Delete("/api/impersonate", auths.Handler.HandleImpersonationEnd)
+func (h *Handler) HandleImpersonationEnd(w http.ResponseWriter, r *http.Request) {
+	actorID := NewContext(r.Context()).ActorID()
+	if actorID == 0 {
+		respondJSON(w, map[string]string{"error": ErrNotImpersonating.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := h.auth.Service.WithRequest(r).EndImpersonation(&User{ID: actorID}); err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	h.auth.deleteCookie(w, h.auth.jwtCookie)
+
+	// 返回
+	respondJSON(w, "已结束模拟登录!", http.StatusOK)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OAuthAuthorize 返回OAuth授权页处理器（已包含ParseToken）
+// 参数（query）：response_type=code、client_id、redirect_uri、scope、state、
+// code_challenge、code_challenge_method=S256
+// 用户未登录的，跳转到Config.OAuthLoginURL（没有设置则返回401）；
+// 登录后须授权确认的，由Config.OAuthConsent决定，
+// 最后跳转回redirect_uri，带上code、state（或error、error_description）
+// 须自行添加路由，如：
+// r.Get("/oauth/authorize", auths.Handler.OAuthAuthorize().ServeHTTP)
+func (h *Handler) OAuthAuthorize() http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &AuthorizeRequest{
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+		service := h.auth.Service.WithRequest(r)
+
+		// client、redirect_uri无效的，不能跳转
+		client, err := service.ValidateAuthorizeRequest(req)
+		if client == nil {
+			respondOAuthError(w, err)
+			return
+		}
+		if err == nil && query.Get("response_type") != "code" {
+			err = ErrUnsupportedResponseType
+		}
+		if err != nil {
+			redirectOAuthError(w, r, req, err)
+			return
+		}
+
+		// 登录
+		ctx := NewContext(r.Context())
+		if ctx.UserID() == 0 {
+			if h.auth.oauthLoginURL == "" {
+				respondJSON(w, map[string]string{"error": http.StatusText(http.StatusUnauthorized)}, http.StatusUnauthorized)
+				return
+			}
+			loginURL := h.auth.oauthLoginURL
+			if strings.Contains(loginURL, "?") {
+				loginURL += "&"
+			} else {
+				loginURL += "?"
+			}
+			http.Redirect(w, r, loginURL+"redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		// 须是用户自己登录，不能用OAuth access token、API key、模拟登录给client授权
+		if ctx.ClientID() != "" || ctx.APIKeyID() != 0 || ctx.ActorID() != 0 {
+			respondJSON(w, map[string]string{"error": http.StatusText(http.StatusForbidden)}, http.StatusForbidden)
+			return
+		}
+		user := ctx.User()
+		if user == nil || user.Username == "" {
+			if user, err = h.auth.Repository.Find(ctx.UserID()); err != nil {
+				respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// 授权确认，没有配置的只允许Trusted的client
+		if !client.Trusted {
+			if h.auth.oauthConsent == nil {
+				redirectOAuthError(w, r, req, ErrAccessDenied)
+				return
+			}
+			granted, err := h.auth.oauthConsent(r, user, client, parseScope(req.Scope))
+			if err != nil || len(granted) == 0 {
+				redirectOAuthError(w, r, req, ErrAccessDenied)
+				return
+			}
+			req.Scope = strings.Join(granted, " ")
+		}
+
+		// 颁发授权码
+		code, err := service.Authorize(user, req)
+		if err != nil {
+			redirectOAuthError(w, r, req, err)
+			return
+		}
+		redirectOAuth(w, r, req, url.Values{"code": {code}})
+	})
+	return h.auth.Middleware.ParseToken(handler)
+}
+
+// HandleOAuthToken OAuth token端点
+// 参数（POST表单）：
+//
+//	grant_type=authorization_code&code=&redirect_uri=&code_verifier=
+//	grant_type=client_credentials&scope=
+//
+// client认证：HTTP Basic，或者表单里的client_id、client_secret（公开客户端只须client_id）
+// 须自行添加路由，如：
+// r.Post("/oauth/token", auths.Handler.HandleOAuthToken)
+func (h *Handler) HandleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondJSON(w, map[string]string{"error": "invalid_request", "error_description": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+
+	// client认证
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 2.3.1：Basic里的client_id、client_secret是经过表单编码的
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	service := h.auth.Service.WithRequest(r)
+	var tokens *OAuthTokenResponse
+	var err error
+	switch r.PostForm.Get("grant_type") {
+	case GrantAuthorizationCode:
+		tokens, err = service.ExchangeCode(
+			clientID, clientSecret,
+			r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"),
+		)
+	case GrantClientCredentials:
+		tokens, err = service.ClientCredentials(clientID, clientSecret, r.PostForm.Get("scope"))
+	default:
+		err = ErrUnsupportedGrantType
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err != nil {
+		if err == ErrInvalidClient && basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		respondOAuthError(w, err)
+		return
+	}
+	respondJSON(w, tokens, http.StatusOK)
+}
+
+// respondOAuthError OAuth错误响应：{"error", "error_description"}
+func respondOAuthError(w http.ResponseWriter, err error) {
+	oauthErr, ok := err.(*OAuthError)
+	if !ok {
+		respondJSON(w, map[string]string{"error": "server_error", "error_description": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr == ErrInvalidClient {
+		status = http.StatusUnauthorized
+	}
+	respondJSON(w, map[string]string{"error": oauthErr.Code, "error_description": oauthErr.Description}, status)
+}
+
+// redirectOAuthError 跳转回客户端，带上error、error_description
+func redirectOAuthError(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, err error) {
+	oauthErr, ok := err.(*OAuthError)
+	if !ok {
+		oauthErr = &OAuthError{"server_error", err.Error()}
+	}
+	redirectOAuth(w, r, req, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}})
+}
+
+// redirectOAuth 跳转回redirect_uri，带上参数和state
+func redirectOAuth(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, params url.Values) {
+	redirectURI, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		respondOAuthError(w, ErrInvalidRedirectURI)
+		return
+	}
+	query := redirectURI.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
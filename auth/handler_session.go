@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// HandleSessions 列出我的会话
+func (h *Handler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r.Context())
+	user := &User{ID: ctx.UserID()}
+
+	sessions, err := h.auth.Service.Sessions(user, ctx.SessionID())
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// 返回
+	respondJSON(w, sessions, http.StatusOK)
+}
+
+// HandleSessionRename 修改会话备注
+// 参数：?id=会话ID，body: {"remark": "家"}
+func (h *Handler) HandleSessionRename(w http.ResponseWriter, r *http.Request) {
+	// 参数
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "无效的id"}, http.StatusBadRequest)
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+	var params struct {
+		Remark string `json:"remark"`
+	}
+	if err := json.Unmarshal(payload, &params); err != nil {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+
+	// 修改
+	user := &User{ID: NewContext(r.Context()).UserID()}
+	if err := h.auth.Service.RenameSession(user, id, params.Remark); err != nil {
+		respondSessionError(w, err)
+		return
+	}
+
+	// 返回
+	respondJSON(w, "修改成功!", http.StatusOK)
+}
+
+// HandleSessionRevoke 注销会话
+// 参数：?id=会话ID 注销某个会话；?others=1 注销除当前会话以外的所有会话
+func (h *Handler) HandleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r.Context())
+	user := &User{ID: ctx.UserID()}
+	service := h.auth.Service.WithRequest(r)
+
+	// 其他所有会话
+	switch r.URL.Query().Get("others") {
+	case "1", "true":
+		if err := service.RevokeOtherSessions(user, ctx.SessionID()); err != nil {
+			respondSessionError(w, err)
+			return
+		}
+		respondJSON(w, "注销成功!", http.StatusOK)
+		return
+	}
+
+	// 某个会话
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "无效的id"}, http.StatusBadRequest)
+		return
+	}
+	if err := service.RevokeSession(user, id); err != nil {
+		respondSessionError(w, err)
+		return
+	}
+	// 注销的是当前会话，顺便清理cookie
+	if id == ctx.SessionID() {
+		h.auth.deleteCookie(w, h.auth.refreshTokenCookie)
+	}
+
+	// 返回
+	respondJSON(w, "注销成功!", http.StatusOK)
+}
+
+// SessionsMux 返回“我的会话”多路复用器（已包含ParseToken、Authenticated）
+//
+//	GET    列出
+//	PATCH  ?id= 修改备注
+//	DELETE ?id= 注销某个会话；?others=1 注销其他所有会话
+//
+// 模拟登录不能管理会话
+func (h *Handler) SessionsMux() http.Handler {
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if NewContext(r.Context()).ActorID() != 0 {
+			respondJSON(
+				w,
+				map[string]string{"error": http.StatusText(http.StatusForbidden)},
+				http.StatusForbidden,
+			)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			h.HandleSessions(w, r)
+
+		case "PATCH":
+			h.HandleSessionRename(w, r)
+
+		case "DELETE":
+			h.HandleSessionRevoke(w, r)
+
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return h.auth.Middleware.ParseToken(h.auth.Middleware.Authenticated(mux))
+}
+
+// respondSessionError 会话操作的错误响应
+func respondSessionError(w http.ResponseWriter, err error) {
+	if err == ErrSessionNotFound {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+		return
+	}
+	respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+}
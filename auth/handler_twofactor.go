@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// HandleTwoFactorLogin 登录第二步：challenge + 验证码换取token
+// 参数：body: {"challenge": "...", "code": "123456"}（code也可以是恢复码）
+// 须自行添加路由，如：
+// r.Post("/api/login/2fa", auths.Handler.HandleTwoFactorLogin)
+func (h *Handler) HandleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	// 参数
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+	var params struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.Unmarshal(payload, &params); err != nil {
+		respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+		return
+	}
+
+	// 验证
+	tokens, err := h.auth.Service.WithRequest(r).VerifyTwoFactor(params.Challenge, params.Code)
+	if err == ErrLoginThrottled || err == ErrLoginLocked {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// 设置cookie
+	h.auth.setCookie(w, h.auth.jwtCookie, tokens.Token, tokens.TokenExpires)
+	if tokens.RefreshToken != nil {
+		h.auth.setCookie(w, h.auth.refreshTokenCookie, *tokens.RefreshToken, *tokens.RefreshTokenExpires)
+	}
+
+	// 返回
+	respondJSON(w, tokens, http.StatusOK)
+}
+
+// TwoFactorMux 返回“两步验证设置”多路复用器（已包含ParseToken、AuthenticatedWithUser）
+//
+//	GET    是否已启用：{"enabled": true}
+//	POST   开始启用，返回 {"secret", "uri"}
+//	PUT    body: {"code"} 确认启用，返回 {"recovery_codes"}
+//	PATCH  body: {"code"} 重新生成恢复码，返回 {"recovery_codes"}
+//	DELETE body: {"code"} 停用
+//
+// 模拟登录不能修改两步验证
+func (h *Handler) TwoFactorMux() http.Handler {
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context())
+		if ctx.ActorID() != 0 {
+			respondJSON(
+				w,
+				map[string]string{"error": http.StatusText(http.StatusForbidden)},
+				http.StatusForbidden,
+			)
+			return
+		}
+		user := ctx.User()
+		service := h.auth.Service.WithRequest(r)
+
+		switch r.Method {
+		case "GET":
+			enabled, err := service.TwoFactorEnabled(user)
+			if err != nil {
+				respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, map[string]bool{"enabled": enabled}, http.StatusOK)
+
+		case "POST":
+			secret, uri, err := service.EnrollTOTP(user)
+			if err != nil {
+				respondTwoFactorError(w, err)
+				return
+			}
+			respondJSON(w, map[string]string{"secret": secret, "uri": uri}, http.StatusOK)
+
+		case "PUT", "PATCH", "DELETE":
+			payload, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+				return
+			}
+			var params struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(payload, &params); err != nil {
+				respondJSON(w, map[string]string{"error": "request body读取错误"}, http.StatusBadRequest)
+				return
+			}
+
+			if r.Method == "DELETE" {
+				if err := service.DisableTOTP(user, params.Code); err != nil {
+					respondTwoFactorError(w, err)
+					return
+				}
+				respondJSON(w, "已停用两步验证!", http.StatusOK)
+				return
+			}
+
+			var recoveryCodes []string
+			if r.Method == "PUT" {
+				recoveryCodes, err = service.ConfirmTOTP(user, params.Code)
+			} else {
+				recoveryCodes, err = service.RegenerateRecoveryCodes(user, params.Code)
+			}
+			if err != nil {
+				respondTwoFactorError(w, err)
+				return
+			}
+			respondJSON(w, map[string][]string{"recovery_codes": recoveryCodes}, http.StatusOK)
+
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return h.auth.Middleware.ParseToken(h.auth.Middleware.AuthenticatedWithUser(mux))
+}
+
+// respondTwoFactorError 两步验证设置的错误响应
+func respondTwoFactorError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrLoginThrottled, ErrLoginLocked:
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusTooManyRequests)
+	case ErrInvalidTwoFactorCode, ErrTwoFactorEnabled, ErrTwoFactorNotEnabled:
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+	default:
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+	}
+}
@@ -15,21 +15,27 @@ func respondJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 }
 
 // deleteCookie 删除cookie
-func deleteCookie(w http.ResponseWriter, name string) {
+func (auth *Auth) deleteCookie(w http.ResponseWriter, name string) {
 	http.SetCookie(w, &http.Cookie{
 		HttpOnly: true,
 		Name:     name,
 		Path:     "/",
+		Domain:   auth.cookieDomain,
+		Secure:   auth.cookieSecure,
+		SameSite: auth.cookieSameSite,
 		MaxAge:   -1,
 	})
 }
 
 // setCookie 设置cookie
-func setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+func (auth *Auth) setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		HttpOnly: true,
 		Name:     name,
 		Path:     "/",
+		Domain:   auth.cookieDomain,
+		Secure:   auth.cookieSecure,
+		SameSite: auth.cookieSameSite,
 		Value:    value,
 		Expires:  expires,
 	})
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+)
+
+// JWTKey JWT签名密钥（非对称）
+//
+// 轮换步骤（不停机）：
+//  1. 新key只配PublicKey（或放在后面），先在JWKS里发布，等下游服务缓存更新
+//  2. 新key放到第一位，开始用它签发；旧key去掉PrivateKey，继续用于验证
+//  3. 等旧key签发的jwt全部过期后，移除旧key
+type JWTKey struct {
+	ID         string           // kid，须唯一
+	Algorithm  string           // RS256、RS384、RS512、PS256、ES256、ES384、ES512、EdDSA
+	PrivateKey crypto.Signer    // *rsa.PrivateKey、*ecdsa.PrivateKey、ed25519.PrivateKey；只用于验证的key可以为空
+	PublicKey  crypto.PublicKey // 为空则从PrivateKey获取
+}
+
+// jwtKey 解析后的密钥
+type jwtKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// jwtKeys JWT签发、验证
+type jwtKeys struct {
+	signing  *jwtKey
+	keys     map[string]*jwtKey // kid => key
+	list     []*jwtKey          // 保持配置顺序，用于JWKS
+	now      func() time.Time   // 验证exp、nbf、iat用的时钟
+	issuer   string             // 不为空则签发时写入iss，验证时检查
+	audience string             // 不为空则签发时写入aud，验证时检查
+}
+
+// reservedClaims 由auth签发、验证的claims，Config.EnrichClaims不能覆盖
+var reservedClaims = map[string]bool{
+	"iat": true, "exp": true, "nbf": true, "sub": true, "sid": true,
+	"iss": true, "aud": true, "jti": true, "client_id": true, "scope": true, "act": true,
+}
+
+// newJWTKeys 创建
+// 没有配置非对称密钥时，使用secretKey做HS256签名（kid为空）
+func newJWTKeys(secretKey []byte, keys []JWTKey) *jwtKeys {
+	k := &jwtKeys{keys: map[string]*jwtKey{}, now: time.Now}
+	if len(keys) == 0 {
+		k.signing = &jwtKey{
+			method:    jwt.SigningMethodHS256,
+			signKey:   secretKey,
+			verifyKey: secretKey,
+		}
+		k.keys[""] = k.signing
+		return k
+	}
+
+	for _, config := range keys {
+		key, err := parseJWTKey(config)
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := k.keys[key.id]; ok {
+			panic(fmt.Sprintf("JWTKey kid重复: %s", key.id))
+		}
+		k.keys[key.id] = key
+		k.list = append(k.list, key)
+		if k.signing == nil && key.signKey != nil {
+			k.signing = key
+		}
+	}
+	if k.signing == nil {
+		panic("JWTKeys 至少需要一个带PrivateKey的key用于签发")
+	}
+	return k
+}
+
+// parseJWTKey 检查算法和密钥类型是否匹配
+func parseJWTKey(config JWTKey) (*jwtKey, error) {
+	if config.ID == "" {
+		return nil, errors.New("JWTKey 缺少ID(kid)")
+	}
+	method := jwt.GetSigningMethod(config.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("JWTKey(%s) 不支持的算法: %s", config.ID, config.Algorithm)
+	}
+	publicKey := config.PublicKey
+	if publicKey == nil && config.PrivateKey != nil {
+		publicKey = config.PrivateKey.Public()
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("JWTKey(%s) 缺少PrivateKey或PublicKey", config.ID)
+	}
+
+	key := &jwtKey{id: config.ID, method: method}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if publicKey, ok := publicKey.(*rsa.PublicKey); ok {
+			key.verifyKey = publicKey
+		}
+		if privateKey, ok := config.PrivateKey.(*rsa.PrivateKey); ok {
+			key.signKey = privateKey
+		}
+	case *jwt.SigningMethodECDSA:
+		curveBits := method.(*jwt.SigningMethodECDSA).CurveBits
+		if publicKey, ok := publicKey.(*ecdsa.PublicKey); ok && publicKey.Curve.Params().BitSize == curveBits {
+			key.verifyKey = publicKey
+		}
+		if privateKey, ok := config.PrivateKey.(*ecdsa.PrivateKey); ok {
+			key.signKey = privateKey
+		}
+	case *signingMethodEdDSA:
+		if publicKey, ok := publicKey.(ed25519.PublicKey); ok {
+			key.verifyKey = publicKey
+		}
+		if privateKey, ok := config.PrivateKey.(ed25519.PrivateKey); ok {
+			key.signKey = privateKey
+		}
+	default:
+		return nil, fmt.Errorf("JWTKey(%s) 只支持非对称算法: %s", config.ID, config.Algorithm)
+	}
+	if key.verifyKey == nil || (config.PrivateKey != nil && key.signKey == nil) {
+		return nil, fmt.Errorf("JWTKey(%s) 密钥类型与算法%s不匹配", config.ID, config.Algorithm)
+	}
+	return key, nil
+}
+
+// Encode 签发
+// MapClaims会带上配置的iss、aud
+func (k *jwtKeys) Encode(claims jwt.Claims) (tokenString string, err error) {
+	if claims, ok := claims.(jwt.MapClaims); ok {
+		if k.issuer != "" {
+			claims["iss"] = k.issuer
+		}
+		if k.audience != "" {
+			claims["aud"] = k.audience
+		}
+	}
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	if k.signing.id != "" {
+		token.Header["kid"] = k.signing.id
+	}
+	return token.SignedString(k.signing.signKey)
+}
+
+// Decode 验证并解析
+// 按header里的kid选择密钥，并且算法必须与密钥一致（防止算法混淆攻击）
+// exp、nbf、iat按k.now验证；配置了iss、aud的，也一并验证
+func (k *jwtKeys) Decode(tokenString string) (*jwt.Token, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("未知的kid: %s", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("算法不匹配: %s", token.Method.Alg())
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return token, err
+	}
+	if err = k.validate(token.Claims.(jwt.MapClaims)); err != nil {
+		token.Valid = false
+		return token, err
+	}
+	return token, nil
+}
+
+// validate 验证时间相关的claims，以及iss、aud
+func (k *jwtKeys) validate(claims jwt.MapClaims) error {
+	now := k.now().Unix()
+	if !claims.VerifyExpiresAt(now, false) {
+		return jwt.NewValidationError("token已过期", jwt.ValidationErrorExpired)
+	}
+	if !claims.VerifyIssuedAt(now, false) {
+		return jwt.NewValidationError("token签发时间无效", jwt.ValidationErrorIssuedAt)
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return jwt.NewValidationError("token尚未生效", jwt.ValidationErrorNotValidYet)
+	}
+	if k.issuer != "" && !claims.VerifyIssuer(k.issuer, true) {
+		return jwt.NewValidationError("token签发方不匹配", jwt.ValidationErrorIssuer)
+	}
+	if k.audience != "" && !containsAudience(claims["aud"], k.audience) {
+		return jwt.NewValidationError("token接收方不匹配", jwt.ValidationErrorAudience)
+	}
+	return nil
+}
+
+// containsAudience aud可以是字符串，也可以是数组
+// （jwt-go v3的VerifyAudience只支持字符串）
+func containsAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a, ok := a.(string); ok && a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FromRequest 从请求中获取jwt并验证
+// 依次查找：?jwt=、Authorization: BEARER、cookie（名称为cookieName）
+func (k *jwtKeys) FromRequest(r *http.Request, cookieName string) (*jwt.Token, error) {
+	var tokenString string
+	for _, find := range []func(r *http.Request) string{
+		jwtauth.TokenFromQuery,
+		jwtauth.TokenFromHeader,
+		func(r *http.Request) string {
+			cookie, err := r.Cookie(cookieName)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		},
+	} {
+		if tokenString = find(r); tokenString != "" {
+			break
+		}
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+	return k.Decode(tokenString)
+}
+
+// JWKS 公钥集合（RFC 7517），只包含非对称密钥
+func (k *jwtKeys) JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+	for _, key := range k.list {
+		jwk := map[string]string{
+			"kid": key.id,
+			"alg": key.method.Alg(),
+			"use": "sig",
+		}
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			jwk["kty"] = "EC"
+			jwk["crv"] = publicKey.Curve.Params().Name
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.X.Bytes(), size))
+			jwk["y"] = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.Y.Bytes(), size))
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// padBytes 左侧补0到指定长度
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// signingMethodEdDSA Ed25519签名（jwt-go v3 没有内置）
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return &signingMethodEdDSA{}
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestJWTKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, config := range []JWTKey{
+		{ID: "rsa", Algorithm: "RS256", PrivateKey: rsaKey},
+		{ID: "ec", Algorithm: "ES256", PrivateKey: ecKey},
+		{ID: "ed", Algorithm: "EdDSA", PrivateKey: edKey},
+	} {
+		keys := newJWTKeys(nil, []JWTKey{config})
+		tokenString, err := keys.Encode(jwt.MapClaims{"sub": 1})
+		if err != nil {
+			t.Fatalf("%s 签发失败：%s", config.Algorithm, err)
+		}
+		token, err := keys.Decode(tokenString)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s 验证失败：%s", config.Algorithm, err)
+		}
+		if token.Header["kid"] != config.ID {
+			t.Fatalf("%s kid不对：%v", config.Algorithm, token.Header["kid"])
+		}
+	}
+}
+
+func TestJWTKeysRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// 旧key签发
+	before := newJWTKeys(nil, []JWTKey{{ID: "old", Algorithm: "ES256", PrivateKey: oldKey}})
+	oldToken, _ := before.Encode(jwt.MapClaims{"sub": 1})
+
+	// 轮换：新key签发，旧key只验证
+	after := newJWTKeys(nil, []JWTKey{
+		{ID: "new", Algorithm: "ES256", PrivateKey: newKey},
+		{ID: "old", Algorithm: "ES256", PublicKey: &oldKey.PublicKey},
+	})
+	newToken, _ := after.Encode(jwt.MapClaims{"sub": 1})
+	if _, err := after.Decode(oldToken); err != nil {
+		t.Fatalf("轮换期间，旧key签发的jwt理应有效：%s", err)
+	}
+	if _, err := after.Decode(newToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := before.Decode(newToken); err == nil {
+		t.Fatal("未知kid理应验证失败")
+	}
+
+	// HS256 不能冒充
+	hmac := newJWTKeys([]byte("secret"), nil)
+	forged, _ := hmac.Encode(jwt.MapClaims{"sub": 1})
+	if _, err := after.Decode(forged); err == nil {
+		t.Fatal("没有kid的HS256 jwt理应验证失败")
+	}
+
+	// 算法与密钥不匹配
+	defer func() {
+		if recover() == nil {
+			t.Fatal("算法与密钥不匹配理应panic")
+		}
+	}()
+	newJWTKeys(nil, []JWTKey{{ID: "bad", Algorithm: "RS256", PrivateKey: newKey}})
+}
+
+func TestHandleJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	auth := New(Config{JWTKeys: []JWTKey{
+		{ID: "rsa", Algorithm: "RS256", PrivateKey: rsaKey},
+		{ID: "ed", Algorithm: "EdDSA", PublicKey: edKey},
+	}})
+	defer auth.Close()
+
+	w := httptest.NewRecorder()
+	auth.Handler.HandleJWKS(w, httptest.NewRequest("GET", "http://localhost/.well-known/jwks.json", nil))
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("理应有2个key：%+v", jwks)
+	}
+	if jwks.Keys[0]["kty"] != "RSA" || jwks.Keys[0]["e"] != "AQAB" {
+		t.Fatalf("RSA key不对：%+v", jwks.Keys[0])
+	}
+	if jwks.Keys[1]["kty"] != "OKP" || jwks.Keys[1]["crv"] != "Ed25519" {
+		t.Fatalf("Ed25519 key不对：%+v", jwks.Keys[1])
+	}
+}
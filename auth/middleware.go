@@ -1,10 +1,10 @@
 package auth
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 
-	"github.com/jinzhu/gorm"
-
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/jwtauth"
 )
@@ -21,19 +21,47 @@ type Middleware struct {
 
 // ParseToken 解析Token
 // 解析token，在r.Context()里带上userID
+// 也接受API key（Authorization: Bearer ak_...），带上userID、API key的ID和scope
 // 如果没有登录，也不会强制要求登陆
 // 如果需要强制要求登陆，
 // 需要后面再加`Authenticated` 或 `AuthenticatedWithUser`
 func (m *Middleware) ParseToken(next http.Handler) http.Handler {
 	check := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token, _, err := jwtauth.FromContext(r.Context())
+			// API key（只从header读取，不要放在URL里）
+			if bearer := jwtauth.TokenFromHeader(r); isAPIKey(bearer) {
+				m.parseAPIKey(next, w, r, bearer)
+				return
+			}
+
+			token, err := m.auth.jwtKeys.FromRequest(r, m.auth.jwtCookie)
+			// 保持与jwtauth.Verifier一致，下游仍可用jwtauth.FromContext()
+			r = r.WithContext(jwtauth.NewContext(r.Context(), token, err))
 			// jwt_token有效，直接过！
 			if err == nil && token != nil && token.Valid && m.auth.Service.JwtInvalid(token) == false {
 				// 带上userID继续
 				claims := token.Claims.(jwt.MapClaims)
-				userID := uint64(claims["sub"].(float64))
-				ctx := NewContext(r.Context()).WithUserID(userID)
+				ctx := NewContext(r.Context()).WithClaims(claims)
+				if userID, ok := claims["sub"].(float64); ok {
+					ctx.WithUserID(uint64(userID))
+				}
+				if sessionID, ok := claims["sid"].(float64); ok {
+					ctx.WithSessionID(uint64(sessionID))
+				}
+				// OAuth access token，带上client、scope
+				if clientID, ok := claims["client_id"].(string); ok {
+					scope, _ := claims["scope"].(string)
+					ctx.WithClientID(clientID).WithScopes(parseScope(scope)...)
+				}
+				// 模拟登录，带上管理员ID，并记录每一个请求
+				if actorID := actorFromClaims(claims); actorID != 0 {
+					ctx.WithActorID(actorID)
+					m.auth.Service.WithRequest(r).record(
+						ctx.UserID(),
+						ActionImpersonatedRequest,
+						fmt.Sprintf("admin: %d, %s %s", actorID, r.Method, r.URL.Path),
+					)
+				}
 				next.ServeHTTP(w, ctx.AttachRequest(r))
 				return
 			}
@@ -41,7 +69,7 @@ func (m *Middleware) ParseToken(next http.Handler) http.Handler {
 			// jwt token无效，
 			// 此时试图用refresh_token来续约jwt
 			// 如果refresh_token 无效！让用户重新登录
-			cookie, _ := r.Cookie("refresh_token")
+			cookie, _ := r.Cookie(m.auth.refreshTokenCookie)
 			if cookie == nil || cookie.Value == "" {
 				// 无效的refresh_token
 				next.ServeHTTP(w, r)
@@ -49,7 +77,7 @@ func (m *Middleware) ParseToken(next http.Handler) http.Handler {
 			}
 
 			// 续约
-			user, tokens, err := m.auth.Service.Renew(cookie.Value)
+			user, tokens, err := m.auth.Service.WithRequest(r).Renew(cookie.Value)
 			if err != nil {
 				// 续约失败
 				next.ServeHTTP(w, r)
@@ -58,23 +86,47 @@ func (m *Middleware) ParseToken(next http.Handler) http.Handler {
 
 			// 成功续约！
 			// 设置cookie
-			setCookie(w, "jwt", tokens.Token, tokens.TokenExpires)
+			m.auth.setCookie(w, m.auth.jwtCookie, tokens.Token, tokens.TokenExpires)
+			if tokens.RefreshToken != nil {
+				m.auth.setCookie(w, m.auth.refreshTokenCookie, *tokens.RefreshToken, *tokens.RefreshTokenExpires)
+			}
 
 			// 带上userID继续
-			ctx := NewContext(r.Context()).WithUser(user)
+			ctx := NewContext(r.Context()).WithUser(user).WithSessionID(tokens.SessionID)
+			if token, err := m.auth.jwtKeys.Decode(tokens.Token); err == nil {
+				ctx.WithClaims(token.Claims.(jwt.MapClaims))
+			}
 			next.ServeHTTP(w, ctx.AttachRequest(r))
 		})
 	}
-	return jwtauth.Verifier(m.auth.jwtauth)(check(next))
+	return check(next)
+}
+
+// parseAPIKey 验证API key，无效的当作未登录（不会再用refresh_token续约）
+func (m *Middleware) parseAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, secret string) {
+	key, err := m.auth.Repository.FindAPIKey(secret)
+	if err != nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	if err := m.auth.Repository.touchAPIKey(key); err != nil {
+		log.Printf("auth: 更新API key使用时间失败: %s", err)
+	}
+	ctx := NewContext(r.Context()).
+		WithUserID(key.UserID).
+		WithAPIKeyID(key.ID).
+		WithScopes(parseScope(key.Scopes)...)
+	next.ServeHTTP(w, ctx.AttachRequest(r))
 }
 
 // Authenticated 验证已登陆
+// OAuth access token、API key只能访问前面加了Scoped的路由，否则返回403（它们带有用户，但不是用户自己登录）
 func (m *Middleware) Authenticated(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := NewContext(r.Context())
 		if ctx.UserID() == 0 {
-			deleteCookie(w, "jwt")
-			deleteCookie(w, "refresh_token")
+			m.auth.deleteCookie(w, m.auth.jwtCookie)
+			m.auth.deleteCookie(w, m.auth.refreshTokenCookie)
 			respondJSON(
 				w,
 				map[string]string{"error": http.StatusText(http.StatusUnauthorized)},
@@ -82,6 +134,14 @@ func (m *Middleware) Authenticated(next http.Handler) http.Handler {
 			)
 			return
 		}
+		if (ctx.ClientID() != "" || ctx.APIKeyID() != 0) && !ctx.scoped() {
+			respondJSON(
+				w,
+				map[string]string{"error": http.StatusText(http.StatusForbidden)},
+				http.StatusForbidden,
+			)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -106,9 +166,9 @@ func (m *Middleware) AuthenticatedWithUser(next http.Handler) http.Handler {
 			// token验证通过，但是数据库找不到人
 			// 按照失败处理：
 			// 清理cookie
-			if err == gorm.ErrRecordNotFound {
-				deleteCookie(w, "jwt")
-				deleteCookie(w, "refresh_token")
+			if err == ErrRecordNotFound {
+				m.auth.deleteCookie(w, m.auth.jwtCookie)
+				m.auth.deleteCookie(w, m.auth.refreshTokenCookie)
 			}
 			if err != nil {
 				respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
@@ -124,15 +184,96 @@ func (m *Middleware) AuthenticatedWithUser(next http.Handler) http.Handler {
 }
 
 // Authorized 必须是XX角色之一
-//func (m *Middleware) Authorized(roles ...string) func(http.Handler) http.Handler {
-//	return func(next http.Handler) http.Handler {
-//		return func(next http.Handler) http.Handler {
-//			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-//				if role in roles {
-//					...
-//				}
-//				next.ServeHTTP(w, r)
-//			})
-//		}(next)
-//	}
-//}
+// 角色会从数据库加载，并附着在r.Context()里面
+// 已登录但不具备角色的，返回403
+func (m *Middleware) Authorized(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		check := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := NewContext(r.Context())
+
+			// load
+			if ctx.Roles() == nil {
+				owned, err := m.auth.Repository.FindRolesByUser(ctx.UserID())
+				if err != nil {
+					respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+					return
+				}
+				ctx.WithRoles(owned...)
+			}
+
+			// check
+			if !ctx.HasRole(roles...) {
+				respondJSON(
+					w,
+					map[string]string{"error": http.StatusText(http.StatusForbidden)},
+					http.StatusForbidden,
+				)
+				return
+			}
+			next.ServeHTTP(w, ctx.AttachRequest(r))
+		})
+		return m.Authenticated(check)
+	}
+}
+
+// Can 必须拥有全部XX权限
+// 权限通过用户的角色从数据库加载，并附着在r.Context()里面
+// 已登录但不具备权限的，返回403
+func (m *Middleware) Can(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		check := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := NewContext(r.Context())
+
+			// load
+			if ctx.Permissions() == nil {
+				owned, err := m.auth.Repository.FindPermissionsByUser(ctx.UserID())
+				if err != nil {
+					respondJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+					return
+				}
+				ctx.WithPermissions(owned...)
+			}
+
+			// check
+			if !ctx.Can(permissions...) {
+				respondJSON(
+					w,
+					map[string]string{"error": http.StatusText(http.StatusForbidden)},
+					http.StatusForbidden,
+				)
+				return
+			}
+			next.ServeHTTP(w, ctx.AttachRequest(r))
+		})
+		return m.Authenticated(check)
+	}
+}
+
+// Scoped OAuth access token、API key须授权了全部XX scope
+// 用户自己登录颁发的jwt不受限；未登录（也没有OAuth access token）的，返回401
+// OAuth access token、API key只能访问加了Scoped的路由：须放在Authenticated、AuthenticatedWithUser等的前面
+// client_credentials颁发的token没有用户，后面不要再加Authenticated
+func (m *Middleware) Scoped(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := NewContext(r.Context())
+			if ctx.UserID() == 0 && ctx.ClientID() == "" {
+				respondJSON(
+					w,
+					map[string]string{"error": http.StatusText(http.StatusUnauthorized)},
+					http.StatusUnauthorized,
+				)
+				return
+			}
+			if !ctx.HasScope(scopes...) {
+				respondJSON(
+					w,
+					map[string]string{"error": http.StatusText(http.StatusForbidden)},
+					http.StatusForbidden,
+				)
+				return
+			}
+			next.ServeHTTP(w, ctx.withScoped().AttachRequest(r))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+)
+
+// CSRFHeader 提交csrf token的请求头
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRF 防跨站请求伪造（double-submit cookie）
+// 用法：放在ParseToken、Handler.Mux前面，如：
+// r.Use(auths.Middleware.CSRF, auths.Middleware.ParseToken)
+//
+// 没有csrf cookie时，生成一个（非HttpOnly，前端js可以读取）
+// 带着jwt或refresh_token cookie的非安全请求（POST、PUT、PATCH、DELETE……），
+// 必须在X-CSRF-Token头里带上csrf cookie的值，否则返回403
+// 没有带登录cookie的请求（如通过Authorization头认证的API）不受影响
+func (m *Middleware) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 确保有csrf cookie
+		expected := ""
+		if cookie, err := r.Cookie(m.auth.csrfCookie); err == nil {
+			expected = cookie.Value
+		}
+		if expected == "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     m.auth.csrfCookie,
+				Value:    newCSRFToken(),
+				Path:     "/",
+				Domain:   m.auth.cookieDomain,
+				Secure:   m.auth.cookieSecure,
+				SameSite: m.auth.cookieSameSite,
+				Expires:  m.auth.now().Add(m.auth.refreshTokenLife),
+			})
+		}
+
+		// 安全请求、不是通过cookie认证的请求，直接过
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !m.hasSessionCookie(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 校验
+		actual := r.Header.Get(CSRFHeader)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 {
+			respondJSON(w, map[string]string{"error": "CSRF校验失败"}, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasSessionCookie 是否带有登录cookie
+func (m *Middleware) hasSessionCookie(r *http.Request) bool {
+	for _, name := range []string{m.auth.jwtCookie, m.auth.refreshTokenCookie} {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// newCSRFToken 生成随机csrf token
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix API key的前缀，ParseToken据此区分API key和jwt
+const apiKeyPrefix = "ak_"
+
+// APIKey 个人访问令牌（给脚本、CI用，长期有效，可随时吊销）
+// 只保存sha256，key本身只在创建时显示一次
+type APIKey struct {
+	ID         uint64     `json:"id"`
+	UserID     uint64     `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // key的前几位，方便用户辨认
+	Hash       string     `json:"-" gorm:"unique_index"`
+	Scopes     string     `json:"scopes"`     // 空格分隔，与OAuth的scope一致
+	ExpiredAt  *time.Time `json:"expired_at"` // 为空则永久有效
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName 指定数据表名(gorm)
+func (k *APIKey) TableName() string {
+	return "user_api_keys"
+}
+
+// Expired 是否已过期
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiredAt != nil && !now.Before(*k.ExpiredAt)
+}
+
+// isAPIKey 是否API key（而不是jwt）
+func isAPIKey(s string) bool {
+	return strings.HasPrefix(s, apiKeyPrefix)
+}
@@ -8,38 +8,51 @@ import (
 	"io"
 	"time"
 
-	"github.com/goodwong/go-x/crypto"
-
 	"golang.org/x/crypto/bcrypt"
 )
 
-// DefaultRefreshTokenLife 默认token有效时长
-var DefaultRefreshTokenLife = 365 * 24 * time.Hour // 1 Year
-
 // Usage:
-// token := newToken(Token{UserID:13, Device:"web", Remark:"浏览器"}, KEY)
-func newToken(params Token, secretKey []byte) *Token {
+// token := newToken(Token{UserID:13, Device:"web", Remark:"浏览器"}, keys)
+//
+// params.IssuedAt为空则使用当前时间，
+// params.ExpiredAt为空则使用DefaultRefreshTokenLife
+func newToken(params Token, keys *tokenKeys) *Token {
 	if params.UserID == 0 {
 		panic("params.UserID未设置")
 	}
 	if len(params.Device) == 0 {
 		panic("params.Device未设置")
 	}
-	now := time.Now()
+	now := params.IssuedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
 	t := &Token{
 		UserID: params.UserID,
 		Device: params.Device,
 		Remark: params.Remark,
+		Family: params.Family,
+	}
+	// 轮换出来的token沿用原来的family，否则开启新的family
+	if t.Family == "" {
+		family := make([]byte, 12)
+		if _, err := io.ReadFull(rand.Reader, family); err != nil {
+			panic(err.Error())
+		}
+		t.Family = base64.RawURLEncoding.EncodeToString(family)
 	}
 	t.IssuedAt = now
-	t.ExpiredAt = now.Add(DefaultRefreshTokenLife)
+	t.ExpiredAt = params.ExpiredAt
+	if t.ExpiredAt.IsZero() {
+		t.ExpiredAt = now.Add(DefaultRefreshTokenLife)
+	}
 	// 生成12位随机数
 	// bcrypt调试到最低1ms左右
 	// 因为是按照字节随机的无规律，充分利用每字节128种可见字符可能性，无法做生日攻击
 	// 所以12位随机是: 2**(7*12) = 3.8E28
 	// 假设每次1ms，则1s内可以运行1000次
 	// 破解时间： 2**(8*12)/(1000*86400*365) = 1.2E18 年
-	t.secretKey = secretKey
+	t.keys = keys
 	t.nonce = make([]byte, 12)
 	if _, err := io.ReadFull(rand.Reader, t.nonce); err != nil {
 		panic(err.Error())
@@ -50,7 +63,7 @@ func newToken(params Token, secretKey []byte) *Token {
 	return t
 }
 
-func parseTokenString(tokenString string, secretKey []byte) (t *Token, err error) {
+func parseTokenString(tokenString string, keys *tokenKeys) (t *Token, err error) {
 	// base64 解码
 	data, err := base64.RawURLEncoding.DecodeString(tokenString)
 	if err != nil {
@@ -58,10 +71,13 @@ func parseTokenString(tokenString string, secretKey []byte) (t *Token, err error
 	}
 
 	// 解密
-	plaintext, err := crypto.NewNaCL(secretKey).Decrypt(data)
+	plaintext, err := keys.open(data)
 	if err != nil {
 		return nil, err
 	}
+	if len(plaintext) != 8+12 {
+		return nil, ErrInvalidToken
+	}
 
 	// 解包
 	ID := uint64(binary.BigEndian.Uint64(plaintext[:8]))
@@ -72,9 +88,9 @@ func parseTokenString(tokenString string, secretKey []byte) (t *Token, err error
 
 	// 剩下的更多内容需要去数据库获取
 	return &Token{
-		ID:        ID,
-		nonce:     []byte(nonce),
-		secretKey: secretKey,
+		ID:    ID,
+		nonce: []byte(nonce),
+		keys:  keys,
 	}, nil
 }
 
@@ -85,12 +101,14 @@ type Token struct {
 	Device    string // 比如“home”“office”，有前端程序定义
 	Remark    string // 比如“家”、“办公室”，用户定义
 	Hash      string //
+	Family    string `gorm:"index"` // 同一次登录轮换出来的token属于同一个family
 	IssuedAt  time.Time
 	ExpiredAt time.Time
+	RotatedAt *time.Time // 已被轮换（用过一次）的时间，再次使用视为盗用
 	DeletedAt *time.Time
 	// 临时变量
-	secretKey []byte
-	nonce     []byte
+	keys  *tokenKeys
+	nonce []byte
 }
 
 // TableName 指定数据表名(gorm)
@@ -110,9 +128,6 @@ func (t *Token) sign() string {
 	if len(t.nonce) == 0 {
 		panic("缺少Token.nonce，只有newToken()创建的对象，才能导出Signature")
 	}
-	if len(t.secretKey) == 0 {
-		panic("缺少Token.secretKey，只有newToken()创建的对象，才能导出Signature")
-	}
 
 	// 打包
 	buf := new(bytes.Buffer)
@@ -168,8 +183,8 @@ func (t *Token) TokenString() string {
 	if len(t.nonce) == 0 {
 		panic("缺少Token.nonce，只有newToken()创建的对象，才能导出String")
 	}
-	if len(t.secretKey) == 0 {
-		panic("缺少Token.secretKey，只有newToken()创建的对象，才能导出String")
+	if t.keys == nil {
+		panic("缺少Token.keys，只有newToken()创建的对象，才能导出String")
 	}
 
 	// 打包
@@ -179,7 +194,7 @@ func (t *Token) TokenString() string {
 	binary.Write(buf, binary.BigEndian, t.nonce) // 12 bytes
 
 	// 加密
-	encrypted := crypto.NewNaCL(t.secretKey).Encrypt(buf.Bytes())
+	encrypted := t.keys.seal(buf.Bytes())
 
 	// base64编码
 	return base64.RawURLEncoding.EncodeToString(encrypted)
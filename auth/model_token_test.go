@@ -6,8 +6,9 @@ import (
 
 func TestToken(t *testing.T) {
 	secretKey := []byte("aasdfkjksjdfaaasdfkjksjdfa123405")
+	keys := newTokenKeys(secretKey, nil)
 	// 生成token
-	token := newToken(Token{UserID: 9999, Device: "deviceID", Remark: "remark"}, secretKey)
+	token := newToken(Token{UserID: 9999, Device: "deviceID", Remark: "remark"}, keys)
 	token.ID = 1234567899 // 模拟保存数据库
 
 	// 发送给客户端，由客户端保存，tokenString内含不可复原的nonce
@@ -15,10 +16,43 @@ func TestToken(t *testing.T) {
 	t.Log(tokenString)
 
 	// 验证
-	parsedToken, err := parseTokenString(tokenString, secretKey)
+	parsedToken, err := parseTokenString(tokenString, keys)
 	if err != nil {
 		t.Fatalf("解析失败：%s", err)
 	}
 	parsedToken.Hash = token.Hash // 模拟读取数据库
 	t.Logf("%d,  %t\n", parsedToken.ID, parsedToken.Verify())
 }
+
+func TestTokenKeyRotation(t *testing.T) {
+	secretKey := []byte("aasdfkjksjdfaaasdfkjksjdfa123405")
+	key1 := TokenKey{ID: "2020-01", Key: []byte("11111111111111111111111111111111")}
+	key2 := TokenKey{ID: "2020-06", Key: []byte("22222222222222222222222222222222")}
+	issue := func(keys *tokenKeys) string {
+		token := newToken(Token{UserID: 9999, Device: "deviceID"}, keys)
+		token.ID = 1234567899
+		return token.TokenString()
+	}
+
+	// 旧格式（没有key ID）
+	legacy := issue(newTokenKeys(secretKey, nil))
+	// key1加密
+	old := issue(newTokenKeys(nil, []TokenKey{key1, {ID: "legacy", Key: secretKey}}))
+
+	// 轮换：key2加密新token，key1、旧secretKey仍可解密
+	keys := newTokenKeys(nil, []TokenKey{key2, key1, {ID: "legacy", Key: secretKey}})
+	for _, tokenString := range []string{legacy, old, issue(keys)} {
+		if _, err := parseTokenString(tokenString, keys); err != nil {
+			t.Fatalf("解析失败：%s", err)
+		}
+	}
+
+	// 移除key1后，它加密的token失效
+	keys = newTokenKeys(nil, []TokenKey{key2})
+	if _, err := parseTokenString(old, keys); err == nil {
+		t.Fatal("移除的key加密的token理应失效")
+	}
+	if _, err := parseTokenString(legacy, keys); err == nil {
+		t.Fatal("移除的key加密的token理应失效")
+	}
+}
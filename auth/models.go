@@ -15,6 +15,16 @@ type User struct {
 	UpdatedAt time.Time
 }
 
+// UserQuery 用户查询条件（Repository.List、Count），按ID排序
+type UserQuery struct {
+	IDs      []uint64 // 为空则不限
+	Username string   // 精确匹配
+	AfterID  uint64   // ID大于（不包含），用于游标分页
+	BeforeID uint64   // ID小于（不包含）
+	Offset   int
+	Limit    int // 为0则不限
+}
+
 // UserIdentity 登录方式(小程序、钉钉……密码都可以）
 type UserIdentity struct {
 	UserID   uint64
@@ -25,11 +35,89 @@ type UserIdentity struct {
 
 // UserLog 用户行为记录（登录、注销）
 type UserLog struct {
-	ID        uint64
-	UserID    uint64
-	Action    string //（程序决定）
-	Remark    string //（备注）
-	IP        string
-	UA        string
+	ID        uint64    `json:"id"`
+	UserID    uint64    `json:"user_id" gorm:"index"`
+	Action    string    `json:"action"` //（程序决定）
+	Remark    string    `json:"remark"` //（备注）
+	IP        string    `json:"ip"`
+	UA        string    `json:"ua"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// UserLog.Action
+const (
+	// ActionLogin 登录成功
+	ActionLogin = "login"
+	// ActionLoginFailed 登录失败（UserID为0）
+	ActionLoginFailed = "login_failed"
+	// ActionTwoFactorFailed 两步验证失败
+	ActionTwoFactorFailed = "two_factor_failed"
+	// ActionTwoFactorEnabled 启用两步验证
+	ActionTwoFactorEnabled = "two_factor_enabled"
+	// ActionTwoFactorDisabled 停用两步验证
+	ActionTwoFactorDisabled = "two_factor_disabled"
+	// ActionLoginLocked 连续登录失败，账号或IP被临时锁定（锁定IP时UserID为0）
+	ActionLoginLocked = "login_locked"
+	// ActionRenew 续约
+	ActionRenew = "renew"
+	// ActionLogout 登出
+	ActionLogout = "logout"
+	// ActionRefreshTokenReused 已轮换的RefreshToken被再次使用，整个family被吊销
+	ActionRefreshTokenReused = "refresh_token_reused"
+	// ActionSessionRevoked 用户主动注销了某个（或其他所有）会话
+	ActionSessionRevoked = "session_revoked"
+	// ActionIdentityLinked 绑定登录方式
+	ActionIdentityLinked = "identity_linked"
+	// ActionIdentityUnlinked 解绑登录方式
+	ActionIdentityUnlinked = "identity_unlinked"
+	// ActionUserMerged 其他用户被合并进来（Remark里有被合并用户的ID、用户名）
+	ActionUserMerged = "user_merged"
+	// ActionOAuthAuthorized 授权OAuth客户端（Remark里有client、scope）
+	ActionOAuthAuthorized = "oauth_authorized"
+	// ActionAPIKeyCreated 创建API key（Remark里有ID、名称、scope）
+	ActionAPIKeyCreated = "api_key_created"
+	// ActionAPIKeyRevoked 吊销API key
+	ActionAPIKeyRevoked = "api_key_revoked"
+	// ActionImpersonationStarted 管理员开始模拟登录（Remark里有被模拟用户的ID、用户名）
+	ActionImpersonationStarted = "impersonation_started"
+	// ActionImpersonationEnded 管理员结束模拟登录
+	ActionImpersonationEnded = "impersonation_ended"
+	// ActionImpersonatedRequest 模拟登录的请求（UserID为被模拟的用户，Remark里有管理员ID、请求）
+	ActionImpersonatedRequest = "impersonated_request"
+)
+
+// LogQuery UserLog查询条件，零值表示不限
+type LogQuery struct {
+	UserID uint64
+	Action string
+	Since  time.Time // 包含
+	Until  time.Time // 不包含
+	Offset int
+	Limit  int
+}
+
+// Role 角色
+type Role struct {
+	Name      string `gorm:"primary_key;not null"`
+	Remark    string
+	CreatedAt time.Time
+}
+
+// Permission 权限
+type Permission struct {
+	Name      string `gorm:"primary_key;not null"`
+	Remark    string
 	CreatedAt time.Time
 }
+
+// UserRole 用户拥有的角色
+type UserRole struct {
+	UserID uint64 `gorm:"primary_key;auto_increment:false"`
+	Role   string `gorm:"primary_key;not null"`
+}
+
+// RolePermission 角色拥有的权限
+type RolePermission struct {
+	Role       string `gorm:"primary_key;not null"`
+	Permission string `gorm:"primary_key;not null"`
+}
@@ -6,6 +6,14 @@ type LoginProvider interface {
 	Login(credentials []byte) (user *User, err error)
 }
 
+// IdentityProvider 可选接口，实现后可以用Service.Link绑定到已登录的用户
+// Identify 与Login一样验证凭证，但不创建用户、UserIdentity，
+// 只返回第三方主键（UserIdentity.OpenID）和要保存的数据（UserIdentity.Data，可以为nil）
+type IdentityProvider interface {
+	LoginProvider
+	Identify(credentials []byte) (openID string, data interface{}, err error)
+}
+
 // RegisterProvider 注册登陆方式
 func (auth *Auth) RegisterProvider(provider LoginProvider) {
 	name := provider.Name()
@@ -5,7 +5,6 @@ import (
 
 	"github.com/goodwong/go-x/auth"
 	"github.com/goodwong/go-x/dingtalk"
-	"github.com/jinzhu/gorm"
 )
 
 // NewProvider 创建实例
@@ -56,7 +55,7 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	// 如果用户存在，直接返回
 	providerName := p.Name()
 	user, err = p.repository().FindByOpenID(providerName, info.UserID)
-	if err != nil && err != gorm.ErrRecordNotFound {
+	if err != nil && err != auth.ErrRecordNotFound {
 		return nil, err
 	}
 	if user != nil {
@@ -68,10 +67,10 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	// 如果不存在，创建用户
 	username := info.Mobile + "@telephone"
 	user, err = p.repository().FindByUsername(username)
-	if err != nil && err != gorm.ErrRecordNotFound {
+	if err != nil && err != auth.ErrRecordNotFound {
 		return nil, err
 	}
-	if err == gorm.ErrRecordNotFound {
+	if err == auth.ErrRecordNotFound {
 		user, err = p.repository().Create(username, info.Name, info.Avatar)
 		if err != nil {
 			return nil, err
@@ -84,3 +83,22 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	}
 	return user, err
 }
+
+// Identify 验证凭证; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户绑定钉钉（Service.Link）
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	// params
+	credentials := struct {
+		Code string
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return "", nil, err
+	}
+
+	// 钉钉接口获取数据
+	info, err := p.dingtalk.UserInfoByCode(credentials.Code)
+	if err != nil {
+		return "", nil, err
+	}
+	return info.UserID, info, nil
+}
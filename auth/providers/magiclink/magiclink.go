@@ -0,0 +1,288 @@
+package magiclink
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/crypto"
+)
+
+// NewProvider 创建实例
+func NewProvider(config *Config) *Provider {
+	if config.Mailer == nil {
+		panic("magiclink: 缺少Mailer")
+	}
+	if config.LinkURL == "" {
+		panic("magiclink: 缺少LinkURL")
+	}
+	if len(config.SecretKey) != 32 {
+		panic("magiclink: SecretKey须为32字节")
+	}
+	p := &Provider{
+		auth:           config.Auth,
+		mailer:         config.Mailer,
+		store:          config.Store,
+		nacl:           crypto.NewNaCL(config.SecretKey),
+		linkURL:        config.LinkURL,
+		linkLife:       config.LinkLife,
+		sendInterval:   config.SendInterval,
+		allowedDomains: config.AllowedDomains,
+		compose:        config.Compose,
+	}
+	if p.store == nil {
+		p.store = newMemoryOnceStore(p.auth.Now)
+	}
+	if p.linkLife == 0 {
+		p.linkLife = DefaultLinkLife
+	}
+	if p.sendInterval == 0 {
+		p.sendInterval = DefaultSendInterval
+	}
+	if p.compose == nil {
+		p.compose = func(email, link string, expires time.Duration) (subject, body string) {
+			return "登录链接", fmt.Sprintf("点击以下链接登录（%d分钟内有效，只能使用一次）：\n\n%s\n\n如果不是你本人操作，请忽略这封邮件。", int(expires.Minutes()), link)
+		}
+	}
+	return p
+}
+
+// Config 配置
+type Config struct {
+	Auth   *auth.Auth
+	Mailer Mailer
+	// Store 已使用的链接、发送间隔，为空则使用内存记录（同NewMemoryOnceStore，但用auth的时钟），多实例部署时须共享
+	Store OnceStore
+	// SecretKey 加密链接里的token，32字节
+	SecretKey []byte
+	// LinkURL 前端登录页，链接为 LinkURL?token=...
+	// 前端页面取出token，提交到登录接口（不要用GET直接登录，邮件安全扫描会预先访问链接）
+	LinkURL string
+	// LinkLife 链接有效时长，为空则使用DefaultLinkLife
+	LinkLife time.Duration
+	// SendInterval 同一个邮箱两次发送的最小间隔，为空则使用DefaultSendInterval
+	SendInterval time.Duration
+	// AllowedDomains 只允许这些域名的邮箱（如内部工具只允许公司邮箱），为空则不限
+	AllowedDomains []string
+	// Compose 邮件标题、内容，为空则使用默认的中文模板
+	Compose func(email, link string, expires time.Duration) (subject, body string)
+}
+
+// 默认配置
+const (
+	// DefaultLinkLife 默认链接有效时长
+	DefaultLinkLife = 10 * time.Minute
+	// DefaultSendInterval 默认两次发送的最小间隔
+	DefaultSendInterval = 1 * time.Minute
+)
+
+// ErrInvalidEmail 无效的邮箱（或者不在AllowedDomains里）
+var ErrInvalidEmail = errors.New("无效的邮箱")
+
+// ErrInvalidLink 链接无效、已过期或已使用
+var ErrInvalidLink = errors.New("链接无效或已过期，请重新获取")
+
+// ErrSendTooFrequent 发送太频繁
+var ErrSendTooFrequent = errors.New("发送太频繁，请稍后再试")
+
+// Provider 通过邮件链接 登陆
+type Provider struct {
+	auth           *auth.Auth
+	mailer         Mailer
+	store          OnceStore
+	nacl           *crypto.NaCL
+	linkURL        string
+	linkLife       time.Duration
+	sendInterval   time.Duration
+	allowedDomains []string
+	compose        func(email, link string, expires time.Duration) (subject, body string)
+}
+
+// linkToken 链接里的token（加密）
+type linkToken struct {
+	Email   string `json:"m"`
+	Nonce   string `json:"n"` // 用于标记已使用
+	Expires int64  `json:"e"`
+}
+
+func (p *Provider) repository() *auth.Repository {
+	return p.auth.Repository
+}
+
+// Name 获取provider名字; implemented Name with LoginProvider interface
+func (p *Provider) Name() string {
+	return "magiclink"
+}
+
+// SendLink 发送登录链接
+// 同一个邮箱须间隔SendInterval，否则返回ErrSendTooFrequent
+func (p *Provider) SendLink(email string) error {
+	email, err := p.normalize(email)
+	if err != nil {
+		return err
+	}
+	first, err := p.store.Once("send:"+email, p.sendInterval)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrSendTooFrequent
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(err.Error())
+	}
+	plaintext, _ := json.Marshal(&linkToken{
+		Email:   email,
+		Nonce:   base64.RawURLEncoding.EncodeToString(nonce),
+		Expires: p.auth.Now().Add(p.linkLife).Unix(),
+	})
+	token := base64.RawURLEncoding.EncodeToString(p.nacl.Encrypt(plaintext))
+
+	separator := "?"
+	if strings.Contains(p.linkURL, "?") {
+		separator = "&"
+	}
+	link := p.linkURL + separator + "token=" + url.QueryEscape(token)
+	subject, body := p.compose(email, link, p.linkLife)
+	return p.mailer.Send(email, subject, body)
+}
+
+// HandleSendLink 发送登录链接
+// 参数：body: {"email": "someone@example.com"}
+// 须自行添加路由，如：
+// r.Post("/api/login/magiclink", provider.HandleSendLink)
+func (p *Provider) HandleSendLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "request body读取错误"})
+		return
+	}
+	params := struct {
+		Email string `json:"email"`
+	}{}
+	json.Unmarshal(payload, &params)
+
+	switch err := p.SendLink(params.Email); err {
+	case nil:
+		json.NewEncoder(w).Encode("发送成功!")
+	case ErrInvalidEmail:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	case ErrSendTooFrequent:
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+// Login 登陆; implemented Login with LoginProvider interface
+// 参数：{"token"}（链接里的）
+// 用户名为 email@email
+func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
+	email, _, err := p.Identify(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 如果用户存在，直接返回
+	providerName := p.Name()
+	user, err = p.repository().FindByOpenID(providerName, email)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	// 第一次登录，先找这个邮箱的用户
+	username := email + "@email"
+	user, err = p.repository().FindByUsername(username)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == auth.ErrRecordNotFound {
+		user, err = p.repository().Create(username, strings.Split(email, "@")[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 然后创建登陆凭证
+	_, err = p.repository().CreateIdentity(user.ID, providerName, email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Identify 验证凭证; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户绑定邮箱（Service.Link），参数与Login一样
+// 验证通过后链接即作废
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	credentials := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return "", nil, err
+	}
+	encrypted, err := base64.RawURLEncoding.DecodeString(credentials.Token)
+	if err != nil {
+		return "", nil, ErrInvalidLink
+	}
+	plaintext, err := p.nacl.Decrypt(encrypted)
+	if err != nil {
+		return "", nil, ErrInvalidLink
+	}
+	token := &linkToken{}
+	if err := json.Unmarshal(plaintext, token); err != nil || token.Email == "" {
+		return "", nil, ErrInvalidLink
+	}
+	remaining := time.Unix(token.Expires, 0).Sub(p.auth.Now())
+	if remaining <= 0 {
+		return "", nil, ErrInvalidLink
+	}
+
+	// 只能用一次
+	first, err := p.store.Once("used:"+token.Nonce, remaining)
+	if err != nil {
+		return "", nil, err
+	}
+	if !first {
+		return "", nil, ErrInvalidLink
+	}
+	return token.Email, nil, nil
+}
+
+// normalize 检查邮箱格式、域名，转为小写
+func (p *Provider) normalize(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+	if len(p.allowedDomains) == 0 {
+		return email, nil
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range p.allowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return email, nil
+		}
+	}
+	return "", ErrInvalidEmail
+}
@@ -0,0 +1,178 @@
+package magiclink_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/magiclink"
+)
+
+var secretKey = []byte("aasdfkjksjdfaaasdfkjksjdfa123405") // 32 bytes
+
+var linkPattern = regexp.MustCompile(`https://example\.com/login\?token=\S+`)
+
+// token 从邮件里取出链接的token
+func token(t *testing.T, mailer *magiclink.MemoryMailer, email string) []byte {
+	message := mailer.Last(email)
+	if message == nil {
+		t.Fatalf("理应给%s发送邮件", email)
+	}
+	link, err := url.Parse(linkPattern.FindString(message.Body))
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("邮件里理应有登录链接：%s", message.Body)
+	}
+	payload, _ := json.Marshal(map[string]string{"token": link.Query().Get("token")})
+	return payload
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	mailer := magiclink.NewMemoryMailer()
+	provider := magiclink.NewProvider(&magiclink.Config{
+		Auth:      instance,
+		Mailer:    mailer,
+		SecretKey: secretKey,
+		LinkURL:   "https://example.com/login",
+		// 发送间隔用的是真实时钟，这里不限制
+		SendInterval: time.Nanosecond,
+	})
+	instance.RegisterProvider(provider)
+	email := "zhangsan@example.com"
+
+	if err := provider.SendLink(" ZhangSan@Example.com "); err != nil {
+		t.Fatal(err)
+	}
+	credentials := token(t, mailer, email)
+
+	// 登录，用户名为 email@email
+	tokens, err := instance.Service.Login("magiclink", credentials, true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == nil {
+		t.Fatal("理应颁发token和refresh token")
+	}
+	user, err := instance.Repository.FindByUsername(email + "@email")
+	if err != nil {
+		t.Fatal("理应创建用户", err)
+	}
+
+	// 只能用一次
+	if _, err := provider.Login(credentials); err != magiclink.ErrInvalidLink {
+		t.Fatalf("用过的链接不应再次通过：%v", err)
+	}
+
+	// 再次登录是同一个用户
+	if err := provider.SendLink(email); err != nil {
+		t.Fatal(err)
+	}
+	again, err := provider.Login(token(t, mailer, email))
+	if err != nil || again.ID != user.ID {
+		t.Fatalf("理应是同一个用户：%+v, %v", again, err)
+	}
+
+	// 篡改
+	if _, err := provider.Login([]byte(`{"token": "bm90LWEtdG9rZW4"}`)); err != magiclink.ErrInvalidLink {
+		t.Fatalf("无效的链接不应通过：%v", err)
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	mailer := magiclink.NewMemoryMailer()
+	provider := magiclink.NewProvider(&magiclink.Config{
+		Auth:      instance,
+		Mailer:    mailer,
+		SecretKey: secretKey,
+		LinkURL:   "https://example.com/login",
+	})
+	email := "lisi@example.com"
+
+	if err := provider.SendLink(email); err != nil {
+		t.Fatal(err)
+	}
+	advance(magiclink.DefaultLinkLife + time.Second)
+	if _, err := provider.Login(token(t, mailer, email)); err != magiclink.ErrInvalidLink {
+		t.Fatalf("过期的链接不应通过：%v", err)
+	}
+
+	// 发送间隔也按auth的时钟计算
+	if err := provider.SendLink(email); err != nil {
+		t.Fatalf("超过发送间隔理应可以重新发送：%v", err)
+	}
+	if _, err := provider.Login(token(t, mailer, email)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMagicLinkAllowedDomains(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := magiclink.NewProvider(&magiclink.Config{
+		Auth:           instance,
+		Mailer:         magiclink.NewMemoryMailer(),
+		SecretKey:      secretKey,
+		LinkURL:        "https://example.com/login",
+		AllowedDomains: []string{"example.com"},
+	})
+
+	if err := provider.SendLink("someone@other.com"); err != magiclink.ErrInvalidEmail {
+		t.Fatalf("其他域名不应发送：%v", err)
+	}
+	if err := provider.SendLink("not-an-email"); err != magiclink.ErrInvalidEmail {
+		t.Fatalf("无效的邮箱不应发送：%v", err)
+	}
+	if err := provider.SendLink("someone@example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleSendLink(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := magiclink.NewProvider(&magiclink.Config{
+		Auth:      instance,
+		Mailer:    magiclink.NewMemoryMailer(),
+		SecretKey: secretKey,
+		LinkURL:   "https://example.com/login",
+	})
+	send := func(body string) int {
+		req := httptest.NewRequest("POST", "http://localhost/api/login/magiclink", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		provider.HandleSendLink(w, req)
+		return w.Result().StatusCode
+	}
+	if status := send(`{"email": "wangwu@example.com"}`); status != http.StatusOK {
+		t.Fatalf("理应发送成功：%d", status)
+	}
+	if status := send(`{"email": "wangwu@example.com"}`); status != http.StatusTooManyRequests {
+		t.Fatalf("太频繁理应429：%d", status)
+	}
+	if status := send(`{"email": "abc"}`); status != http.StatusBadRequest {
+		t.Fatalf("无效的邮箱理应400：%d", status)
+	}
+}
@@ -0,0 +1,100 @@
+package magiclink
+
+import (
+	"sync"
+	"time"
+)
+
+// Mailer 发送邮件，由应用对接邮件服务（SMTP、SendGrid……）
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Message 一封邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// NewMemoryMailer 不发邮件，只保存在内存里，用于开发和测试
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// MemoryMailer 不发邮件，只保存在内存里
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send implemented Send with Mailer interface
+func (m *MemoryMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages 已发送的全部邮件
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message{}, m.messages...)
+}
+
+// Last 发给to的最后一封邮件，没有则为nil
+func (m *MemoryMailer) Last(to string) *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			message := m.messages[i]
+			return &message
+		}
+	}
+	return nil
+}
+
+// OnceStore 记录一次性的key（已使用的链接、发送间隔）
+// 多实例部署时，应使用共享的存储（如基于redis的SET NX + 过期时间）
+type OnceStore interface {
+	// Once 第一次调用返回true；ttl之内再次调用返回false，ttl之后可以删除
+	Once(key string, ttl time.Duration) (bool, error)
+}
+
+// NewMemoryOnceStore 内存记录（仅限单实例，重启后丢失）
+func NewMemoryOnceStore() OnceStore {
+	return newMemoryOnceStore(time.Now)
+}
+
+// newMemoryOnceStore 指定时钟的内存记录（Provider默认使用auth的时钟）
+func newMemoryOnceStore(now func() time.Time) *memoryOnceStore {
+	return &memoryOnceStore{keys: map[string]time.Time{}, now: now}
+}
+
+type memoryOnceStore struct {
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	cleanedAt time.Time
+	now       func() time.Time
+}
+
+func (s *memoryOnceStore) Once(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.cleanedAt) > time.Minute {
+		for k, expires := range s.keys {
+			if now.After(expires) {
+				delete(s.keys, k)
+			}
+		}
+		s.cleanedAt = now
+	}
+	if expires, ok := s.keys[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+	s.keys[key] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,431 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/crypto"
+)
+
+// NewProvider 创建实例
+// 不会立即请求issuer，第一次用到时才获取元数据（discovery）和公钥（JWKS）
+func NewProvider(config *Config) *Provider {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		panic("oidc: 缺少Issuer、ClientID或RedirectURL")
+	}
+	if len(config.SecretKey) != 32 {
+		panic("oidc: SecretKey须为32字节")
+	}
+	p := &Provider{
+		auth:                config.Auth,
+		name:                config.Name,
+		issuer:              config.Issuer,
+		clientID:            config.ClientID,
+		clientSecret:        config.ClientSecret,
+		redirectURL:         config.RedirectURL,
+		scopes:              config.Scopes,
+		flowLife:            config.FlowLife,
+		client:              config.HTTPClient,
+		mapClaims:           config.MapClaims,
+		jwksRefreshInterval: config.JWKSRefreshInterval,
+		nacl:                crypto.NewNaCL(config.SecretKey),
+	}
+	if p.name == "" {
+		p.name = "oidc"
+	}
+	if len(p.scopes) == 0 {
+		p.scopes = []string{"openid", "profile", "email"}
+	}
+	if p.flowLife == 0 {
+		p.flowLife = DefaultFlowLife
+	}
+	if p.jwksRefreshInterval == 0 {
+		p.jwksRefreshInterval = DefaultJWKSRefreshInterval
+	}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if p.mapClaims == nil {
+		p.mapClaims = func(claims *Claims) (username, name, avatar string) {
+			return claims.Subject + "@" + p.name, claims.Name, claims.Picture
+		}
+	}
+	return p
+}
+
+// Config 配置
+type Config struct {
+	Auth *auth.Auth
+	// Name provider名字，接入多个issuer时须区分，为空则为"oidc"
+	Name string
+	// Issuer 如"https://accounts.google.com"，须与元数据里的issuer完全一致
+	Issuer       string
+	ClientID     string
+	ClientSecret string // 公开客户端为空（只用PKCE）
+	// RedirectURL 在issuer登记的回调地址（前端页面），
+	// 收到code、state后，连同flow一起提交到登录接口
+	RedirectURL string
+	// Scopes 为空则为openid profile email
+	Scopes []string
+	// SecretKey 加密flow（state、nonce、code_verifier）用，32字节
+	SecretKey []byte
+	// FlowLife 从跳转到issuer到回来登录的最长时间，为空则使用DefaultFlowLife
+	FlowLife   time.Duration
+	HTTPClient *http.Client
+	// MapClaims 把ID token里的信息映射为User，为空则用户名为 sub@<Name>
+	// 例如信任issuer验证过的邮箱，可以返回 email@email，与其他登录方式的用户合在一起
+	MapClaims func(claims *Claims) (username, name, avatar string)
+	// JWKSRefreshInterval 遇到未知的kid时（issuer轮换了密钥）重新获取JWKS，
+	// 但两次之间至少间隔这么久，为空则使用DefaultJWKSRefreshInterval
+	JWKSRefreshInterval time.Duration
+}
+
+// 默认配置
+const (
+	// DefaultFlowLife 默认flow有效时长
+	DefaultFlowLife = 10 * time.Minute
+	// DefaultJWKSRefreshInterval 默认重新获取JWKS的最小间隔
+	DefaultJWKSRefreshInterval = time.Minute
+)
+
+// idTokenLeeway 验证ID token的exp、iat时，容许的时钟误差
+const idTokenLeeway = time.Minute
+
+// ErrInvalidFlow flow无效、已过期，或者state不匹配
+var ErrInvalidFlow = errors.New("登录已过期，请重新登录")
+
+// Claims ID token里的用户信息
+type Claims struct {
+	Subject             string                 `json:"sub"`
+	Email               string                 `json:"email"`
+	EmailVerified       bool                   `json:"email_verified"`
+	PhoneNumber         string                 `json:"phone_number"`
+	PhoneNumberVerified bool                   `json:"phone_number_verified"`
+	Name                string                 `json:"name"`
+	Picture             string                 `json:"picture"`
+	Raw                 map[string]interface{} `json:"-"` // 全部claims
+}
+
+// Provider 通过OpenID Connect 登陆
+type Provider struct {
+	auth                *auth.Auth
+	name                string
+	issuer              string
+	clientID            string
+	clientSecret        string
+	redirectURL         string
+	scopes              []string
+	flowLife            time.Duration
+	client              *http.Client
+	mapClaims           func(claims *Claims) (username, name, avatar string)
+	jwksRefreshInterval time.Duration
+	nacl                *crypto.NaCL
+
+	// 缓存
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]*verifyKey // kid => key
+	keysFetchedAt time.Time
+}
+
+// flow 跳转到issuer之前生成，加密后交给前端保管，登录时原样提交
+type flow struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"e"`
+}
+
+func (p *Provider) repository() *auth.Repository {
+	return p.auth.Repository
+}
+
+// Name 获取provider名字; implemented Name with LoginProvider interface
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL 生成跳转到issuer的地址，以及flow（前端保存，登录时提交）
+func (p *Provider) AuthCodeURL() (authURL, flowString string, err error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", "", err
+	}
+	f := &flow{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Expires:  p.auth.Now().Add(p.flowLife).Unix(),
+	}
+	plaintext, _ := json.Marshal(f)
+	flowString = base64.RawURLEncoding.EncodeToString(p.nacl.Encrypt(plaintext))
+
+	sum := sha256.Sum256([]byte(f.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {f.State},
+		"nonce":                 {f.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), flowString, nil
+}
+
+// HandleAuthCodeURL 返回 {"url", "flow"}，前端保存flow后跳转到url
+// 须自行添加路由，如：
+// r.Get("/api/login/oidc", provider.HandleAuthCodeURL)
+func (p *Provider) HandleAuthCodeURL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	authURL, flowString, err := p.AuthCodeURL()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"url": authURL, "flow": flowString})
+}
+
+// Login 登陆; implemented Login with LoginProvider interface
+// 参数：{"code", "state"}（issuer回调带回来的）和{"flow"}（AuthCodeURL返回的）
+func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
+	claims, err := p.claims(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 如果用户存在，直接返回
+	providerName := p.Name()
+	user, err = p.repository().FindByOpenID(providerName, claims.Subject)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	// 第一次登录，按MapClaims找到或创建用户
+	username, name, avatar := p.mapClaims(claims)
+	user, err = p.repository().FindByUsername(username)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == auth.ErrRecordNotFound {
+		user, err = p.repository().Create(username, name, avatar)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 然后创建登陆凭证
+	_, err = p.repository().CreateIdentity(user.ID, providerName, claims.Subject, claims.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Identify 验证凭证; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户绑定（Service.Link），参数与Login一样
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	claims, err := p.claims(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return claims.Subject, claims.Raw, nil
+}
+
+// claims 检查flow、state，用code换取ID token并验证
+func (p *Provider) claims(payload []byte) (*Claims, error) {
+	// params
+	credentials := struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+		Flow  string `json:"flow"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return nil, err
+	}
+	f, err := p.openFlow(credentials.Flow)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(f.State), []byte(credentials.State)) != 1 {
+		return nil, ErrInvalidFlow
+	}
+
+	rawIDToken, err := p.exchange(credentials.Code, f.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	return p.verifyIDToken(rawIDToken, f.Nonce)
+}
+
+// openFlow 解密并检查有效期
+func (p *Provider) openFlow(flowString string) (*flow, error) {
+	data, err := base64.RawURLEncoding.DecodeString(flowString)
+	if err != nil {
+		return nil, ErrInvalidFlow
+	}
+	plaintext, err := p.nacl.Decrypt(data)
+	if err != nil {
+		return nil, ErrInvalidFlow
+	}
+	f := &flow{}
+	if err := json.Unmarshal(plaintext, f); err != nil {
+		return nil, ErrInvalidFlow
+	}
+	if p.auth.Now().Unix() > f.Expires {
+		return nil, ErrInvalidFlow
+	}
+	return f, nil
+}
+
+// exchange 用code换取ID token
+func (p *Provider) exchange(code, verifier string) (rawIDToken string, err error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("oidc: token接口返回错误: %s", resp.Status)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("oidc: %s %s", result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("oidc: token接口没有返回id_token")
+	}
+	return result.IDToken, nil
+}
+
+// verifyIDToken 验证签名（JWKS）、iss、aud、azp、exp、iat、nonce
+func (p *Provider) verifyIDToken(rawIDToken, nonce string) (*Claims, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
+		alg := token.Method.Alg()
+		if alg == "none" || strings.HasPrefix(alg, "HS") {
+			return nil, fmt.Errorf("oidc: 不支持的算法: %s", alg)
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.verifyKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != alg {
+			return nil, fmt.Errorf("oidc: 算法不匹配: %s", alg)
+		}
+		return key.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw := token.Claims.(jwt.MapClaims)
+
+	now := p.auth.Now()
+	if !raw.VerifyIssuer(d.Issuer, true) {
+		return nil, errors.New("oidc: ID token的iss不匹配")
+	}
+	if !containsAudience(raw["aud"], p.clientID) {
+		return nil, errors.New("oidc: ID token的aud不匹配")
+	}
+	if azp, ok := raw["azp"].(string); ok && azp != p.clientID {
+		return nil, errors.New("oidc: ID token的azp不匹配")
+	}
+	if !raw.VerifyExpiresAt(now.Add(-idTokenLeeway).Unix(), true) {
+		return nil, errors.New("oidc: ID token已过期")
+	}
+	if !raw.VerifyIssuedAt(now.Add(idTokenLeeway).Unix(), true) {
+		return nil, errors.New("oidc: ID token签发时间无效")
+	}
+	if n, _ := raw["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, errors.New("oidc: ID token的nonce不匹配")
+	}
+
+	// 映射
+	data, _ := json.Marshal(raw)
+	claims := &Claims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("oidc: ID token缺少sub")
+	}
+	claims.Raw = raw
+	return claims, nil
+}
+
+// containsAudience aud可以是字符串或数组（jwt-go v3的VerifyAudience只支持字符串）
+func containsAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// randomString state、nonce、code_verifier
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
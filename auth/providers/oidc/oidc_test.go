@@ -0,0 +1,304 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/oidc"
+)
+
+var secretKey = []byte("aasdfkjksjdfaaasdfkjksjdfa123405") // 32 bytes
+
+// issuer 模拟的OpenID Provider
+type issuer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	codes  map[string]url.Values  // code => 授权请求参数
+	claims map[string]interface{} // 覆盖ID token里的claims
+	// JWKS请求次数；failJWKS为true时返回500
+	jwksRequests int
+	failJWKS     bool
+}
+
+func newIssuer(t *testing.T) *issuer {
+	s := &issuer{codes: map[string]url.Values{}, claims: map[string]interface{}{}}
+	s.rotateKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jwksRequests++
+		if s.failJWKS {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.kid,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r.ParseForm()
+		clientID, clientSecret, _ := r.BasicAuth()
+		params, ok := s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code"))
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || clientID != "gotest" || clientSecret != "secret" ||
+			params.Get("redirect_uri") != r.PostForm.Get("redirect_uri") ||
+			params.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   s.URL,
+			"sub":   "248289761001",
+			"aud":   []string{"gotest"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": params.Get("nonce"),
+			"name":  "Jane Doe",
+			"email": "janedoe@example.com",
+		}
+		for key, value := range s.claims {
+			claims[key] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = s.kid
+		idToken, _ := token.SignedString(s.key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": idToken})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// rotateKey 换一个签名密钥
+func (s *issuer) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+// authorize 模拟用户在issuer登录、同意，返回回调的code、state
+func (s *issuer) authorize(t *testing.T, authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, s.URL+"/authorize?") {
+		t.Fatalf("无效的授权地址：%s", authURL)
+	}
+	params := u.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("nonce") == "" {
+		t.Fatalf("理应带上PKCE、nonce：%s", authURL)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code = fmt.Sprintf("code-%d", len(s.codes)+1)
+	s.codes[code] = params
+	return code, params.Get("state")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newIssuer(t)
+	defer issuer.Close()
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := oidc.NewProvider(&oidc.Config{
+		Auth:         instance,
+		Issuer:       issuer.URL,
+		ClientID:     "gotest",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/callback",
+		SecretKey:    secretKey,
+		// 测试轮换密钥，不限制间隔
+		JWKSRefreshInterval: time.Nanosecond,
+	})
+	instance.RegisterProvider(provider)
+
+	login := func(mutate func(code, state, flow string) (string, string, string)) (*auth.User, error) {
+		authURL, flow, err := provider.AuthCodeURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := issuer.authorize(t, authURL)
+		if mutate != nil {
+			code, state, flow = mutate(code, state, flow)
+		}
+		payload, _ := json.Marshal(map[string]string{"code": code, "state": state, "flow": flow})
+		return provider.Login(payload)
+	}
+
+	// 第一次登录，创建用户
+	user, err := login(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "248289761001@oidc" || user.Name != "Jane Doe" {
+		t.Fatalf("claims映射不对：%+v", user)
+	}
+	// 再次登录，同一个用户
+	again, err := login(nil)
+	if err != nil || again.ID != user.ID {
+		t.Fatalf("理应是同一个用户：%+v, %v", again, err)
+	}
+
+	// state不匹配、flow伪造
+	if _, err := login(func(code, state, flow string) (string, string, string) { return code, "forged", flow }); err != oidc.ErrInvalidFlow {
+		t.Fatalf("state不匹配不应通过：%v", err)
+	}
+	if _, err := login(func(code, state, flow string) (string, string, string) { return code, state, "forged" }); err != oidc.ErrInvalidFlow {
+		t.Fatalf("伪造的flow不应通过：%v", err)
+	}
+
+	// nonce、aud不对
+	for key, value := range map[string]interface{}{"nonce": "replayed", "aud": "other-client", "iss": "https://evil.example.com"} {
+		issuer.claims = map[string]interface{}{key: value}
+		if _, err := login(nil); err == nil {
+			t.Fatalf("%s不对不应通过", key)
+		}
+	}
+	issuer.claims = map[string]interface{}{}
+
+	// issuer轮换密钥，重新获取JWKS
+	issuer.rotateKey(t)
+	if _, err := login(nil); err != nil {
+		t.Fatal("轮换密钥后理应重新获取JWKS", err)
+	}
+}
+
+func TestOIDCMapClaims(t *testing.T) {
+	issuer := newIssuer(t)
+	defer issuer.Close()
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := oidc.NewProvider(&oidc.Config{
+		Auth:         instance,
+		Name:         "company",
+		Issuer:       issuer.URL,
+		ClientID:     "gotest",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/callback",
+		SecretKey:    secretKey,
+		MapClaims: func(claims *oidc.Claims) (username, name, avatar string) {
+			return claims.Email + "@email", claims.Name, claims.Picture
+		},
+	})
+	instance.RegisterProvider(provider)
+
+	// 已有的用户，按映射的用户名关联上
+	existing, err := instance.Repository.Create("janedoe@example.com@email", "Jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, flow, _ := provider.AuthCodeURL()
+	code, state := issuer.authorize(t, authURL)
+	payload, _ := json.Marshal(map[string]string{"code": code, "state": state, "flow": flow})
+	tokens, err := instance.Service.Login("company", payload, false, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token == "" {
+		t.Fatal("理应颁发token")
+	}
+	if user, err := instance.Repository.FindByOpenID("company", "248289761001"); err != nil || user.ID != existing.ID {
+		t.Fatalf("理应关联到已有用户：%+v, %v", user, err)
+	}
+}
+
+func TestOIDCClock(t *testing.T) {
+	issuer := newIssuer(t)
+	defer issuer.Close()
+	var mu sync.Mutex
+	now := time.Now()
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	provider := oidc.NewProvider(&oidc.Config{
+		Auth:         instance,
+		Issuer:       issuer.URL,
+		ClientID:     "gotest",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/callback",
+		SecretKey:    secretKey,
+	})
+	instance.RegisterProvider(provider)
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	login := func() error {
+		authURL, flow, err := provider.AuthCodeURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := issuer.authorize(t, authURL)
+		payload, _ := json.Marshal(map[string]string{"code": code, "state": state, "flow": flow})
+		_, err = provider.Login(payload)
+		return err
+	}
+
+	// 获取JWKS失败，间隔内不再重试
+	issuer.failJWKS = true
+	for i := 0; i < 3; i++ {
+		if err := login(); err == nil {
+			t.Fatal("获取JWKS失败不应通过")
+		}
+	}
+	if issuer.jwksRequests != 1 {
+		t.Fatalf("失败后间隔内不应再请求JWKS：%d", issuer.jwksRequests)
+	}
+	issuer.failJWKS = false
+	advance(oidc.DefaultJWKSRefreshInterval)
+	if err := login(); err != nil {
+		t.Fatal("间隔过后理应重新获取JWKS", err)
+	}
+
+	// flow按auth的时钟过期
+	authURL, flow, _ := provider.AuthCodeURL()
+	code, state := issuer.authorize(t, authURL)
+	advance(oidc.DefaultFlowLife + time.Second)
+	payload, _ := json.Marshal(map[string]string{"code": code, "state": state, "flow": flow})
+	if _, err := provider.Login(payload); err != oidc.ErrInvalidFlow {
+		t.Fatalf("flow理应已过期：%v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package password
+
+// CommonPasswords 内置的常见密码列表（PasswordPolicy.RejectCommon）
+// 来自公开的泄露密码统计，比较时不区分大小写
+// 大部分过不了字符类型的要求，这里重点收录“看起来够复杂”的
+var CommonPasswords = []string{
+	// 满足大小写、数字、特殊字符
+	"P@ssw0rd", "P@ssw0rd1", "P@ssw0rd!", "P@ssw0rd123", "P@$$w0rd", "P@55w0rd",
+	"Passw0rd!", "Passw0rd@", "Passw0rd#", "Passw0rd1!", "Password1!", "Password1@",
+	"Password1#", "Password12!", "Password123!", "Password123@", "Password@1",
+	"Password@123", "Password#1", "Password!1", "Pa$$w0rd", "Pa$$word1",
+	"Admin@123", "Admin@1234", "Admin123!", "Admin#123", "Admin@2020", "Admin@2021",
+	"Root@123", "Root@1234", "Test@123", "Test@1234", "Test123!", "User@123",
+	"Welcome1!", "Welcome@1", "Welcome@123", "Welcome123!", "Welcome#1",
+	"Qwerty1!", "Qwerty123!", "Qwerty@123", "Qwer1234!", "Qwer!234", "Qwe123!@#",
+	"Abc@1234", "Abc@123456", "Abc123!@#", "Abcd@1234", "Abcd1234!", "Abc#1234",
+	"Aa123456!", "Aa123456@", "Aa@123456", "Aa12345678!", "Aa111111!",
+	"Zxcvbnm1!", "Zxcv1234!", "Asdf1234!", "Asdfgh1!", "1qaz@WSX", "1qaz!QAZ",
+	"1Qaz2wsx!", "!QAZ2wsx", "Qaz123!@#", "Zaq12wsx!", "Zaq1@wsx",
+	"Changeme1!", "Changeme123!", "Letmein1!", "Iloveyou1!", "Sunshine1!",
+	"Summer2020!", "Summer2021!", "Spring2021!", "Winter2020!", "Autumn2020!",
+	"Company@123", "Company123!", "Huawei@123", "Huawei12#$", "Sangfor@123",
+	"Hello@123", "Hello123!", "Master@123", "Dragon1!", "Monkey1!",
+	"Woaini1314!", "Woaini@1314", "Wang@123", "Zhang@123", "Li@123456",
+
+	// 常见的弱密码（规则放宽时也能拦住）
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567",
+	"111111", "000000", "123123", "654321", "666666", "888888", "121212",
+	"112233", "123321", "147258369", "159357", "5201314", "1314520",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "abc123", "abcd1234", "a123456", "a12345678", "aa123456",
+	"iloveyou", "admin", "admin123", "root", "toor", "letmein", "welcome",
+	"monkey", "dragon", "master", "sunshine", "princess", "football", "baseball",
+	"superman", "trustno1", "changeme", "login", "starwars", "whatever",
+	"1q2w3e4r", "1qaz2wsx", "qazwsx", "zxcvbnm", "asdfghjkl", "woaini",
+	"woaini1314", "iloveyou1", "q1w2e3r4", "test", "test123", "guest",
+}
@@ -0,0 +1,189 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hasher 密码hash算法
+// 保存的hash是自描述的PHC格式（$算法$参数$salt$hash），以后调整算法、参数，旧的hash仍然可以验证
+//
+//   - argon2id：$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//   - bcrypt：$2a$10$...（bcrypt自己的格式）
+//   - scrypt：$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+type Hasher interface {
+	// Hash 生成新的hash
+	Hash(password []byte) (string, error)
+	// NeedsRehash 已保存的hash是否与当前算法、参数不一致（登录成功后会重新hash）
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultHasher 默认的hash算法
+var DefaultHasher Hasher = Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+// ErrUnknownHash 无法识别的hash格式
+var ErrUnknownHash = errors.New("无法识别的密码hash格式")
+
+// salt、hash的长度
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// Argon2id argon2id算法（推荐）
+type Argon2id struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Hash implemented Hash with Hasher interface
+func (a Argon2id) Hash(password []byte) (string, error) {
+	salt := newSalt()
+	key := argon2.IDKey(password, salt, a.Iterations, a.Memory, a.Parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		a.Memory, a.Iterations, a.Parallelism, encodeBase64(salt), encodeBase64(key)), nil
+}
+
+// NeedsRehash implemented NeedsRehash with Hasher interface
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	params, _, _, err := parseArgon2id(encoded)
+	return err != nil || params != a
+}
+
+// Bcrypt bcrypt算法
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash implemented Hash with Hasher interface
+func (b Bcrypt) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, b.Cost) // DefaultCost: 50ms
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil // bcrypt 本身有base64编码
+}
+
+// NeedsRehash implemented NeedsRehash with Hasher interface
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// Scrypt scrypt算法，N = 2^LogN
+type Scrypt struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+// Hash implemented Hash with Hasher interface
+func (s Scrypt) Hash(password []byte) (string, error) {
+	salt := newSalt()
+	key, err := scrypt.Key(password, salt, 1<<s.LogN, s.R, s.P, keyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		s.LogN, s.R, s.P, encodeBase64(salt), encodeBase64(key)), nil
+}
+
+// NeedsRehash implemented NeedsRehash with Hasher interface
+func (s Scrypt) NeedsRehash(encoded string) bool {
+	params, _, _, err := parseScrypt(encoded)
+	return err != nil || params != s
+}
+
+// verifyHash 验证密码，支持以上所有格式
+func verifyHash(encoded string, password []byte) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := parseArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		computed := argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1, nil
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		params, salt, key, err := parseScrypt(encoded)
+		if err != nil {
+			return false, err
+		}
+		computed, err := scrypt.Key(password, salt, 1<<params.LogN, params.R, params.P, len(key))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(computed, key) == 1, nil
+	case strings.HasPrefix(encoded, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, ErrUnknownHash
+}
+
+// parseArgon2id $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func parseArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil ||
+		params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, key, err = decodeSaltAndKey(parts[4], parts[5])
+	return params, salt, key, err
+}
+
+// parseScrypt $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+func parseScrypt(encoded string) (params Scrypt, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, key, err = decodeSaltAndKey(parts[3], parts[4])
+	return params, salt, key, err
+}
+
+func decodeSaltAndKey(encodedSalt, encodedKey string) (salt, key []byte, err error) {
+	if salt, err = base64.RawStdEncoding.DecodeString(encodedSalt); err != nil {
+		return nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(encodedKey); err != nil || len(key) == 0 {
+		return nil, nil, ErrUnknownHash
+	}
+	return salt, key, nil
+}
+
+// encodeBase64 PHC格式使用不带padding的标准base64
+func encodeBase64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func newSalt() []byte {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		panic(err.Error())
+	}
+	return salt
+}
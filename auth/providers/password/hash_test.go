@@ -0,0 +1,145 @@
+package password_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storedHash 读取已保存的hash
+func storedHash(t *testing.T, instance *auth.Auth, username string) string {
+	identity, err := instance.Repository.FindIdentity("password", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := struct {
+		PasswordHash string `json:"password_hash"`
+	}{}
+	if err := json.Unmarshal([]byte(*identity.Data), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data.PasswordHash
+}
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]password.Hasher{
+		"$argon2id$v=19$m=1024,t=1,p=1$": password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1},
+		"$2a$04$":                        password.Bcrypt{Cost: bcrypt.MinCost},
+		"$scrypt$ln=10,r=8,p=1$":         password.Scrypt{LogN: 10, R: 8, P: 1},
+	}
+	for prefix, hasher := range hashers {
+		hash, err := hasher.Hash([]byte("Xk9#mLp2qR"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			t.Fatalf("理应是PHC格式%s：%s", prefix, hash)
+		}
+		if hasher.NeedsRehash(hash) {
+			t.Fatalf("参数一致不需要重新hash：%s", hash)
+		}
+		for _, other := range hashers {
+			if other != hasher && !other.NeedsRehash(hash) {
+				t.Fatalf("算法、参数不一致理应重新hash：%s", hash)
+			}
+		}
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	legacy := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Bcrypt{Cost: bcrypt.MinCost},
+	})
+	if _, err := legacy.Register("zhangsan", "Xk9#mLp2qR"); err != nil {
+		t.Fatal(err)
+	}
+	old := storedHash(t, instance, "zhangsan")
+	if !strings.HasPrefix(old, "$2a$") {
+		t.Fatalf("理应是bcrypt：%s", old)
+	}
+
+	// 换成argon2id，旧的hash仍然可以登录，登录后重新hash
+	provider := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	if _, err := provider.Login([]byte(`{"username": "zhangsan", "password": "wrong"}`)); err == nil {
+		t.Fatal("错误的密码不应登录")
+	}
+	if storedHash(t, instance, "zhangsan") != old {
+		t.Fatal("登录失败不应重新hash")
+	}
+	if _, err := provider.Login([]byte(`{"username": "zhangsan", "password": "Xk9#mLp2qR"}`)); err != nil {
+		t.Fatal(err)
+	}
+	rehashed := storedHash(t, instance, "zhangsan")
+	if !strings.HasPrefix(rehashed, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("理应重新hash为argon2id：%s", rehashed)
+	}
+
+	// 提高参数
+	stronger := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Argon2id{Memory: 2048, Iterations: 2, Parallelism: 1},
+	})
+	if _, err := stronger.Login([]byte(`{"username": "zhangsan", "password": "Xk9#mLp2qR"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if hash := storedHash(t, instance, "zhangsan"); !strings.HasPrefix(hash, "$argon2id$v=19$m=2048,t=2,p=1$") {
+		t.Fatalf("理应按新参数重新hash：%s", hash)
+	}
+	if _, err := legacy.Login([]byte(`{"username": "zhangsan", "password": "Xk9#mLp2qR"}`)); err != nil {
+		t.Fatal("任何格式都理应可以验证", err)
+	}
+}
+
+// brokenStorage 更新登录凭证总是出错（如数据库断开）
+type brokenStorage struct {
+	auth.Storage
+}
+
+func (s brokenStorage) UpdateIdentityData(identity *auth.UserIdentity, data json.RawMessage) error {
+	return errors.New("数据库连接失败")
+}
+
+func (s brokenStorage) CompareAndUpdateIdentityData(identity *auth.UserIdentity, data json.RawMessage) (bool, error) {
+	return false, errors.New("数据库连接失败")
+}
+
+func TestRehashStorageError(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey, Storage: brokenStorage{auth.NewMemoryStorage()}})
+	defer instance.Close()
+	legacy := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Bcrypt{Cost: bcrypt.MinCost},
+	})
+	if _, err := legacy.Register("lisi", "Xk9#mLp2qR"); err != nil {
+		t.Fatal(err)
+	}
+	old := storedHash(t, instance, "lisi")
+
+	// 重新hash保存失败，不影响登录
+	provider := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	if _, err := provider.Login([]byte(`{"username": "lisi", "password": "Xk9#mLp2qR"}`)); err != nil {
+		t.Fatal("重新hash失败不应影响登录", err)
+	}
+	if storedHash(t, instance, "lisi") != old {
+		t.Fatal("保存失败理应还是旧的hash")
+	}
+
+	// 修改密码返回错误，不panic
+	if err := provider.SetPassword("lisi", "Nw7$kQz4pL"); err == nil {
+		t.Fatal("保存失败理应返回错误")
+	}
+}
@@ -1,32 +1,78 @@
 package password
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"unicode"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/goodwong/go-x/auth"
-	"github.com/jinzhu/gorm"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // NewProvider 创建
 func NewProvider(config *Config) *Provider {
-	return &Provider{
-		auth: config.Auth,
+	p := &Provider{
+		auth:          config.Auth,
+		hasher:        config.Hasher,
+		policy:        DefaultPolicy,
+		deliverReset:  config.DeliverReset,
+		resetLife:     config.ResetLife,
+		resetInterval: config.ResetInterval,
 	}
+	if p.hasher == nil {
+		p.hasher = DefaultHasher
+	}
+	if config.Policy != nil {
+		p.policy = *config.Policy
+	}
+	p.policy.compile()
+	if p.resetLife == 0 {
+		p.resetLife = DefaultResetLife
+	}
+	if p.resetInterval == 0 {
+		p.resetInterval = DefaultResetInterval
+	}
+	return p
 }
 
 // Config 配置
 type Config struct {
 	Auth *auth.Auth
+	// Hasher 新密码的hash算法、参数，为空则使用DefaultHasher
+	// 已保存的旧格式hash仍然可以登录，登录成功后自动按新的算法、参数重新hash
+	Hasher Hasher
+	// Policy 密码规则，为空则使用DefaultPolicy
+	Policy *PasswordPolicy
+	// DeliverReset 发送重设密码的链接（邮件、短信……），token须原样交给ConfirmReset
+	// 为空则不能重设密码（RequestReset返回ErrResetNotConfigured）
+	DeliverReset func(user *auth.User, username, token string) error
+	// ResetLife 重设密码token的有效时长，为空则使用DefaultResetLife
+	ResetLife time.Duration
+	// ResetInterval 同一个用户两次发送的最小间隔，为空则使用DefaultResetInterval
+	ResetInterval time.Duration
 }
 
+// 默认配置
+const (
+	// DefaultResetLife 默认重设密码token的有效时长
+	DefaultResetLife = 30 * time.Minute
+	// DefaultResetInterval 默认两次发送重设密码token的最小间隔
+	DefaultResetInterval = 1 * time.Minute
+)
+
 // Provider 通过password 登陆
 type Provider struct {
-	auth *auth.Auth
+	auth          *auth.Auth
+	hasher        Hasher
+	policy        PasswordPolicy
+	deliverReset  func(user *auth.User, username, token string) error
+	resetLife     time.Duration
+	resetInterval time.Duration
+
+	// 同一个实例内，读取、修改密码凭证的Data不能交错
+	mu sync.Mutex
 }
 
 func (p *Provider) repository() *auth.Repository {
@@ -38,19 +84,69 @@ func (p *Provider) Name() string {
 	return "password"
 }
 
+// passwordData 密码登录凭证的Data
+// 重设密码的token（只保存hash）也放在这里，设置新密码时会覆盖，未使用的token随即作废
+type passwordData struct {
+	PasswordHash   string `json:"password_hash"` // PHC格式，见Hasher
+	ResetHash      string `json:"reset_hash,omitempty"`
+	ResetExpiredAt int64  `json:"reset_expired_at,omitempty"`
+	ResetSentAt    int64  `json:"reset_sent_at,omitempty"`
+	// History 以前的密码hash，最近的在前（PasswordPolicy.History）
+	History []string `json:"history,omitempty"`
+}
+
+// loadData 读取凭证的Data
+func (p *Provider) loadData(identity *auth.UserIdentity) (*passwordData, error) {
+	data := &passwordData{}
+	if identity.Data == nil {
+		return data, nil
+	}
+	if err := json.Unmarshal([]byte(*identity.Data), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func (p *Provider) passwordMatched(identity *auth.UserIdentity, password string) bool {
-	data := struct {
-		PasswordHash string `json:"password_hash"`
-	}{}
-	if err := json.Unmarshal([]byte(*identity.Data), &data); err != nil {
+	data, err := p.loadData(identity)
+	if err != nil {
 		return false
 	}
-	// 打包
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, []byte(password))
+	matched, err := verifyHash(data.PasswordHash, []byte(password))
+	if err != nil {
+		log.Printf("password: 验证密码失败: %s", err)
+	}
+	return matched
+}
 
-	err := bcrypt.CompareHashAndPassword([]byte(data.PasswordHash), buf.Bytes())
-	return err == nil
+// rehash 已保存的hash算法、参数过时了，趁登录成功（有明文密码）重新hash
+// 失败不影响登录
+func (p *Provider) rehash(username, password string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 重新读取，期间可能已经改了密码
+	identity, err := p.repository().FindIdentity(p.Name(), username)
+	if err != nil {
+		return
+	}
+	data, err := p.loadData(identity)
+	if err != nil || !p.hasher.NeedsRehash(data.PasswordHash) {
+		return
+	}
+	if matched, _ := verifyHash(data.PasswordHash, []byte(password)); !matched {
+		return
+	}
+	hash, err := p.hasher.Hash([]byte(password))
+	if err != nil {
+		log.Printf("password: 重新hash失败: %s", err)
+		return
+	}
+	data.PasswordHash = hash
+	// 期间改了密码的（没有更新），不用管
+	if _, err := p.repository().CompareAndUpdateIdentityData(identity, data); err != nil {
+		log.Printf("password: 保存重新hash的密码失败: %s", err)
+	}
 }
 
 // Login 登陆; implemented Login with LoginProvider interface
@@ -73,31 +169,70 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	if !p.passwordMatched(identity, credentials.Password) {
 		return nil, errors.New("无效的用户名或密码")
 	}
+	if data, err := p.loadData(identity); err == nil && p.hasher.NeedsRehash(data.PasswordHash) {
+		p.rehash(credentials.Username, credentials.Password)
+	}
 
 	// find user
 	user, err = p.repository().Find(identity.UserID)
-	if err == gorm.ErrRecordNotFound {
+	if err == auth.ErrRecordNotFound {
 		return nil, errors.New("无效的用户名或密码")
 	}
 	return
 }
 
-// PasswordHash 获取hash的密码
+// Account 从登录凭证中取出用户名; implemented Account with ThrottledProvider interface
+// 用于按用户名限制登录失败次数（防暴力破解）
+func (p *Provider) Account(payload []byte) string {
+	credentials := struct {
+		Username string `json:"username"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return ""
+	}
+	return credentials.Username
+}
+
+// Identify 设置用户名、密码; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户（如通过微信登录的）绑定密码登录（Service.Link）
+// 与Login不同，这里的用户名、密码是新设置的
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	// params
+	credentials := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return "", nil, err
+	}
+	if credentials.Username == "" {
+		return "", nil, errors.New("用户名不能为空")
+	}
+
+	// 创建密码
+	if err := p.validate(credentials.Password, nil); err != nil {
+		return "", nil, err
+	}
+	data = &passwordData{
+		PasswordHash: p.passwordHash(credentials.Password),
+	}
+	return credentials.Username, data, nil
+}
+
+// PasswordHash 获取hash的密码（Config.Hasher）
 func (p *Provider) passwordHash(password string) string {
-	// 打包
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, []byte(password))
-	hash, err := bcrypt.GenerateFromPassword(buf.Bytes(), bcrypt.DefaultCost) // 50ms
+	hash, err := p.hasher.Hash([]byte(password))
 	if err != nil {
 		panic(err)
 	}
-
-	// base64
-	return string(hash) // bcrypt 本身有base64编码
+	return hash
 }
 
 // Register 注册用户
 func (p *Provider) Register(username, password string, bindUserID ...uint64) (user *auth.User, err error) {
+	if err := p.validate(password, nil); err != nil {
+		return nil, err
+	}
 	if len(bindUserID) == 1 {
 		// 如果指定用户
 		user, err = p.repository().Find(bindUserID[0])
@@ -110,13 +245,8 @@ func (p *Provider) Register(username, password string, bindUserID ...uint64) (us
 	}
 
 	// 创建密码
-	if !isValid(password) {
-		return nil, errors.New("密码长度须大于8位，包含大小写，特殊字符、数字")
-	}
 	passwordHash := p.passwordHash(password)
-	data := struct {
-		PasswordHash string `json:"password_hash"`
-	}{
+	data := &passwordData{
 		PasswordHash: passwordHash,
 	}
 	_, err = p.repository().CreateIdentity(user.ID, p.Name(), username, data)
@@ -127,52 +257,87 @@ func (p *Provider) Register(username, password string, bindUserID ...uint64) (us
 }
 
 // SetPassword 重设密码
+// 密码不符合规则时返回*PolicyError；期间被其他实例修改过的，返回auth.ErrIdentityChanged
 func (p *Provider) SetPassword(username, password string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	// 找到用户
 	identity, err := p.repository().FindIdentity(p.Name(), username)
 	if err != nil {
 		return err
 	}
+	data, err := p.loadData(identity)
+	if err != nil {
+		return err
+	}
 
 	// 创建密码
-	if !isValid(password) {
-		return errors.New("密码长度须大于8位，包含大小写，特殊字符、数字")
-	}
-	passwordHash := p.passwordHash(password)
-	data := struct {
-		PasswordHash string `json:"password_hash"`
-	}{
-		PasswordHash: passwordHash,
+	if err := p.validate(password, data); err != nil {
+		return err
 	}
 
 	// 设置密码
-	p.repository().UpdateIdentityData(identity, data)
+	ok, err := p.repository().CompareAndUpdateIdentityData(identity, p.newPasswordData(password, data))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return auth.ErrIdentityChanged
+	}
 	return nil
 }
 
-// https://stackoverflow.com/a/56139457
-func isValid(s string) bool {
-	var (
-		hasMinLen  = false
-		hasUpper   = false
-		hasLower   = false
-		hasNumber  = false
-		hasSpecial = false
-	)
-	if len(s) > 7 {
-		hasMinLen = true
-	}
-	for _, char := range s {
-		switch {
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsLower(char):
-			hasLower = true
-		case unicode.IsNumber(char):
-			hasNumber = true
-		case unicode.IsPunct(char) || unicode.IsSymbol(char):
-			hasSpecial = true
+// Policy 密码规则（可用于前端提示）
+func (p *Provider) Policy() PasswordPolicy {
+	return p.policy
+}
+
+// validate 检查密码规则，修改密码时（data不为空）还要检查是否用过
+func (p *Provider) validate(password string, data *passwordData) error {
+	err := p.policy.Validate(password)
+	if data == nil || p.policy.History == 0 {
+		return err
+	}
+	policyError, _ := err.(*PolicyError)
+	if policyError != nil && policyError.Has(RuleMaxLength) {
+		return err // 太长的不算hash了
+	}
+	if !p.reused(password, data) {
+		return err
+	}
+	if policyError == nil {
+		policyError = &PolicyError{}
+	}
+	policyError.add(RuleReused, fmt.Sprintf("不能使用最近%d次用过的密码", p.policy.History))
+	return policyError
+}
+
+// reused 是否与当前密码、最近History-1次的密码相同
+func (p *Provider) reused(password string, data *passwordData) bool {
+	hashes := append([]string{data.PasswordHash}, data.History...)
+	if len(hashes) > p.policy.History {
+		hashes = hashes[:p.policy.History]
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		if matched, _ := verifyHash(hash, []byte(password)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// newPasswordData 设置新密码，当前密码放入History（未使用的重设密码token随即作废）
+func (p *Provider) newPasswordData(password string, old *passwordData) *passwordData {
+	data := &passwordData{PasswordHash: p.passwordHash(password)}
+	if p.policy.History > 1 && old.PasswordHash != "" {
+		data.History = append([]string{old.PasswordHash}, old.History...)
+		if len(data.History) > p.policy.History-1 {
+			data.History = data.History[:p.policy.History-1]
 		}
 	}
-	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
+	return data
 }
@@ -0,0 +1,173 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy 密码规则
+type PasswordPolicy struct {
+	// MinLength、MaxLength 长度（按字符计），为空则分别为8、64
+	MinLength int
+	MaxLength int
+	// 须包含的字符类型
+	RequireUpper  bool
+	RequireLower  bool
+	RequireNumber bool
+	RequireSymbol bool
+	// RejectCommon 拒绝常见密码（不区分大小写）
+	RejectCommon bool
+	// CommonPasswords 常见密码列表，为空则使用内置的列表（CommonPasswords）
+	CommonPasswords []string
+	// History 不能与最近几次的密码相同（包括当前密码），为空则不限
+	// 只对修改密码（SetPassword、ConfirmReset）有效
+	History int
+
+	common map[string]bool
+}
+
+// DefaultPolicy 默认的密码规则
+var DefaultPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     64,
+	RequireUpper:  true,
+	RequireLower:  true,
+	RequireNumber: true,
+	RequireSymbol: true,
+	RejectCommon:  true,
+}
+
+// 密码规则（Violation.Rule）
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUpper     = "upper"
+	RuleLower     = "lower"
+	RuleNumber    = "number"
+	RuleSymbol    = "symbol"
+	RuleCommon    = "common"
+	RuleReused    = "reused"
+)
+
+// Violation 不符合的一条规则
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError 密码不符合规则，列出所有不符合的规则
+type PolicyError struct {
+	Violations []Violation `json:"violations"`
+}
+
+// Error implemented Error with error interface
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "；")
+}
+
+// Has 是否违反了某条规则
+func (e *PolicyError) Has(rule string) bool {
+	for _, v := range e.Violations {
+		if v.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *PolicyError) add(rule, message string) {
+	e.Violations = append(e.Violations, Violation{Rule: rule, Message: message})
+}
+
+// compile 常见密码列表转为map，NewProvider时调用一次
+func (policy *PasswordPolicy) compile() {
+	if !policy.RejectCommon {
+		return
+	}
+	list := policy.CommonPasswords
+	if len(list) == 0 {
+		list = CommonPasswords
+	}
+	policy.common = make(map[string]bool, len(list))
+	for _, password := range list {
+		policy.common[strings.ToLower(password)] = true
+	}
+}
+
+// isCommon 是否常见密码
+func (policy *PasswordPolicy) isCommon(password string) bool {
+	password = strings.ToLower(password)
+	if policy.common != nil {
+		return policy.common[password]
+	}
+	list := policy.CommonPasswords
+	if len(list) == 0 {
+		list = CommonPasswords
+	}
+	for _, common := range list {
+		if strings.ToLower(common) == password {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 检查密码，不符合则返回*PolicyError
+// 不检查History（需要已保存的密码）
+func (policy *PasswordPolicy) Validate(password string) error {
+	e := &PolicyError{}
+	length := utf8.RuneCountInString(password)
+	minLength, maxLength := policy.MinLength, policy.MaxLength
+	if minLength == 0 {
+		minLength = 8
+	}
+	if maxLength == 0 {
+		maxLength = 64
+	}
+	if length < minLength {
+		e.add(RuleMinLength, fmt.Sprintf("密码不能少于%d位", minLength))
+	}
+	if length > maxLength {
+		e.add(RuleMaxLength, fmt.Sprintf("密码不能多于%d位", maxLength))
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSymbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsNumber(char):
+			hasNumber = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		e.add(RuleUpper, "密码须包含大写字母")
+	}
+	if policy.RequireLower && !hasLower {
+		e.add(RuleLower, "密码须包含小写字母")
+	}
+	if policy.RequireNumber && !hasNumber {
+		e.add(RuleNumber, "密码须包含数字")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		e.add(RuleSymbol, "密码须包含特殊字符")
+	}
+	if policy.RejectCommon && policy.isCommon(password) {
+		e.add(RuleCommon, "密码太常见，容易被猜到")
+	}
+
+	if len(e.Violations) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package password_test
+
+import (
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := password.DefaultPolicy
+	if err := policy.Validate("Xk9#mLp2qR"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 列出所有不符合的规则
+	err, ok := policy.Validate("abc").(*password.PolicyError)
+	if !ok {
+		t.Fatal("理应返回*PolicyError")
+	}
+	for _, rule := range []string{password.RuleMinLength, password.RuleUpper, password.RuleNumber, password.RuleSymbol} {
+		if !err.Has(rule) {
+			t.Fatalf("理应违反%s：%+v", rule, err.Violations)
+		}
+	}
+	if err.Has(password.RuleLower) {
+		t.Fatalf("不应违反%s：%+v", password.RuleLower, err.Violations)
+	}
+
+	// 常见密码，不区分大小写
+	err, _ = policy.Validate("p@SSW0RD").(*password.PolicyError)
+	if err == nil || !err.Has(password.RuleCommon) || len(err.Violations) != 1 {
+		t.Fatalf("常见密码理应被拒绝：%v", err)
+	}
+}
+
+func TestCustomPolicy(t *testing.T) {
+	policy := password.PasswordPolicy{
+		MinLength:       12,
+		MaxLength:       16,
+		RejectCommon:    true,
+		CommonPasswords: []string{"correcthorsebattery"},
+	}
+	if err := policy.Validate("correct horse"); err != nil {
+		t.Fatal("不要求字符类型", err)
+	}
+	err, _ := policy.Validate("CorrectHorseBattery").(*password.PolicyError)
+	if err == nil || !err.Has(password.RuleMaxLength) || !err.Has(password.RuleCommon) {
+		t.Fatalf("理应违反max_length、common：%v", err)
+	}
+	if err := policy.Validate("password"); err == nil || err.(*password.PolicyError).Has(password.RuleCommon) {
+		t.Fatalf("自定义列表不包括内置的：%v", err)
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	policy := password.DefaultPolicy
+	policy.History = 3
+	provider := password.NewProvider(&password.Config{
+		Auth:   instance,
+		Hasher: password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1},
+		Policy: &policy,
+	})
+	if _, err := provider.Register("zhangsan", "First-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+
+	reused := func(pw string) bool {
+		err, ok := provider.SetPassword("zhangsan", pw).(*password.PolicyError)
+		return ok && err.Has(password.RuleReused)
+	}
+	if !reused("First-passw0rd") {
+		t.Fatal("当前密码不能再用")
+	}
+	for _, pw := range []string{"Second-passw0rd", "Third-passw0rd"} {
+		if err := provider.SetPassword("zhangsan", pw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reused("First-passw0rd") || !reused("Second-passw0rd") {
+		t.Fatal("最近3次的密码不能再用")
+	}
+	if err := provider.SetPassword("zhangsan", "Fourth-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.SetPassword("zhangsan", "First-passw0rd"); err != nil {
+		t.Fatal("3次以前的密码可以再用", err)
+	}
+}
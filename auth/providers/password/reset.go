@@ -0,0 +1,224 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+// ErrInvalidResetToken 重设密码的token无效、已过期或已使用
+var ErrInvalidResetToken = errors.New("重设密码的链接无效或已过期，请重新获取")
+
+// ErrResetTooFrequent 发送太频繁
+var ErrResetTooFrequent = errors.New("发送太频繁，请稍后再试")
+
+// ErrResetNotConfigured 没有配置Config.DeliverReset，不能重设密码
+var ErrResetNotConfigured = errors.New("未开通重设密码")
+
+// RequestReset 申请重设密码，通过Config.DeliverReset发送token
+// 用户名不存在时什么都不做，也不返回错误（避免被用来探测用户名）
+// 同一个用户须间隔ResetInterval，否则返回ErrResetTooFrequent
+// 没有配置DeliverReset的，返回ErrResetNotConfigured
+func (p *Provider) RequestReset(username string) (err error) {
+	if p.deliverReset == nil {
+		return ErrResetNotConfigured
+	}
+	user, token, err := p.saveResetToken(username)
+	if err != nil || user == nil {
+		return err
+	}
+
+	// 发送（邮件、短信）可能很慢，不能占着锁，否则会卡住其他登录、修改密码
+	return p.deliverReset(user, username, token)
+}
+
+// saveResetToken 生成并保存重设密码的token，用户名不存在时返回nil
+func (p *Provider) saveResetToken(username string) (user *auth.User, token string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	identity, err := p.repository().FindIdentity(p.Name(), username)
+	if err == auth.ErrRecordNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	user, err = p.repository().Find(identity.UserID)
+	if err == auth.ErrRecordNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := p.loadData(identity)
+	if err != nil {
+		return nil, "", err
+	}
+	now := p.auth.Now()
+	if data.ResetSentAt != 0 && now.Unix()-data.ResetSentAt < int64(p.resetInterval.Seconds()) {
+		return nil, "", ErrResetTooFrequent
+	}
+
+	// token里带上用户名，确认时才能找到凭证
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		panic(err.Error())
+	}
+	token = base64.RawURLEncoding.EncodeToString([]byte(username)) + "." +
+		base64.RawURLEncoding.EncodeToString(secret)
+	data.ResetHash = hashResetToken(token)
+	data.ResetExpiredAt = now.Add(p.resetLife).Unix()
+	data.ResetSentAt = now.Unix()
+	ok, err := p.repository().CompareAndUpdateIdentityData(identity, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", auth.ErrIdentityChanged
+	}
+	return user, token, nil
+}
+
+// ConfirmReset 用token重设密码，密码不符合规则时返回*PolicyError（token仍然有效）
+// 成功后token作废，该用户的所有会话注销、已颁发的jwt立即失效，须重新登录
+func (p *Provider) ConfirmReset(token, password string) (user *auth.User, err error) {
+	return p.confirmReset(p.auth.Service, token, password)
+}
+
+func (p *Provider) confirmReset(service *auth.Service, token, password string) (user *auth.User, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidResetToken
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidResetToken
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	identity, err := p.repository().FindIdentity(p.Name(), string(username))
+	if err == auth.ErrRecordNotFound {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.loadData(identity)
+	if err != nil {
+		return nil, err
+	}
+	if data.ResetHash == "" || p.auth.Now().Unix() > data.ResetExpiredAt ||
+		subtle.ConstantTimeCompare([]byte(hashResetToken(token)), []byte(data.ResetHash)) != 1 {
+		return nil, ErrInvalidResetToken
+	}
+	if err := p.validate(password, data); err != nil {
+		return nil, err
+	}
+	user, err = p.repository().Find(identity.UserID)
+	if err == auth.ErrRecordNotFound {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 设置密码，同时清除token
+	// 期间被修改过的（如token已被另一个请求用掉），作废
+	ok, err := p.repository().CompareAndUpdateIdentityData(identity, p.newPasswordData(password, data))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidResetToken
+	}
+
+	// 旧密码可能已泄露，全部重新登录
+	if err = service.RevokeAllSessions(user, "password_reset"); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// HandleRequestReset 申请重设密码
+// 参数：body: {"username": "..."}
+// 无论用户名是否存在都返回成功；没有配置DeliverReset的返回501
+// 须自行添加路由，如：
+// r.Post("/api/password/reset", provider.HandleRequestReset)
+func (p *Provider) HandleRequestReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if p.deliverReset == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(map[string]string{"error": ErrResetNotConfigured.Error()})
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "request body读取错误"})
+		return
+	}
+	params := struct {
+		Username string `json:"username"`
+	}{}
+	json.Unmarshal(payload, &params)
+	if params.Username == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "用户名不能为空"})
+		return
+	}
+
+	// 太频繁、发送失败也不告诉客户端，否则可以据此判断用户名是否存在
+	if err := p.RequestReset(params.Username); err != nil && err != ErrResetTooFrequent {
+		log.Printf("password: 发送重设密码token失败: %s", err)
+	}
+	json.NewEncoder(w).Encode("如果该账号存在，重设密码的链接已经发出")
+}
+
+// HandleConfirmReset 重设密码
+// 参数：body: {"token": "...", "password": "..."}
+// 须自行添加路由，如：
+// r.Post("/api/password/reset/confirm", provider.HandleConfirmReset)
+func (p *Provider) HandleConfirmReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "request body读取错误"})
+		return
+	}
+	params := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	json.Unmarshal(payload, &params)
+
+	if _, err := p.confirmReset(p.auth.Service.WithRequest(r), params.Token, params.Password); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if policyError, ok := err.(*PolicyError); ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "violations": policyError.Violations})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode("密码已重设，请重新登录")
+}
+
+// hashResetToken token是高熵随机数，sha256即可
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
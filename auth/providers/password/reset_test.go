@@ -0,0 +1,240 @@
+package password_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+)
+
+var secretKey = []byte("aasdfkjksjdfaaasdfkjksjdfa123405")
+
+func TestPasswordReset(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	sent := map[string]string{}
+	provider := password.NewProvider(&password.Config{
+		Auth: instance,
+		DeliverReset: func(user *auth.User, username, token string) error {
+			sent[username] = token
+			return nil
+		},
+	})
+	instance.RegisterProvider(provider)
+	if _, err := provider.Register("zhangsan", "Old-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := instance.Service.Login("password", []byte(`{"username": "zhangsan", "password": "Old-passw0rd"}`), true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户名不存在，不报错也不发送
+	if err := provider.RequestReset("nobody"); err != nil || len(sent) != 0 {
+		t.Fatalf("不存在的用户名不应发送：%v, %v", err, sent)
+	}
+	if err := provider.RequestReset("zhangsan"); err != nil {
+		t.Fatal(err)
+	}
+	token := sent["zhangsan"]
+	if token == "" {
+		t.Fatal("理应发送token")
+	}
+	if err := provider.RequestReset("zhangsan"); err != password.ErrResetTooFrequent {
+		t.Fatalf("间隔太短不应发送：%v", err)
+	}
+
+	// 错误的token、不合格的密码
+	if _, err := provider.ConfirmReset(token+"x", "New-passw0rd"); err != password.ErrInvalidResetToken {
+		t.Fatalf("错误的token不应通过：%v", err)
+	}
+	if _, err := provider.ConfirmReset(token, "weak"); err == nil {
+		t.Fatal("不合格的密码不应通过")
+	}
+
+	mu.Lock()
+	now = now.Add(time.Second)
+	mu.Unlock()
+	if _, err := provider.ConfirmReset(token, "New-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只能用一次
+	if _, err := provider.ConfirmReset(token, "Other-passw0rd"); err != password.ErrInvalidResetToken {
+		t.Fatalf("用过的token不应再次通过：%v", err)
+	}
+
+	// 旧密码不能登录，新密码可以
+	if _, err := provider.Login([]byte(`{"username": "zhangsan", "password": "Old-passw0rd"}`)); err == nil {
+		t.Fatal("旧密码不应登录")
+	}
+	if _, err := provider.Login([]byte(`{"username": "zhangsan", "password": "New-passw0rd"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 原有的refresh token、jwt都失效
+	if _, _, err := instance.Service.Renew(*tokens.RefreshToken); err == nil {
+		t.Fatal("重设密码后refresh token理应失效")
+	}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	jwtToken, err := parser.Parse(tokens.Token, func(*jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !instance.Service.JwtInvalid(jwtToken) {
+		t.Fatal("重设密码后jwt理应失效")
+	}
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	sent := map[string]string{}
+	provider := password.NewProvider(&password.Config{
+		Auth: instance,
+		DeliverReset: func(user *auth.User, username, token string) error {
+			sent[username] = token
+			return nil
+		},
+	})
+	instance.RegisterProvider(provider)
+	if _, err := provider.Register("lisi", "Old-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.RequestReset("lisi"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	now = now.Add(password.DefaultResetLife + time.Second)
+	mu.Unlock()
+	if _, err := provider.ConfirmReset(sent["lisi"], "New-passw0rd"); err != password.ErrInvalidResetToken {
+		t.Fatalf("过期的token不应通过：%v", err)
+	}
+}
+
+func TestHandleResetPassword(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	sent := map[string]string{}
+	provider := password.NewProvider(&password.Config{
+		Auth: instance,
+		DeliverReset: func(user *auth.User, username, token string) error {
+			sent[username] = token
+			return nil
+		},
+	})
+	instance.RegisterProvider(provider)
+	if _, err := provider.Register("wangwu", "Old-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	post := func(handler http.HandlerFunc, body interface{}) int {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "http://localhost/api/password/reset", bytes.NewBuffer(payload))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result().StatusCode
+	}
+
+	// 用户名存在与否，返回一样
+	if status := post(provider.HandleRequestReset, map[string]string{"username": "nobody"}); status != http.StatusOK {
+		t.Fatalf("理应返回成功：%d", status)
+	}
+	if status := post(provider.HandleRequestReset, map[string]string{"username": "wangwu"}); status != http.StatusOK {
+		t.Fatalf("理应返回成功：%d", status)
+	}
+	if status := post(provider.HandleRequestReset, map[string]string{"username": "wangwu"}); status != http.StatusOK {
+		t.Fatalf("太频繁也理应返回成功：%d", status)
+	}
+
+	if status := post(provider.HandleConfirmReset, map[string]string{"token": "abc", "password": "New-passw0rd"}); status != http.StatusBadRequest {
+		t.Fatalf("错误的token理应400：%d", status)
+	}
+	if status := post(provider.HandleConfirmReset, map[string]string{"token": sent["wangwu"], "password": "New-passw0rd"}); status != http.StatusOK {
+		t.Fatalf("理应重设成功：%d", status)
+	}
+}
+
+func TestResetNotConfigured(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := password.NewProvider(&password.Config{Auth: instance})
+	if err := provider.RequestReset("zhaoliu"); err != password.ErrResetNotConfigured {
+		t.Fatalf("没有配置DeliverReset理应返回ErrResetNotConfigured：%v", err)
+	}
+	req := httptest.NewRequest("POST", "http://localhost/api/password/reset", bytes.NewBufferString(`{"username": "zhaoliu"}`))
+	w := httptest.NewRecorder()
+	provider.HandleRequestReset(w, req)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("没有配置DeliverReset理应501：%d", w.Code)
+	}
+}
+
+func TestResetDeliverUnlocked(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	delivering := make(chan struct{})
+	release := make(chan struct{})
+	provider := password.NewProvider(&password.Config{
+		Auth: instance,
+		// 很慢的邮件服务
+		DeliverReset: func(user *auth.User, username, token string) error {
+			close(delivering)
+			<-release
+			return nil
+		},
+	})
+	instance.RegisterProvider(provider)
+	if _, err := provider.Register("sunqi", "Old-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- provider.RequestReset("sunqi")
+	}()
+	<-delivering
+
+	// 发送期间，修改密码不应被卡住
+	changed := make(chan error)
+	go func() {
+		changed <- provider.SetPassword("sunqi", "New-passw0rd")
+	}()
+	select {
+	case err := <-changed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("发送重设密码期间，修改密码被卡住了")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
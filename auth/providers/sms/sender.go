@@ -0,0 +1,38 @@
+package sms
+
+import (
+	"log"
+	"sync"
+)
+
+// Sender 发送短信验证码，由应用对接短信服务商（阿里云、腾讯云……）
+type Sender interface {
+	Send(mobile, code string) error
+}
+
+// NewLogSender 只打日志、不发短信，用于开发和测试
+func NewLogSender() *LogSender {
+	return &LogSender{codes: map[string]string{}}
+}
+
+// LogSender 只打日志、不发短信，并记下每个手机号最后收到的验证码
+type LogSender struct {
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+// Send implemented Send with Sender interface
+func (s *LogSender) Send(mobile, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[mobile] = code
+	log.Printf("sms: 发送验证码 %s 到 %s", code, mobile)
+	return nil
+}
+
+// Code 手机号最后收到的验证码
+func (s *LogSender) Code(mobile string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codes[mobile]
+}
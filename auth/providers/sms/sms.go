@@ -0,0 +1,324 @@
+package sms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+// NewProvider 创建实例
+func NewProvider(config *Config) *Provider {
+	if config.Sender == nil {
+		panic("sms: 缺少Sender")
+	}
+	if len(config.SecretKey) == 0 {
+		panic("sms: 缺少SecretKey")
+	}
+	p := &Provider{
+		auth:         config.Auth,
+		sender:       config.Sender,
+		store:        config.Store,
+		secretKey:    config.SecretKey,
+		codeLength:   config.CodeLength,
+		codeLife:     config.CodeLife,
+		maxAttempts:  config.MaxAttempts,
+		sendInterval: config.SendInterval,
+		maxSends:     config.MaxSends,
+		sendWindow:   config.SendWindow,
+	}
+	if p.store == nil {
+		p.store = newMemoryCodeStore(p.auth.Now)
+	}
+	if p.codeLength == 0 {
+		p.codeLength = 6
+	}
+	if p.codeLife == 0 {
+		p.codeLife = DefaultCodeLife
+	}
+	if p.maxAttempts == 0 {
+		p.maxAttempts = DefaultMaxAttempts
+	}
+	if p.sendInterval == 0 {
+		p.sendInterval = DefaultSendInterval
+	}
+	if p.maxSends == 0 {
+		p.maxSends = DefaultMaxSends
+	}
+	if p.sendWindow == 0 {
+		p.sendWindow = DefaultSendWindow
+	}
+	return p
+}
+
+// Config 配置
+type Config struct {
+	Auth   *auth.Auth
+	Sender Sender
+	// Store 验证码记录，为空则使用内存记录（同NewMemoryCodeStore，但用auth的时钟），多实例部署时须共享
+	Store CodeStore
+	// SecretKey 验证码只保存HMAC，6位数字直接hash可以被穷举
+	SecretKey []byte
+	// CodeLength 验证码位数，为空则为6
+	CodeLength int
+	// CodeLife 验证码有效时长，为空则使用DefaultCodeLife
+	CodeLife time.Duration
+	// MaxAttempts 同一个验证码最多验证几次，超过则作废，为空则使用DefaultMaxAttempts
+	MaxAttempts int
+	// SendInterval 同一个手机号两次发送的最小间隔，为空则使用DefaultSendInterval
+	SendInterval time.Duration
+	// MaxSends、SendWindow 同一个手机号在SendWindow内最多发送MaxSends次
+	MaxSends   int
+	SendWindow time.Duration
+}
+
+// 默认配置
+const (
+	// DefaultCodeLife 默认验证码有效时长
+	DefaultCodeLife = 5 * time.Minute
+	// DefaultMaxAttempts 默认同一个验证码最多验证次数
+	DefaultMaxAttempts = 5
+	// DefaultSendInterval 默认两次发送的最小间隔
+	DefaultSendInterval = 1 * time.Minute
+	// DefaultMaxSends 默认SendWindow内最多发送次数
+	DefaultMaxSends = 10
+	// DefaultSendWindow 默认发送次数的计数周期
+	DefaultSendWindow = 24 * time.Hour
+)
+
+// ErrInvalidMobile 无效的手机号
+var ErrInvalidMobile = errors.New("无效的手机号")
+
+// ErrInvalidCode 验证码错误、已过期、已使用或者失败次数过多
+var ErrInvalidCode = errors.New("验证码错误或已过期")
+
+// ErrSendTooFrequent 发送太频繁
+var ErrSendTooFrequent = errors.New("发送太频繁，请稍后再试")
+
+// mobilePattern 手机号，可以带国际区号（+86……）
+var mobilePattern = regexp.MustCompile(`^\+?[0-9]{5,15}$`)
+
+// Provider 通过短信验证码 登陆
+type Provider struct {
+	auth         *auth.Auth
+	sender       Sender
+	store        CodeStore
+	secretKey    []byte
+	codeLength   int
+	codeLife     time.Duration
+	maxAttempts  int
+	sendInterval time.Duration
+	maxSends     int
+	sendWindow   time.Duration
+
+	// 同一个实例内，读取、修改验证码记录不能交错
+	mu sync.Mutex
+}
+
+func (p *Provider) repository() *auth.Repository {
+	return p.auth.Repository
+}
+
+// Name 获取provider名字; implemented Name with LoginProvider interface
+func (p *Provider) Name() string {
+	return "sms"
+}
+
+// SendCode 发送验证码
+// 同一个手机号，须间隔SendInterval，并且SendWindow内不超过MaxSends次，否则返回ErrSendTooFrequent
+func (p *Provider) SendCode(mobile string) error {
+	mobile = strings.TrimSpace(mobile)
+	if !mobilePattern.MatchString(mobile) {
+		return ErrInvalidMobile
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.auth.Now()
+	record, err := p.store.Get(mobile)
+	if err != nil {
+		return err
+	}
+	if record == nil || now.Sub(record.WindowStart) >= p.sendWindow {
+		record = &Code{WindowStart: now}
+	}
+	if now.Sub(record.SentAt) < p.sendInterval || record.Sends >= p.maxSends {
+		return ErrSendTooFrequent
+	}
+
+	code := p.newCode()
+	record.Hash = p.hash(mobile, code)
+	record.ExpiredAt = now.Add(p.codeLife)
+	record.Attempts = 0
+	record.SentAt = now
+	record.Sends++
+	if err := p.store.Save(mobile, record, p.ttl(record)); err != nil {
+		return err
+	}
+	return p.sender.Send(mobile, code)
+}
+
+// HandleSendCode 发送验证码
+// 参数：body: {"mobile": "13800000000"}
+// 须自行添加路由，如：
+// r.Post("/api/login/sms", provider.HandleSendCode)
+func (p *Provider) HandleSendCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "request body读取错误"})
+		return
+	}
+	params := struct {
+		Mobile string `json:"mobile"`
+	}{}
+	json.Unmarshal(payload, &params)
+
+	switch err := p.SendCode(params.Mobile); err {
+	case nil:
+		json.NewEncoder(w).Encode(map[string]interface{}{"expires_in": int64(p.codeLife.Seconds())})
+	case ErrInvalidMobile:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	case ErrSendTooFrequent:
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+// Login 登陆; implemented Login with LoginProvider interface
+// 参数：{"mobile", "code"}
+// 用户名与钉钉登录一致（<mobile>@telephone），同一个手机号是同一个用户
+func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
+	mobile, _, err := p.Identify(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 如果用户存在，直接返回
+	providerName := p.Name()
+	user, err = p.repository().FindByOpenID(providerName, mobile)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	// 第一次用短信登录，先找这个手机号的用户（比如钉钉登录过的）
+	username := mobile + "@telephone"
+	user, err = p.repository().FindByUsername(username)
+	if err != nil && err != auth.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == auth.ErrRecordNotFound {
+		user, err = p.repository().Create(username, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 然后创建登陆凭证
+	_, err = p.repository().CreateIdentity(user.ID, providerName, mobile)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Identify 验证凭证; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户绑定手机号（Service.Link），参数与Login一样
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	credentials := struct {
+		Mobile string `json:"mobile"`
+		Code   string `json:"code"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return "", nil, err
+	}
+	mobile := strings.TrimSpace(credentials.Mobile)
+	if err := p.verify(mobile, strings.TrimSpace(credentials.Code)); err != nil {
+		return "", nil, err
+	}
+	return mobile, nil, nil
+}
+
+// Account 从登录凭证中取出手机号; implemented Account with ThrottledProvider interface
+// 除了每个验证码的MaxAttempts，还按手机号、IP限制登录失败次数
+func (p *Provider) Account(payload []byte) string {
+	credentials := struct {
+		Mobile string `json:"mobile"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(credentials.Mobile)
+}
+
+// verify 验证并作废验证码
+func (p *Provider) verify(mobile, code string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	record, err := p.store.Get(mobile)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Hash == "" || p.auth.Now().After(record.ExpiredAt) {
+		return ErrInvalidCode
+	}
+	if !hmac.Equal([]byte(p.hash(mobile, code)), []byte(record.Hash)) {
+		// 失败次数过多，作废
+		record.Attempts++
+		if record.Attempts >= p.maxAttempts {
+			record.Hash = ""
+		}
+		if err := p.store.Save(mobile, record, p.ttl(record)); err != nil {
+			return err
+		}
+		return ErrInvalidCode
+	}
+
+	// 只能用一次（保留记录，发送次数继续计数）
+	record.Hash = ""
+	return p.store.Save(mobile, record, p.ttl(record))
+}
+
+// ttl 记录要保留到计数周期结束
+func (p *Provider) ttl(record *Code) time.Duration {
+	return record.WindowStart.Add(p.sendWindow).Sub(p.auth.Now())
+}
+
+// newCode 随机数字验证码
+func (p *Provider) newCode() string {
+	digits := make([]byte, p.codeLength)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			panic(err.Error())
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	return string(digits)
+}
+
+// hash HMAC-SHA256(SecretKey, mobile:code)
+func (p *Provider) hash(mobile, code string) string {
+	mac := hmac.New(sha256.New, p.secretKey)
+	mac.Write([]byte(mobile + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,176 @@
+package sms_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/sms"
+)
+
+var secretKey = []byte("aasdfkjksjdfaaasdfkjksjdfa123405") // 32 bytes
+
+func TestSMSLogin(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	sender := sms.NewLogSender()
+	provider := sms.NewProvider(&sms.Config{
+		Auth:      instance,
+		Sender:    sender,
+		SecretKey: secretKey,
+	})
+	instance.RegisterProvider(provider)
+	mobile := "13800000000"
+
+	// 钉钉登录过的用户
+	existing, err := instance.Repository.Create(mobile+"@telephone", "张三")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.SendCode("not-a-mobile"); err != sms.ErrInvalidMobile {
+		t.Fatalf("无效的手机号不应发送：%v", err)
+	}
+	if err := provider.SendCode(mobile); err != nil {
+		t.Fatal(err)
+	}
+	code := sender.Code(mobile)
+	if len(code) != 6 {
+		t.Fatalf("理应发送6位验证码：%s", code)
+	}
+
+	// 登录，关联到同一个手机号的用户
+	tokens, err := instance.Service.Login("sms", []byte(`{"mobile": "13800000000", "code": "`+code+`"}`), true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token == "" {
+		t.Fatal("理应颁发token")
+	}
+	if user, err := instance.Repository.FindByOpenID("sms", mobile); err != nil || user.ID != existing.ID {
+		t.Fatalf("理应关联到%s@telephone：%+v, %v", mobile, user, err)
+	}
+
+	// 只能用一次
+	if _, err := provider.Login([]byte(`{"mobile": "13800000000", "code": "` + code + `"}`)); err != sms.ErrInvalidCode {
+		t.Fatalf("用过的验证码不应再次通过：%v", err)
+	}
+
+	// 过期
+	advance(sms.DefaultSendInterval)
+	if err := provider.SendCode(mobile); err != nil {
+		t.Fatal(err)
+	}
+	advance(sms.DefaultCodeLife + time.Second)
+	if _, err := provider.Login([]byte(`{"mobile": "13800000000", "code": "` + sender.Code(mobile) + `"}`)); err != sms.ErrInvalidCode {
+		t.Fatalf("过期的验证码不应通过：%v", err)
+	}
+}
+
+func TestSMSLimits(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	})
+	defer instance.Close()
+	sender := sms.NewLogSender()
+	provider := sms.NewProvider(&sms.Config{
+		Auth:      instance,
+		Sender:    sender,
+		SecretKey: secretKey,
+	})
+	mobile := "+8613900000000"
+
+	// 发送间隔
+	if err := provider.SendCode(mobile); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.SendCode(mobile); err != sms.ErrSendTooFrequent {
+		t.Fatalf("间隔太短不应发送：%v", err)
+	}
+
+	// 失败次数过多，验证码作废
+	code := sender.Code(mobile)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < sms.DefaultMaxAttempts; i++ {
+		if _, err := provider.Login([]byte(`{"mobile": "+8613900000000", "code": "` + wrong + `"}`)); err != sms.ErrInvalidCode {
+			t.Fatalf("错误的验证码不应通过：%v", err)
+		}
+	}
+	if _, err := provider.Login([]byte(`{"mobile": "+8613900000000", "code": "` + code + `"}`)); err != sms.ErrInvalidCode {
+		t.Fatalf("失败次数过多，验证码理应作废：%v", err)
+	}
+
+	// 计数周期内最多发送次数
+	for i := 1; i < sms.DefaultMaxSends; i++ {
+		advance(sms.DefaultSendInterval)
+		if err := provider.SendCode(mobile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	advance(sms.DefaultSendInterval)
+	if err := provider.SendCode(mobile); err != sms.ErrSendTooFrequent {
+		t.Fatalf("超过次数不应发送：%v", err)
+	}
+	advance(sms.DefaultSendWindow)
+	if err := provider.SendCode(mobile); err != nil {
+		t.Fatal("新的计数周期理应可以发送", err)
+	}
+}
+
+func TestHandleSendCode(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	provider := sms.NewProvider(&sms.Config{
+		Auth:      instance,
+		Sender:    sms.NewLogSender(),
+		SecretKey: secretKey,
+	})
+	send := func(body string) int {
+		req := httptest.NewRequest("POST", "http://localhost/api/login/sms", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		provider.HandleSendCode(w, req)
+		return w.Result().StatusCode
+	}
+	if status := send(`{"mobile": "13700000000"}`); status != http.StatusOK {
+		t.Fatalf("理应发送成功：%d", status)
+	}
+	if status := send(`{"mobile": "13700000000"}`); status != http.StatusTooManyRequests {
+		t.Fatalf("太频繁理应429：%d", status)
+	}
+	if status := send(`{"mobile": "abc"}`); status != http.StatusBadRequest {
+		t.Fatalf("无效的手机号理应400：%d", status)
+	}
+}
@@ -0,0 +1,76 @@
+package sms
+
+import (
+	"sync"
+	"time"
+)
+
+// CodeStore 验证码记录，按手机号保存
+// 多实例部署时，应使用共享的存储（如基于redis自行实现）
+type CodeStore interface {
+	// Get 查询记录，没有记录（或已超过ttl）则返回nil
+	Get(mobile string) (*Code, error)
+	// Save 保存记录，ttl后可以删除
+	Save(mobile string, code *Code, ttl time.Duration) error
+}
+
+// Code 某个手机号的验证码记录
+type Code struct {
+	Hash        string    // 验证码的HMAC，用过（或失败次数过多）即清空
+	ExpiredAt   time.Time // 验证码过期时间
+	Attempts    int       // 验证失败次数
+	SentAt      time.Time // 最后发送时间
+	Sends       int       // 本计数周期内的发送次数
+	WindowStart time.Time // 计数周期开始时间
+}
+
+// NewMemoryCodeStore 内存记录（仅限单实例，重启后丢失）
+func NewMemoryCodeStore() CodeStore {
+	return newMemoryCodeStore(time.Now)
+}
+
+// newMemoryCodeStore 指定时钟的内存记录（Provider默认使用auth的时钟）
+func newMemoryCodeStore(now func() time.Time) *memoryCodeStore {
+	return &memoryCodeStore{codes: map[string]memoryCode{}, now: now}
+}
+
+type memoryCode struct {
+	code    Code
+	expires time.Time
+}
+
+type memoryCodeStore struct {
+	mu        sync.Mutex
+	codes     map[string]memoryCode
+	cleanedAt time.Time
+	now       func() time.Time
+}
+
+func (s *memoryCodeStore) Get(mobile string) (*Code, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.codes[mobile]
+	if !ok || s.now().After(stored.expires) {
+		return nil, nil
+	}
+	code := stored.code
+	return &code, nil
+}
+
+func (s *memoryCodeStore) Save(mobile string, code *Code, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.codes[mobile] = memoryCode{code: *code, expires: now.Add(ttl)}
+
+	// 顺便清理过期的记录
+	if now.Sub(s.cleanedAt) > time.Minute {
+		for key, stored := range s.codes {
+			if now.After(stored.expires) {
+				delete(s.codes, key)
+			}
+		}
+		s.cleanedAt = now
+	}
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/goodwong/go-x/auth"
 	"github.com/goodwong/go-x/wechat/weapp"
-	"github.com/jinzhu/gorm"
 )
 
 // NewProvider 创建实例
@@ -61,7 +60,7 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	// 如果用户存在，直接返回
 	// SELECT * FROM "user_identities" WHERE (provider = 'wechat_weapp' and open_id = 'oPy_U5****Q6y7so@wx70****f05') LIMIT 1
 	user, err = p.repository().FindByOpenID(p.Name(), openID)
-	if err != nil && err != gorm.ErrRecordNotFound {
+	if err != nil && err != auth.ErrRecordNotFound {
 		return nil, err
 	}
 	if user != nil {
@@ -92,3 +91,21 @@ func (p *Provider) Login(payload []byte) (user *auth.User, err error) {
 	}
 	return user, err
 }
+
+// Identify 验证凭证; implemented Identify with IdentityProvider interface
+// 用于给已登录的用户绑定小程序（Service.Link）
+func (p *Provider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	// params
+	credentials := struct {
+		Code string
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return "", nil, err
+	}
+	// 接口获取数据
+	session, err := p.weapp.Code2session(credentials.Code)
+	if err != nil {
+		return "", nil, err
+	}
+	return session.OpenID + "@" + p.weapp.AppID, nil, nil
+}
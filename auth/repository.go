@@ -1,9 +1,5 @@
 package auth
 
-import (
-	"github.com/jinzhu/gorm"
-)
-
 // newRepository 类
 func newRepository(auth *Auth) *Repository {
 	return &Repository{auth: auth}
@@ -14,14 +10,14 @@ type Repository struct {
 	auth *Auth
 }
 
-func (r *Repository) db() *gorm.DB {
+func (r *Repository) storage() Storage {
 	if r.auth == nil {
 		panic("缺少auth字段")
 	}
-	return r.auth.db()
+	return r.auth.storage
 }
 
 // AutoMigrate 创建数据表
 func (r *Repository) AutoMigrate() error {
-	return r.db().AutoMigrate(&User{}, &UserIdentity{}, &Token{}, &UserLog{}).Error
+	return r.storage().AutoMigrate()
 }
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// APIKey 操作类...
+
+// apiKeyTouchInterval LastUsedAt的更新间隔，避免每个请求都写数据库
+const apiKeyTouchInterval = time.Minute
+
+// CreateAPIKey 创建API key，返回key（只显示这一次）
+// key.UserID、Name必填，Scopes为空格分隔的列表，ExpiredAt为空则永久有效
+func (r *Repository) CreateAPIKey(key *APIKey) (secret string, err error) {
+	if key.UserID == 0 {
+		return "", errors.New("缺少UserID")
+	}
+	if strings.TrimSpace(key.Name) == "" {
+		return "", errors.New("API key名称不能为空")
+	}
+	secret = apiKeyPrefix + newOAuthSecret(32)
+	key.Hash = hashOAuthSecret(secret)
+	key.Prefix = secret[:len(apiKeyPrefix)+6]
+	key.Scopes = strings.Join(parseScope(key.Scopes), " ")
+	key.LastUsedAt = nil
+	if err = r.storage().CreateAPIKey(key); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// FindAPIKey 查找API key，无效、已过期的返回ErrInvalidToken
+func (r *Repository) FindAPIKey(secret string) (key *APIKey, err error) {
+	if !isAPIKey(secret) {
+		return nil, ErrInvalidToken
+	}
+	key, err = r.storage().FindAPIKey(hashOAuthSecret(secret))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if key.Expired(r.auth.now()) {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
+
+// ListAPIKeys 列出用户的API key（包括已过期的）
+func (r *Repository) ListAPIKeys(userID uint64) (keys []*APIKey, err error) {
+	return r.storage().ListAPIKeys(userID)
+}
+
+// DeleteAPIKey 删除（吊销）用户的API key
+func (r *Repository) DeleteAPIKey(userID, id uint64) error {
+	return r.storage().DeleteAPIKey(userID, id)
+}
+
+// DeleteAPIKeys 删除（吊销）用户的全部API key
+func (r *Repository) DeleteAPIKeys(userID uint64) error {
+	return r.storage().DeleteAPIKeys(userID)
+}
+
+// touchAPIKey 更新LastUsedAt（间隔apiKeyTouchInterval），失败不影响请求
+func (r *Repository) touchAPIKey(key *APIKey) error {
+	now := r.auth.now()
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < apiKeyTouchInterval {
+		return nil
+	}
+	return r.storage().TouchAPIKey(key.ID, now)
+}
@@ -9,22 +9,17 @@ import (
 
 // FindIdentity 查找UserIdentity
 func (r *Repository) FindIdentity(provider, openID string) (indentity *UserIdentity, err error) {
-	indentity = &UserIdentity{}
-	err = r.db().Where("provider = ? and open_id = ?", provider, openID).Take(indentity).Error
-	if err != nil {
-		return nil, err
-	}
-	return
+	return r.storage().FindIdentity(provider, openID)
 }
 
 // FindIdentityByUser 查找UserIdentity
 func (r *Repository) FindIdentityByUser(userID uint64, provider string) (indentity *UserIdentity, err error) {
-	indentity = &UserIdentity{}
-	err = r.db().Where("user_id = ? and provider = ?", userID, provider).Take(indentity).Error
-	if err != nil {
-		return nil, err
-	}
-	return
+	return r.storage().FindIdentityByUser(userID, provider)
+}
+
+// ListIdentitiesByUser 列出用户的所有UserIdentity
+func (r *Repository) ListIdentitiesByUser(userID uint64) (identities []*UserIdentity, err error) {
+	return r.storage().ListIdentitiesByUser(userID)
 }
 
 // CreateIdentity 创建UserIdentity
@@ -42,7 +37,7 @@ func (r *Repository) CreateIdentity(userID uint64, provider, openID string, data
 		jsonData := json.RawMessage(bytes)
 		indentity.Data = &jsonData
 	}
-	err = r.db().Create(indentity).Error
+	err = r.storage().CreateIdentity(indentity)
 	if err != nil {
 		return nil, err
 	}
@@ -55,16 +50,32 @@ func (r *Repository) UpdateIdentityData(identity *UserIdentity, data interface{}
 	if err != nil {
 		panic(err)
 	}
-	err = r.db().Model(identity).Update("data", json.RawMessage(bytes)).Error
+	err = r.storage().UpdateIdentityData(identity, json.RawMessage(bytes))
 	if err != nil {
 		panic(err)
 	}
 }
 
+// CompareAndUpdateIdentityData 更新UserIdentity的Data（乐观锁）
+// 存储的Data与读取时（identity.Data）不一致，说明期间被修改过，不更新，返回false
+// 与UpdateIdentityData不同，出错时返回错误，不panic
+func (r *Repository) CompareAndUpdateIdentityData(identity *UserIdentity, data interface{}) (bool, error) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+	return r.storage().CompareAndUpdateIdentityData(identity, json.RawMessage(bytes))
+}
+
 // UpdateIdentityUser 更新UserIdentity
 func (r *Repository) UpdateIdentityUser(identity *UserIdentity, user *User) { // 更新绑定的用户，单独出来接口，避免误操作
-	err := r.db().Model(identity).Update("user_id", user.ID).Error
+	err := r.storage().UpdateIdentityUser(identity, user.ID)
 	if err != nil {
 		panic(err)
 	}
 }
+
+// DeleteIdentity 删除UserIdentity
+func (r *Repository) DeleteIdentity(identity *UserIdentity) error {
+	return r.storage().DeleteIdentity(identity.Provider, identity.OpenID)
+}
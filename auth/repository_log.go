@@ -0,0 +1,22 @@
+package auth
+
+// UserLog 操作类...
+
+// CreateLog 记录用户行为
+func (r *Repository) CreateLog(log *UserLog) (err error) {
+	return r.storage().CreateLog(log)
+}
+
+// ListLogs 查询用户行为记录（按时间倒序）
+// 同时返回符合条件的总数，便于分页
+func (r *Repository) ListLogs(query LogQuery) (logs []*UserLog, total int, err error) {
+	total, err = r.storage().CountLogs(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	logs, err = r.storage().ListLogs(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// OAuthClient、OAuthCode 操作类...
+
+// CreateOAuthClient 登记OAuth客户端，返回secret（只显示这一次，公开客户端为空）
+// client.ID为空则自动生成；Grants为空则只允许授权码
+func (r *Repository) CreateOAuthClient(client *OAuthClient) (secret string, err error) {
+	if client.Name == "" {
+		return "", errors.New("client名称不能为空")
+	}
+	if client.ID == "" {
+		client.ID = newOAuthSecret(12)
+	}
+	if client.Grants == "" {
+		client.Grants = GrantAuthorizationCode
+	}
+	for _, grant := range parseScope(client.Grants) {
+		if grant != GrantAuthorizationCode && grant != GrantClientCredentials {
+			return "", ErrUnsupportedGrantType
+		}
+	}
+	if client.AllowGrant(GrantAuthorizationCode) && strings.TrimSpace(client.RedirectURIs) == "" {
+		return "", errors.New("授权码流程须登记redirect_uri")
+	}
+	if client.Public && client.AllowGrant(GrantClientCredentials) {
+		return "", errors.New("公开客户端不能使用client_credentials")
+	}
+	client.SecretHash = ""
+	if !client.Public {
+		secret = newOAuthSecret(32)
+		client.SecretHash = hashOAuthSecret(secret)
+	}
+	if err = r.storage().CreateOAuthClient(client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// FindOAuthClient 查找OAuth客户端
+func (r *Repository) FindOAuthClient(id string) (client *OAuthClient, err error) {
+	return r.storage().FindOAuthClient(id)
+}
+
+// ListOAuthClients 列出所有OAuth客户端
+func (r *Repository) ListOAuthClients() (clients []*OAuthClient, err error) {
+	return r.storage().ListOAuthClients()
+}
+
+// DeleteOAuthClient 删除OAuth客户端（已颁发的access token到期前仍然有效）
+func (r *Repository) DeleteOAuthClient(id string) error {
+	return r.storage().DeleteOAuthClient(id)
+}
+
+// createOAuthCode 创建授权码，返回给客户端的code
+func (r *Repository) createOAuthCode(params OAuthCode) (code string, err error) {
+	code = newOAuthSecret(32)
+	params.Hash = hashOAuthSecret(code)
+	params.ExpiredAt = r.auth.now().Add(r.auth.oauthCodeLife)
+	if err = r.storage().CreateOAuthCode(&params); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// takeOAuthCode 兑换授权码（只能兑换一次），无效、过期都返回ErrInvalidGrant
+func (r *Repository) takeOAuthCode(code string) (*OAuthCode, error) {
+	stored, err := r.storage().TakeOAuthCode(hashOAuthSecret(code))
+	if err == ErrRecordNotFound {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.ExpiredAt.Before(r.auth.now()) {
+		return nil, ErrInvalidGrant
+	}
+	return stored, nil
+}
+
+// deleteExpiredOAuthCodes 清理过期的授权码
+func (r *Repository) deleteExpiredOAuthCodes(before time.Time) error {
+	return r.storage().DeleteOAuthCodes(before)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"errors"
+)
+
+// Role、Permission 操作类...
+
+// CreateRole 创建角色
+func (r *Repository) CreateRole(name string, remark ...string) (role *Role, err error) {
+	if name == "" {
+		return nil, errors.New("角色名不能为空")
+	}
+	role = &Role{Name: name}
+	if len(remark) == 1 {
+		role.Remark = remark[0]
+	}
+	err = r.storage().CreateRole(role)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// FindRole 查找角色
+func (r *Repository) FindRole(name string) (role *Role, err error) {
+	return r.storage().FindRole(name)
+}
+
+// ListRoles 列出所有角色
+func (r *Repository) ListRoles() (roles []*Role, err error) {
+	return r.storage().ListRoles()
+}
+
+// DeleteRole 删除角色（同时解除用户、权限的关联）
+func (r *Repository) DeleteRole(name string) error {
+	return r.storage().DeleteRole(name)
+}
+
+// CreatePermission 创建权限
+func (r *Repository) CreatePermission(name string, remark ...string) (permission *Permission, err error) {
+	if name == "" {
+		return nil, errors.New("权限名不能为空")
+	}
+	permission = &Permission{Name: name}
+	if len(remark) == 1 {
+		permission.Remark = remark[0]
+	}
+	err = r.storage().CreatePermission(permission)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// FindPermission 查找权限
+func (r *Repository) FindPermission(name string) (permission *Permission, err error) {
+	return r.storage().FindPermission(name)
+}
+
+// GrantPermission 给角色授予权限（角色、权限须已存在）
+func (r *Repository) GrantPermission(role, permission string) error {
+	if _, err := r.FindRole(role); err != nil {
+		return err
+	}
+	if _, err := r.FindPermission(permission); err != nil {
+		return err
+	}
+	return r.storage().CreateRolePermission(role, permission)
+}
+
+// RevokePermission 收回角色的权限
+func (r *Repository) RevokePermission(role, permission string) error {
+	return r.storage().DeleteRolePermission(role, permission)
+}
+
+// GrantRole 给用户授予角色（角色须已存在）
+func (r *Repository) GrantRole(userID uint64, role string) error {
+	if userID == 0 {
+		return errors.New("userID不能为0")
+	}
+	if _, err := r.FindRole(role); err != nil {
+		return err
+	}
+	return r.storage().CreateUserRole(userID, role)
+}
+
+// RevokeRole 收回用户的角色
+func (r *Repository) RevokeRole(userID uint64, role string) error {
+	return r.storage().DeleteUserRole(userID, role)
+}
+
+// FindRolesByUser 查询用户的角色名
+func (r *Repository) FindRolesByUser(userID uint64) (roles []string, err error) {
+	return r.storage().FindRolesByUser(userID)
+}
+
+// FindPermissionsByUser 查询用户（通过角色）拥有的权限名
+func (r *Repository) FindPermissionsByUser(userID uint64) (permissions []string, err error) {
+	return r.storage().FindPermissionsByUser(userID)
+}
@@ -1,27 +1,39 @@
 package auth
 
-import (
-	"time"
-)
-
 // Token操作类...
 
 // FindToken 查找Token
+// 已被轮换过的token视为无效
 func (r *Repository) FindToken(tokenString string) (token *Token, err error) {
+	token, err = r.findToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if token.RotatedAt != nil {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// findToken 查找Token（包括已被轮换过的，用于盗用检测）
+func (r *Repository) findToken(tokenString string) (token *Token, err error) {
 	// parse and load token
-	token, err = parseTokenString(tokenString, r.auth.secretKey)
+	token, err = parseTokenString(tokenString, r.auth.tokenKeys)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
-	if r.db().Where("id = ?", token.ID).Take(&token).RecordNotFound() {
+	stored, err := r.storage().FindToken(token.ID)
+	if err != nil {
 		return nil, ErrInvalidToken
 	}
+	stored.nonce, stored.keys = token.nonce, token.keys
+	token = stored
 
 	// verify token
 	if !token.Verify() {
 		return nil, ErrInvalidToken
 	}
-	if token.ExpiredAt.Before(time.Now()) {
+	if token.ExpiredAt.Before(r.auth.now()) {
 		return nil, ErrInvalidToken
 	}
 
@@ -43,10 +55,10 @@ func (r *Repository) CreateToken(
 	if len(remark) == 1 {
 		params.Remark = remark[0]
 	}
-	token = newToken(params, r.auth.secretKey)
+	token = r.newToken(params)
 
 	// create
-	err = r.db().Create(token).Error
+	err = r.storage().CreateToken(token)
 	if err != nil {
 		return nil, "", err
 	}
@@ -54,7 +66,111 @@ func (r *Repository) CreateToken(
 	return token, token.TokenString(), nil
 }
 
+// newToken 按配置的时钟和有效时长创建Token
+func (r *Repository) newToken(params Token) *Token {
+	params.IssuedAt = r.auth.now()
+	params.ExpiredAt = params.IssuedAt.Add(r.auth.refreshTokenLife)
+	return newToken(params, r.auth.tokenKeys)
+}
+
 // DeleteToken 删除Token
 func (r *Repository) DeleteToken(userID uint64, device string) (err error) {
-	return r.db().Where("user_id = ? and device = ?", userID, device).Delete(&Token{}).Error
+	return r.storage().DeleteTokens(userID, device)
+}
+
+// RotateToken 轮换Token
+// 旧token标记为已轮换，颁发同一个family的新token（在一个事务里）
+// 旧数据没有family的，开启新的family，旧token一并归入，以便盗用检测
+// 如果旧token已经被轮换过了，返回ErrTokenReused
+func (r *Repository) RotateToken(token *Token) (rotated *Token, tokenString string, err error) {
+	// new
+	params := Token{
+		UserID: token.UserID,
+		Device: token.Device,
+		Remark: token.Remark,
+		Family: token.Family,
+	}
+	rotated = r.newToken(params)
+
+	// rotate and create
+	ok, err := r.storage().RotateToken(token.ID, rotated.IssuedAt, rotated)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", ErrTokenReused
+	}
+	token.RotatedAt, token.Family = &rotated.IssuedAt, rotated.Family
+
+	return rotated, rotated.TokenString(), nil
+}
+
+// findSuccessor 查找同一个family当前有效的Token（已被注销的返回ErrRecordNotFound）
+func (r *Repository) findSuccessor(token *Token) (successor *Token, err error) {
+	tokens, err := r.ListTokens(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		if token.Family != "" && t.Family == token.Family {
+			return t, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// DeleteTokenFamily 删除同一个family的所有Token
+// family为空（旧数据）时不删除，以免误删其他用户的Token
+func (r *Repository) DeleteTokenFamily(family string) (err error) {
+	if family == "" {
+		return nil
+	}
+	return r.storage().DeleteTokenFamily(family)
+}
+
+// ListTokens 列出用户的有效Token（未过期、未被轮换），按颁发时间倒序
+func (r *Repository) ListTokens(userID uint64) (tokens []*Token, err error) {
+	all, err := r.storage().ListTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := r.auth.now()
+	tokens = []*Token{}
+	for _, token := range all {
+		if token.RotatedAt == nil && token.ExpiredAt.After(now) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// FindTokenByID 按ID查找用户的有效Token（须属于该用户）
+func (r *Repository) FindTokenByID(userID, id uint64) (token *Token, err error) {
+	token, err = r.storage().FindToken(id)
+	if err != nil {
+		return nil, err
+	}
+	if token.UserID != userID || token.RotatedAt != nil || token.ExpiredAt.Before(r.auth.now()) {
+		return nil, ErrRecordNotFound
+	}
+	return token, nil
+}
+
+// UpdateTokenRemark 更新Token备注（如“家”、“办公室”）
+func (r *Repository) UpdateTokenRemark(token *Token, remark string) (err error) {
+	if err = r.storage().UpdateTokenRemark(token.ID, remark); err != nil {
+		return err
+	}
+	token.Remark = remark
+	return nil
+}
+
+// DeleteTokenByID 按ID删除Token
+func (r *Repository) DeleteTokenByID(id uint64) (err error) {
+	return r.storage().DeleteToken(id)
+}
+
+// DeleteTokensExcept 删除用户除exceptID以外的所有Token
+func (r *Repository) DeleteTokensExcept(userID uint64, exceptID uint64) (err error) {
+	return r.storage().DeleteTokensExcept(userID, exceptID)
 }
@@ -1,29 +1,15 @@
 package auth
 
-import (
-	"github.com/jinzhu/gorm"
-)
-
 // User 操作类...
 
 // Find 根据id查找
 func (r *Repository) Find(id uint64) (user *User, err error) {
-	user = &User{}
-	err = r.db().Where("id = ?", id).Take(user).Error
-	if err != nil {
-		return nil, err
-	}
-	return
+	return r.storage().FindUser(id)
 }
 
 // FindByUsername 根据用户名查找
 func (r *Repository) FindByUsername(username string) (user *User, err error) {
-	user = &User{}
-	err = r.db().Where("username = ?", username).Take(user).Error
-	if err != nil {
-		return nil, err
-	}
-	return
+	return r.storage().FindUserByUsername(username)
 }
 
 // FindByOpenID 按openid查找
@@ -54,7 +40,7 @@ func (r *Repository) Create(username, name string, avatar ...string) (u *User, e
 	if len(avatar) == 1 {
 		user.Avatar = avatar[0]
 	}
-	err = r.db().Create(&user).Error
+	err = r.storage().CreateUser(&user)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +54,7 @@ func (r *Repository) Update(u *User, update User) error {
 		Name:   update.Name,
 		Avatar: update.Avatar,
 	}
-	return r.db().Model(u).Updates(update).Error
+	return r.storage().UpdateUser(u, update)
 }
 
 // UpdateUserName 更新用户名（独立出来，避免误用）
@@ -76,24 +62,21 @@ func (r *Repository) UpdateUserName(u *User, username string) error {
 	update := User{
 		Username: username,
 	}
-	return r.db().Model(u).Updates(update).Error
+	return r.storage().UpdateUser(u, update)
+}
+
+// Merge 合并用户（独立出来，避免误用）
+// source的登录方式、Token、角色移到target，然后删除source
+func (r *Repository) Merge(target, source *User, drop ...*UserIdentity) error {
+	return r.storage().MergeUsers(target.ID, source.ID, drop)
 }
 
 // List 查询列表
-func (r *Repository) List(offset, limit int, where ...interface{}) (users []*User, err error) {
-	var db *gorm.DB
-	switch len(where) {
-	case 0:
-		db = r.db()
-	default:
-		db = r.db().Where(where[0], where[1:]...)
-	}
-	err = db.Offset(offset).Limit(limit).Find(&users).Error
-	return
+func (r *Repository) List(query UserQuery) (users []*User, err error) {
+	return r.storage().ListUsers(query)
 }
 
-// Count 统计文件长度
-func (r *Repository) Count(where ...interface{}) (count int, err error) {
-	err = r.db().Model(&User{}).Count(&count).Error
-	return
+// Count 统计数量（忽略Offset、Limit）
+func (r *Repository) Count(query UserQuery) (count int, err error) {
+	return r.storage().CountUsers(query)
 }
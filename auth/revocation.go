@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RevocationStore JWT提前失效（用户主动注销）的记录
+// 只要是userID在这里的，并且jwt.iat <= RevokedAt的，都要重新登录
+// 多实例部署时，应使用共享的存储（如NewGormRevocationStore），
+// 否则在一个实例上注销，其他实例感知不到
+type RevocationStore interface {
+	// Revoke 记录用户注销时间
+	Revoke(userID uint64, at time.Time) error
+	// RevokedAt 查询用户最后注销时间，没有记录则返回零值
+	RevokedAt(userID uint64) (time.Time, error)
+	// Cleanup 清理before之前的记录（此前颁发的jwt已经自然过期了）
+	Cleanup(before time.Time) error
+}
+
+// Revocation 用户注销记录
+type Revocation struct {
+	UserID    uint64 `gorm:"primary_key;auto_increment:false"`
+	RevokedAt time.Time
+}
+
+// TableName 指定数据表名(gorm)
+func (r *Revocation) TableName() string {
+	return "user_revocations"
+}
+
+// NewMemoryRevocationStore 内存记录（仅限单实例，重启后丢失）
+func NewMemoryRevocationStore() RevocationStore {
+	return &memoryRevocationStore{}
+}
+
+type memoryRevocationStore struct {
+	revocations sync.Map // userID => time.Time
+}
+
+func (s *memoryRevocationStore) Revoke(userID uint64, at time.Time) error {
+	s.revocations.Store(userID, at)
+	return nil
+}
+
+func (s *memoryRevocationStore) RevokedAt(userID uint64) (time.Time, error) {
+	v, ok := s.revocations.Load(userID)
+	if !ok {
+		return time.Time{}, nil
+	}
+	return v.(time.Time), nil
+}
+
+func (s *memoryRevocationStore) Cleanup(before time.Time) error {
+	s.revocations.Range(func(k, v interface{}) bool {
+		if v.(time.Time).Before(before) {
+			s.revocations.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// NewGormRevocationStore 数据库记录（多实例共享，数据表由Repository.AutoMigrate创建）
+func NewGormRevocationStore(db *gorm.DB) RevocationStore {
+	if db == nil {
+		panic("NewGormRevocationStore 缺少有效的*gorm.DB对象")
+	}
+	return &gormRevocationStore{db: db}
+}
+
+type gormRevocationStore struct {
+	db *gorm.DB
+}
+
+func (s *gormRevocationStore) Revoke(userID uint64, at time.Time) error {
+	return s.db.
+		Where(Revocation{UserID: userID}).
+		Assign(Revocation{RevokedAt: at}).
+		FirstOrCreate(&Revocation{}).Error
+}
+
+func (s *gormRevocationStore) RevokedAt(userID uint64) (time.Time, error) {
+	revocation := &Revocation{}
+	err := s.db.Where("user_id = ?", userID).Take(revocation).Error
+	if err == gorm.ErrRecordNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return revocation.RevokedAt, nil
+}
+
+func (s *gormRevocationStore) Cleanup(before time.Time) error {
+	return s.db.Where("revoked_at < ?", before).Delete(&Revocation{}).Error
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+
+	// 没有记录
+	if at, err := store.RevokedAt(1); err != nil || !at.IsZero() {
+		t.Fatalf("理应没有记录：%s, %v", at, err)
+	}
+
+	// 记录
+	store.Revoke(1, now.Add(-time.Hour))
+	store.Revoke(2, now)
+	if at, _ := store.RevokedAt(2); !at.Equal(now) {
+		t.Fatalf("注销时间不对：%s", at)
+	}
+
+	// 清理
+	store.Cleanup(now.Add(-time.Minute))
+	if at, _ := store.RevokedAt(1); !at.IsZero() {
+		t.Fatal("过期记录理应被清理")
+	}
+	if at, _ := store.RevokedAt(2); at.IsZero() {
+		t.Fatal("未过期记录不应被清理")
+	}
+}
+
+func TestJwtInvalidSharedStore(t *testing.T) {
+	// 两个实例共享同一个注销记录
+	secretKey := []byte("aasdfkjksjdfaaasdfkjksjdfa123405")
+	revocations := NewMemoryRevocationStore()
+	auth1 := New(Config{SecretKey: secretKey, Revocations: revocations})
+	auth2 := New(Config{SecretKey: secretKey, Revocations: revocations})
+	defer auth1.Close()
+	defer auth2.Close()
+
+	user := &User{ID: 1}
+	tokenString, _, err := auth1.Service.issueJWTToken(user, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth2.jwtKeys.Decode(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth2.Service.JwtInvalid(token) {
+		t.Fatal("不需要重新登录")
+	}
+
+	// 在实例1上注销，实例2也要感知到
+	if err := auth1.Service.Logout(user, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if !auth2.Service.JwtInvalid(token) {
+		t.Fatal("理应需要重新登录")
+	}
+}
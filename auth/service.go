@@ -2,19 +2,14 @@ package auth
 
 import (
 	"errors"
-	"sync"
+	"fmt"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var (
-	// DefaultTokenLife 默认JWT token有效时长
-	DefaultTokenLife = 1 * time.Hour // 1 Hour
-	// CleanupInterval 清理登出间隔
-	CleanupInterval = 10 * time.Second
-)
-
 func newService(auth *Auth) *Service {
 	service := &Service{auth: auth, providers: map[string]LoginProvider{}}
 	service.cleanupLogoutsLoop()
@@ -25,7 +20,33 @@ func newService(auth *Auth) *Service {
 type Service struct {
 	auth      *Auth
 	providers map[string]LoginProvider
-	logouts   sync.Map
+	// 客户端信息，用于记录日志
+	clientIP  string
+	userAgent string
+}
+
+// WithRequest 带上请求的客户端信息（IP、UA），
+// 之后的Login、Renew、Logout会记录到UserLog里
+// 用法：auths.Service.WithRequest(r).Login(...)
+func (s *Service) WithRequest(r *http.Request) *Service {
+	copied := *s
+	copied.clientIP = s.auth.clientIP(r)
+	copied.userAgent = r.UserAgent()
+	return &copied
+}
+
+// record 记录用户行为，失败不影响主流程
+func (s *Service) record(userID uint64, action, remark string) {
+	userLog := &UserLog{
+		UserID: userID,
+		Action: action,
+		Remark: remark,
+		IP:     s.clientIP,
+		UA:     s.userAgent,
+	}
+	if err := s.repository().CreateLog(userLog); err != nil {
+		log.Printf("auth: 记录日志失败: %s", err)
+	}
 }
 
 func (s *Service) repository() *Repository {
@@ -35,40 +56,66 @@ func (s *Service) repository() *Repository {
 // issueRefreshToken 获取用于refresh的token
 func (s *Service) issueRefreshToken(
 	user *User, device, remark string,
-) (tokenString string, expires time.Time, err error) {
+) (token *Token, tokenString string, err error) {
 	// 同一个设备只能有一个有效token，创建新token，该device的原有token失效
 	if err = s.repository().DeleteToken(user.ID, device); err != nil {
 		return
 	}
 	// 颁发新token，返回的tokenString返回到前端保存
-	token, tokenString, err := s.repository().CreateToken(user.ID, device, remark)
-	if token != nil {
-		expires = token.ExpiredAt
-	}
-	return
+	return s.repository().CreateToken(user.ID, device, remark)
 }
 
 // issueJWTToken 获取jwt的token
+// sessionID 即对应的refresh token的ID（没有则为0），用于识别当前会话
+// 配置了EnrichClaims的，带上其返回的claims
 func (s *Service) issueJWTToken(
-	user *User,
+	user *User, sessionID uint64,
 ) (tokenString string, expires time.Time, err error) {
-	now := time.Now()
-	expires = now.Add(DefaultTokenLife)
+	now := s.auth.now()
+	expires = now.Add(s.auth.tokenLife)
 	claims := jwt.MapClaims{
 		"iat": now.UTC().Unix(),
 		"sub": user.ID,
 		"exp": expires.UTC().Unix(),
 	}
-	_, tokenString, err = s.auth.jwtauth.Encode(claims)
+	if sessionID != 0 {
+		claims["sid"] = sessionID
+	}
+	if err = s.enrichClaims(user, claims); err != nil {
+		return
+	}
+	tokenString, err = s.auth.jwtKeys.Encode(claims)
 	return
 }
 
+// enrichClaims 带上Config.EnrichClaims返回的claims（保留的claims不覆盖）
+func (s *Service) enrichClaims(user *User, claims jwt.MapClaims) error {
+	if s.auth.enrichClaims == nil {
+		return nil
+	}
+	extra, err := s.auth.enrichClaims(user)
+	if err != nil {
+		return err
+	}
+	for name, value := range extra {
+		if !reservedClaims[name] {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
 // TokenResponse 返回token结构
 type TokenResponse struct {
 	Token               string     `json:"token"`
 	TokenExpires        time.Time  `json:"token_expires"`
 	RefreshToken        *string    `json:"refresh_token,omitempty"`
 	RefreshTokenExpires *time.Time `json:"refresh_token_expires,omitempty"`
+	SessionID           uint64     `json:"session_id,omitempty"` // 当前会话（refresh token）的ID
+	// Challenge 用户启用了两步验证时，Login不颁发token（Token为空），只返回Challenge，
+	// 凭它和TOTP验证码调用Service.VerifyTwoFactor换取token
+	Challenge        string     `json:"challenge,omitempty"`
+	ChallengeExpires *time.Time `json:"challenge_expires,omitempty"`
 }
 
 // Login 登陆
@@ -80,26 +127,60 @@ func (s *Service) Login(
 	if !ok {
 		return nil, errors.New("invalid provider")
 	}
+	// 防暴力破解
+	var keys []throttleKey
+	if throttled, ok := provider.(ThrottledProvider); ok {
+		keys = s.throttleKeys(throttled, credentials)
+		if err = s.checkThrottle(keys); err != nil {
+			s.record(0, ActionLoginFailed, fmt.Sprintf("provider: %s, error: %s", providerName, err))
+			return nil, err
+		}
+	}
 	user, err := provider.Login(credentials)
 	if err != nil {
+		s.failThrottle(keys)
+		s.record(0, ActionLoginFailed, fmt.Sprintf("provider: %s, error: %s", providerName, err))
 		return nil, err
 	}
+	s.resetThrottle(keys)
+
+	remark := ""
+	if len(deviceName) == 1 {
+		remark = deviceName[0]
+	}
+
+	// 启用了两步验证的，只返回challenge，
+	// 再由Service.VerifyTwoFactor验证TOTP后换取token
+	enabled, err := s.TwoFactorEnabled(user)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return s.issueChallenge(user, providerName, remember, device, remark)
+	}
 
 	// issue tokens
+	tokens, err = s.issueTokens(user, remember, device, remark)
+	if err != nil {
+		return nil, err
+	}
+	s.record(user.ID, ActionLogin, fmt.Sprintf("provider: %s, device: %s", providerName, device))
+	return
+}
+
+// issueTokens 颁发jwt，remember则同时颁发refresh token
+func (s *Service) issueTokens(user *User, remember bool, device, remark string) (tokens *TokenResponse, err error) {
 	tokens = &TokenResponse{}
 	if remember {
-		remark := ""
-		if len(deviceName) == 1 {
-			remark = deviceName[0]
-		}
 		// 如果出错也没关系，重点是jwt要成功
-		refreshToken, refreshTokenExpires, err := s.issueRefreshToken(user, device, remark)
+		token, refreshToken, err := s.issueRefreshToken(user, device, remark)
 		if err == nil {
 			tokens.RefreshToken = &refreshToken
-			tokens.RefreshTokenExpires = &refreshTokenExpires
+			tokens.RefreshTokenExpires = &token.ExpiredAt
+			tokens.SessionID = token.ID
 		}
 	}
-	tokens.Token, tokens.TokenExpires, err = s.issueJWTToken(user)
+	tokens.Token, tokens.TokenExpires, err = s.issueJWTToken(user, tokens.SessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -107,78 +188,170 @@ func (s *Service) Login(
 }
 
 // Renew 通过RefreshToken续约
+// 如果开启了RotateRefreshTokens，还会返回新的RefreshToken，旧的作废
 func (s *Service) Renew(tokenString string) (user *User, tokens *TokenResponse, err error) {
 	// 验证
-	user, err = s.repository().FindByToken(tokenString)
+	token, err := s.repository().findToken(tokenString)
 	if err != nil {
 		return nil, nil, err
 	}
+	// 已经轮换过的token又来续约
+	if token.RotatedAt != nil {
+		return s.renewRotated(token)
+	}
+	user, err = s.repository().Find(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens = &TokenResponse{SessionID: token.ID}
+
+	// 轮换refresh token
+	if s.auth.rotateRefreshTokens {
+		rotated, refreshToken, err := s.repository().RotateToken(token)
+		if err == ErrTokenReused {
+			// 并发的请求刚刚轮换过
+			if token, err = s.repository().findToken(tokenString); err != nil {
+				return nil, nil, err
+			}
+			return s.renewRotated(token)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		tokens.RefreshToken = &refreshToken
+		tokens.RefreshTokenExpires = &rotated.ExpiredAt
+		tokens.SessionID = rotated.ID
+	}
+
 	// 发放jwttoken
-	tokens = &TokenResponse{}
-	tokens.Token, tokens.TokenExpires, err = s.issueJWTToken(user)
+	tokens.Token, tokens.TokenExpires, err = s.issueJWTToken(user, tokens.SessionID)
 	if err != nil {
 		return nil, nil, err
 	}
+	s.record(user.ID, ActionRenew, fmt.Sprintf("device: %s", token.Device))
 	return
 }
 
+// renewRotated 用已经轮换过的token续约
+// 宽限期内（浏览器并发的请求同时续约）只颁发jwt，不返回新的RefreshToken（客户端沿用先到的那个）
+// 否则说明被盗用了（或者被盗用的那个已经先用了），吊销整个family
+func (s *Service) renewRotated(token *Token) (user *User, tokens *TokenResponse, err error) {
+	if s.auth.now().After(token.RotatedAt.Add(s.auth.reuseGrace)) {
+		s.revokeTokenFamily(token)
+		return nil, nil, ErrTokenReused
+	}
+	// 新的token已经注销（如登出）的，不能再续约
+	successor, err := s.repository().findSuccessor(token)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	user, err = s.repository().Find(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens = &TokenResponse{SessionID: successor.ID}
+	tokens.Token, tokens.TokenExpires, err = s.issueJWTToken(user, tokens.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.record(user.ID, ActionRenew, fmt.Sprintf("device: %s, within reuse grace", token.Device))
+	return
+}
+
+// revokeTokenFamily 吊销同一次登录轮换出来的所有refresh token，并记录日志
+func (s *Service) revokeTokenFamily(token *Token) {
+	if err := s.repository().DeleteTokenFamily(token.Family); err != nil {
+		log.Printf("auth: 吊销RefreshToken失败: %s", err)
+	}
+	s.record(token.UserID, ActionRefreshTokenReused, fmt.Sprintf("device: %s, family: %s", token.Device, token.Family))
+}
+
 // Logout 登出
-// todo middleware 检查这个Logouts名单
+// 登出前颁发的jwt全部失效（记录在RevocationStore，多实例共享）
 func (s *Service) Logout(user *User, device string) (err error) {
-	logout := logoutStruct{UserID: user.ID, LogoutAt: time.Now()}
-	s.logouts.Store(user.ID, &logout)
-	return s.repository().DeleteToken(user.ID, device)
+	if err = s.auth.revocations.Revoke(user.ID, s.auth.now()); err != nil {
+		return err
+	}
+	if err = s.repository().DeleteToken(user.ID, device); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionLogout, fmt.Sprintf("device: %s", device))
+	return nil
 }
 
 // JwtInvalid 检查是否jwt是否提前失效（指用户主动登出）
+// 没有用户的jwt（OAuth client_credentials）不会提前失效
+// 模拟登录的jwt，管理员结束模拟（或登出）后也失效
 func (s *Service) JwtInvalid(token *jwt.Token) bool {
 	claims := token.Claims.(jwt.MapClaims)
-	userID := uint64(claims["sub"].(float64))
-	issuedAt := int64(claims["iat"].(float64))
-
-	// 查询
-	// 如果没在logouts里面找到，
-	// 说明用户没有主动注销行为，jwt可以继续使用
-	v, ok := s.logouts.Load(userID)
+	sub, ok := claims["sub"].(float64)
 	if !ok {
 		return false
 	}
-
-	// 比较
-	// 如果是注销前颁发的jwt，则失效，需要重新登录
-	logout := v.(*logoutStruct)
-	if issuedAt <= logout.LogoutAt.UTC().Unix() {
+	issuedAt, _ := claims["iat"].(float64)
+	if s.revoked(uint64(sub), int64(issuedAt)) {
 		return true
 	}
-
-	// 注销后颁发的jwt，可以继续使用那个
+	if actorID := actorFromClaims(claims); actorID != 0 {
+		return s.revoked(actorID, int64(issuedAt))
+	}
 	return false
 }
 
-// logoutStruct 主动注销行为（数据量较小，可内存保存）
-//（只要是userID在这里的，并且user.iat < LogoutAt的，都要重新登录）
-type logoutStruct struct {
-	UserID   uint64
-	LogoutAt time.Time
+// revoked 用户是否在issuedAt之后注销过
+func (s *Service) revoked(userID uint64, issuedAt int64) bool {
+	// 查询
+	// 如果没有注销记录，
+	// 说明用户没有主动注销行为，jwt可以继续使用
+	revokedAt, err := s.auth.revocations.RevokedAt(userID)
+	if err != nil {
+		// 查询失败，宁可让用户重新登录
+		log.Printf("auth: 查询注销记录失败: %s", err)
+		return true
+	}
+	if revokedAt.IsZero() {
+		return false
+	}
+
+	// 比较
+	// 如果是注销前颁发的jwt，则失效，需要重新登录
+	// 注销后颁发的jwt，可以继续使用
+	return issuedAt <= revokedAt.UTC().Unix()
 }
 
-// 自动清理logouts条目
-// todo 集成CanceledContext，感知cancel时间
+// 自动清理注销记录、登录失败记录、OAuth授权码
+// Auth.Close、Auth.Shutdown时退出
 func (s *Service) cleanupLogoutsLoop() {
-	go func() {
+	s.auth.goBackground(func(stop <-chan struct{}) {
+		ticker := time.NewTicker(s.auth.cleanupInterval)
+		defer ticker.Stop()
 		for {
-			now := time.Now()
-			s.logouts.Range(func(k, v interface{}) bool {
-				logout := v.(*logoutStruct)
-				if logout.LogoutAt.Add(DefaultTokenLife).Before(now) {
-					s.logouts.Delete(k)
-				}
-
-				return true
-			})
-
-			// 间隔
-			time.Sleep(CleanupInterval)
+			// 注销前颁发的jwt，过了有效期就自然失效了，记录可以删掉
+			// 模拟登录的jwt有效期可能更长，按较长的算
+			life := s.auth.tokenLife
+			if s.auth.impersonationLife > life {
+				life = s.auth.impersonationLife
+			}
+			before := s.auth.now().Add(-life)
+			if err := s.auth.revocations.Cleanup(before); err != nil {
+				log.Printf("auth: 清理注销记录失败: %s", err)
+			}
+			// 过了计数窗口、锁定时间的登录失败记录
+			before = s.auth.now().Add(-s.auth.loginThrottle.Window)
+			if err := s.auth.loginAttempts.Cleanup(before); err != nil {
+				log.Printf("auth: 清理登录失败记录失败: %s", err)
+			}
+			// 过期的OAuth授权码
+			if err := s.repository().deleteExpiredOAuthCodes(s.auth.now()); err != nil {
+				log.Printf("auth: 清理OAuth授权码失败: %s", err)
+			}
+
+			// 间隔，Auth.Close时退出
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
 		}
-	}()
+	})
 }
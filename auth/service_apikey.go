@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// APIKeys 列出用户的API key
+func (s *Service) APIKeys(user *User) (keys []*APIKey, err error) {
+	return s.repository().ListAPIKeys(user.ID)
+}
+
+// CreateAPIKey 给用户创建API key（个人访问令牌），返回的secret只显示这一次
+// scope为空格分隔的列表（见Middleware.Scoped）；expiresIn为0则永久有效
+func (s *Service) CreateAPIKey(
+	user *User, name, scope string, expiresIn time.Duration,
+) (key *APIKey, secret string, err error) {
+	key = &APIKey{UserID: user.ID, Name: name, Scopes: scope}
+	if expiresIn > 0 {
+		expiredAt := s.auth.now().Add(expiresIn)
+		key.ExpiredAt = &expiredAt
+	}
+	if secret, err = s.repository().CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	s.record(user.ID, ActionAPIKeyCreated, fmt.Sprintf("id: %d, name: %s, scope: %s", key.ID, key.Name, key.Scopes))
+	return key, secret, nil
+}
+
+// RevokeAPIKey 吊销用户的API key，立即失效
+func (s *Service) RevokeAPIKey(user *User, id uint64) (err error) {
+	err = s.repository().DeleteAPIKey(user.ID, id)
+	if err == ErrRecordNotFound {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.record(user.ID, ActionAPIKeyRevoked, fmt.Sprintf("id: %d", id))
+	return nil
+}
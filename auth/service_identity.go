@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Identity 用户绑定的登录方式（不含Data，里面可能有密码hash）
+type Identity struct {
+	Provider string `json:"provider"`
+	OpenID   string `json:"open_id"`
+}
+
+// Identities 列出用户绑定的登录方式（不包括两步验证）
+func (s *Service) Identities(user *User) (identities []*Identity, err error) {
+	all, err := s.repository().ListIdentitiesByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	identities = []*Identity{}
+	for _, identity := range all {
+		if identity.Provider == TwoFactorProvider {
+			continue
+		}
+		identities = append(identities, &Identity{Provider: identity.Provider, OpenID: identity.OpenID})
+	}
+	return identities, nil
+}
+
+// Link 给已登录的用户绑定新的登录方式（如给密码账号绑定微信小程序）
+// credentials与Login的一样，由provider验证（须实现IdentityProvider）
+// 已绑定其他用户的，返回ErrIdentityTaken；同一种登录方式只能绑定一个
+func (s *Service) Link(user *User, providerName string, credentials []byte) (err error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return errors.New("invalid provider")
+	}
+	identifier, ok := provider.(IdentityProvider)
+	if !ok {
+		return ErrProviderNotLinkable
+	}
+
+	// 每种登录方式只能绑定一个
+	if _, err = s.repository().FindIdentityByUser(user.ID, providerName); err == nil {
+		return ErrIdentityLinked
+	} else if err != ErrRecordNotFound {
+		return err
+	}
+
+	// 验证凭证
+	openID, data, err := identifier.Identify(credentials)
+	if err != nil {
+		return err
+	}
+
+	// 不能抢别人的
+	if identity, err := s.repository().FindIdentity(providerName, openID); err == nil {
+		if identity.UserID == user.ID {
+			return ErrIdentityLinked
+		}
+		return ErrIdentityTaken
+	} else if err != ErrRecordNotFound {
+		return err
+	}
+
+	// 绑定
+	if data == nil {
+		_, err = s.repository().CreateIdentity(user.ID, providerName, openID)
+	} else {
+		_, err = s.repository().CreateIdentity(user.ID, providerName, openID, data)
+	}
+	if err == ErrDuplicateKey {
+		return ErrIdentityTaken
+	}
+	if err != nil {
+		return err
+	}
+	s.record(user.ID, ActionIdentityLinked, fmt.Sprintf("provider: %s", providerName))
+	return nil
+}
+
+// Unlink 解绑登录方式
+// 至少要保留一种登录方式，否则返回ErrLastIdentity
+func (s *Service) Unlink(user *User, providerName string) (err error) {
+	identities, err := s.Identities(user)
+	if err != nil {
+		return err
+	}
+	var found *Identity
+	for _, identity := range identities {
+		if identity.Provider == providerName {
+			found = identity
+		}
+	}
+	if found == nil {
+		return ErrIdentityNotFound
+	}
+	if len(identities) <= 1 {
+		return ErrLastIdentity
+	}
+
+	if err = s.repository().DeleteIdentity(&UserIdentity{Provider: found.Provider, OpenID: found.OpenID}); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionIdentityUnlinked, fmt.Sprintf("provider: %s", providerName))
+	return nil
+}
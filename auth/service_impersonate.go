@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Impersonate 管理员模拟登录为target（客服复现用户的问题）
+// 只颁发短期的jwt（Config.ImpersonationLife），不颁发refresh token，过期后须重新模拟
+// jwt带上act（{"sub": 管理员ID}），ParseToken据此记录每一个模拟的请求
+// 权限由调用方检查（如Middleware.Authorized("admin")）
+func (s *Service) Impersonate(admin, target *User) (tokens *TokenResponse, err error) {
+	if admin.ID == target.ID {
+		return nil, ErrImpersonateSelf
+	}
+	now := s.auth.now()
+	expires := now.Add(s.auth.impersonationLife)
+	claims := jwt.MapClaims{
+		"iat": now.UTC().Unix(),
+		"sub": target.ID,
+		"exp": expires.UTC().Unix(),
+		"act": map[string]interface{}{"sub": admin.ID},
+	}
+	if err = s.enrichClaims(target, claims); err != nil {
+		return nil, err
+	}
+	tokens = &TokenResponse{TokenExpires: expires}
+	if tokens.Token, err = s.auth.jwtKeys.Encode(claims); err != nil {
+		return nil, err
+	}
+	s.record(admin.ID, ActionImpersonationStarted, fmt.Sprintf("target: %d, username: %s", target.ID, target.Username))
+	return tokens, nil
+}
+
+// EndImpersonation 结束管理员的模拟登录
+// 其颁发的模拟jwt全部立即失效；管理员自己已颁发的jwt也会失效（有refresh token的自动续约）
+func (s *Service) EndImpersonation(admin *User) (err error) {
+	if err = s.auth.revocations.Revoke(admin.ID, s.auth.now()); err != nil {
+		return err
+	}
+	s.record(admin.ID, ActionImpersonationEnded, "")
+	return nil
+}
+
+// actorFromClaims 模拟登录的管理员ID（act.sub），不是模拟登录则为0
+func actorFromClaims(claims jwt.MapClaims) uint64 {
+	act, _ := claims["act"].(map[string]interface{})
+	sub, _ := act["sub"].(float64)
+	return uint64(sub)
+}
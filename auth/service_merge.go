@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+)
+
+// MergeUsers 合并用户：把source的登录方式、refresh token、角色移到target，然后删除source
+// 同一个人用不同方式登录（如手机号、微信）可能产生了两个用户，合并后用哪种方式登录都是target
+//
+//   - 合并前调用Config.OnMergeUsers，应用在这里迁移自己的数据
+//   - source已颁发的jwt随即失效，“记住我”的设备续约后成为target
+//   - 两个用户都设置了两步验证的，保留target的（除非只有source的已启用）
+//   - 两个用户有相同的登录方式（同一个provider）的，不能合并，返回ErrMergeConflict
+//   - source的UserLog保留，另在target名下记录一条user_merged
+func (s *Service) MergeUsers(target, source *User) (err error) {
+	if target.ID == source.ID {
+		return ErrMergeSameUser
+	}
+	// 确认都存在
+	if target, err = s.repository().Find(target.ID); err != nil {
+		return err
+	}
+	if source, err = s.repository().Find(source.ID); err != nil {
+		return err
+	}
+	// 两步验证只能保留一个，优先保留已启用的、target的
+	var dropTwoFactor *UserIdentity
+	targetIdentity, targetData, err := s.findTwoFactor(target)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+	sourceIdentity, sourceData, err := s.findTwoFactor(source)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+	if targetIdentity != nil && sourceIdentity != nil {
+		dropTwoFactor = sourceIdentity
+		if sourceData.Enabled && !targetData.Enabled {
+			dropTwoFactor = targetIdentity
+		}
+	}
+
+	// 同一个provider（迁移应用的数据之前检查，合并的事务里还会再检查）
+	if err = s.checkMergeConflict(target, source, dropTwoFactor); err != nil {
+		return err
+	}
+
+	// 应用的数据
+	if s.auth.onMergeUsers != nil {
+		if err = s.auth.onMergeUsers(target, source); err != nil {
+			return err
+		}
+	}
+
+	// source的jwt失效
+	// 注销记录不在存储的事务里，先于合并写入：合并失败的，source只是需要续约一次
+	if err = s.auth.revocations.Revoke(source.ID, s.auth.now()); err != nil {
+		return err
+	}
+
+	// 合并（与删除保留不了的两步验证在一个事务里）
+	var drop []*UserIdentity
+	if dropTwoFactor != nil {
+		drop = append(drop, dropTwoFactor)
+	}
+	if err = s.repository().Merge(target, source, drop...); err != nil {
+		return err
+	}
+
+	s.record(target.ID, ActionUserMerged, fmt.Sprintf("source: %d, username: %s", source.ID, source.Username))
+	return nil
+}
+
+// checkMergeConflict 两个用户有同一个provider的登录方式（drop除外）的，返回ErrMergeConflict
+func (s *Service) checkMergeConflict(target, source *User, drop *UserIdentity) error {
+	providers := func(user *User) (map[string]bool, error) {
+		identities, err := s.repository().ListIdentitiesByUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		providers := map[string]bool{}
+		for _, identity := range identities {
+			if drop == nil || identity.Provider != drop.Provider || identity.OpenID != drop.OpenID {
+				providers[identity.Provider] = true
+			}
+		}
+		return providers, nil
+	}
+	targetProviders, err := providers(target)
+	if err != nil {
+		return err
+	}
+	sourceProviders, err := providers(source)
+	if err != nil {
+		return err
+	}
+	for provider := range sourceProviders {
+		if targetProviders[provider] {
+			return ErrMergeConflict
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// AuthorizeRequest 授权请求（授权页的参数）
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string // 为空时，client只登记了一个的，使用那一个
+	Scope               string // 为空则申请client允许的全部scope
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string // 只支持S256
+}
+
+// OAuthTokenResponse access token响应（RFC 6749 5.1）
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// ValidateAuthorizeRequest 检查授权请求，补全RedirectURI、Scope
+// client、redirect_uri无效的（ErrInvalidClient、ErrInvalidRedirectURI），不能跳转回客户端，
+// 其余错误可以带在redirect_uri上返回给客户端
+func (s *Service) ValidateAuthorizeRequest(req *AuthorizeRequest) (client *OAuthClient, err error) {
+	client, err = s.repository().FindOAuthClient(req.ClientID)
+	if err == ErrRecordNotFound {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.RedirectURI == "" {
+		if uris := strings.Fields(client.RedirectURIs); len(uris) == 1 {
+			req.RedirectURI = uris[0]
+		}
+	}
+	if !client.AllowRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if !client.AllowGrant(GrantAuthorizationCode) {
+		return client, ErrUnauthorizedClient
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return client, ErrPKCERequired
+	}
+	if req.Scope, err = s.checkScope(client, req.Scope); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+// Authorize 用户同意授权后，颁发授权码
+// req须先经过ValidateAuthorizeRequest；用户只同意部分scope的，把req.Scope改小即可
+func (s *Service) Authorize(user *User, req *AuthorizeRequest) (code string, err error) {
+	client, err := s.ValidateAuthorizeRequest(req)
+	if err != nil {
+		return "", err
+	}
+	code, err = s.repository().createOAuthCode(OAuthCode{
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+	s.record(user.ID, ActionOAuthAuthorized, fmt.Sprintf("client: %s, scope: %s", client.ID, req.Scope))
+	return code, nil
+}
+
+// ExchangeCode 用授权码换取access token（grant_type=authorization_code）
+// 公开客户端clientSecret为空；redirectURI须与授权时的一致
+func (s *Service) ExchangeCode(
+	clientID, clientSecret, code, redirectURI, codeVerifier string,
+) (tokens *OAuthTokenResponse, err error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowGrant(GrantAuthorizationCode) {
+		return nil, ErrUnauthorizedClient
+	}
+	stored, err := s.repository().takeOAuthCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if stored.ClientID != client.ID || stored.RedirectURI != redirectURI || !verifyCodeChallenge(stored.CodeChallenge, codeVerifier) {
+		return nil, ErrInvalidGrant
+	}
+	// 授权后用户可能已被删除（或合并）
+	user, err := s.repository().Find(stored.UserID)
+	if err == ErrRecordNotFound {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.issueAccessToken(client, user, stored.Scope)
+}
+
+// ClientCredentials 客户端凭证换取access token（grant_type=client_credentials），
+// access token里没有用户（sub）
+func (s *Service) ClientCredentials(clientID, clientSecret, scope string) (tokens *OAuthTokenResponse, err error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public || !client.AllowGrant(GrantClientCredentials) {
+		return nil, ErrUnauthorizedClient
+	}
+	if scope, err = s.checkScope(client, scope); err != nil {
+		return nil, err
+	}
+	return s.issueAccessToken(client, nil, scope)
+}
+
+// authenticateClient 验证client，公开客户端不验证secret
+func (s *Service) authenticateClient(clientID, clientSecret string) (*OAuthClient, error) {
+	client, err := s.repository().FindOAuthClient(clientID)
+	if err == ErrRecordNotFound {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOAuthSecret(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// checkScope 申请的scope须是client允许的，为空则为全部
+func (s *Service) checkScope(client *OAuthClient, scope string) (string, error) {
+	requested := parseScope(scope)
+	if len(requested) == 0 {
+		return strings.Join(parseScope(client.Scopes), " "), nil
+	}
+	for _, scope := range requested {
+		if !containsScope(client.Scopes, scope) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
+
+// issueAccessToken 用JWT签发access token
+// 与登录颁发的jwt一样，ParseToken可以解析，另外带上client_id、scope
+func (s *Service) issueAccessToken(client *OAuthClient, user *User, scope string) (*OAuthTokenResponse, error) {
+	now := s.auth.now()
+	claims := jwt.MapClaims{
+		"iat":       now.UTC().Unix(),
+		"exp":       now.Add(s.auth.tokenLife).UTC().Unix(),
+		"client_id": client.ID,
+		"scope":     scope,
+	}
+	if user != nil {
+		claims["sub"] = user.ID
+	}
+	tokenString, err := s.auth.jwtKeys.Encode(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthTokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.auth.tokenLife.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// verifyCodeChallenge PKCE：BASE64URL(SHA256(code_verifier)) == code_challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" || verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
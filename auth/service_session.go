@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session 登录会话，即一个有效的refresh token（“记住我”登录的设备）
+type Session struct {
+	ID        uint64    `json:"id"`
+	Device    string    `json:"device"`
+	Remark    string    `json:"remark"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+	Current   bool      `json:"current"` // 是否当前会话
+}
+
+// Sessions 列出用户的有效会话
+// currentID 为当前会话ID（见ContextRepository.SessionID()），用于标记当前会话
+func (s *Service) Sessions(user *User, currentID uint64) (sessions []*Session, err error) {
+	tokens, err := s.repository().ListTokens(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions = make([]*Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, &Session{
+			ID:        token.ID,
+			Device:    token.Device,
+			Remark:    token.Remark,
+			IssuedAt:  token.IssuedAt,
+			ExpiredAt: token.ExpiredAt,
+			Current:   token.ID == currentID,
+		})
+	}
+	return sessions, nil
+}
+
+// RenameSession 修改会话备注（如“家”、“办公室”）
+func (s *Service) RenameSession(user *User, id uint64, remark string) (err error) {
+	token, err := s.repository().FindTokenByID(user.ID, id)
+	if err == ErrRecordNotFound {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.repository().UpdateTokenRemark(token, remark)
+}
+
+// RevokeSession 注销某个会话
+// 该会话的refresh token立即失效，已颁发的jwt在有效期后自然失效
+func (s *Service) RevokeSession(user *User, id uint64) (err error) {
+	token, err := s.repository().FindTokenByID(user.ID, id)
+	if err == ErrRecordNotFound {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.repository().DeleteTokenByID(token.ID); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionSessionRevoked, fmt.Sprintf("session: %d, device: %s", token.ID, token.Device))
+	return nil
+}
+
+// RevokeOtherSessions 注销除当前会话以外的所有会话
+// currentID 为0时（当前不是“记住我”登录的），注销全部会话
+func (s *Service) RevokeOtherSessions(user *User, currentID uint64) (err error) {
+	if err = s.repository().DeleteTokensExcept(user.ID, currentID); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionSessionRevoked, fmt.Sprintf("all except session: %d", currentID))
+	return nil
+}
+
+// RevokeAllSessions 注销全部会话、吊销全部API key，并且已颁发的jwt立即失效
+// 用于重设密码等（账号可能已被盗用）的场景，reason记录在日志里
+func (s *Service) RevokeAllSessions(user *User, reason string) (err error) {
+	if err = s.auth.revocations.Revoke(user.ID, s.auth.now()); err != nil {
+		return err
+	}
+	if err = s.repository().DeleteTokensExcept(user.ID, 0); err != nil {
+		return err
+	}
+	if err = s.repository().DeleteAPIKeys(user.ID); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionSessionRevoked, fmt.Sprintf("all, reason: %s", reason))
+	return nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+)
+
+// ThrottledProvider 可选接口，实现后Service.Login按账号、IP限制失败次数（防暴力破解）
+// 比如密码登录；而像微信、钉钉这种由第三方验证的，就不需要
+type ThrottledProvider interface {
+	LoginProvider
+	// Account 从登录凭证中取出账号（即UserIdentity.OpenID），取不到则返回空
+	Account(credentials []byte) string
+}
+
+// throttleKey 限制的维度（账号、IP）
+type throttleKey struct {
+	key          string
+	lockoutAfter int
+	provider     string
+	account      string // IP维度为空
+}
+
+// throttleKeys 本次登录涉及的限制维度
+func (s *Service) throttleKeys(provider ThrottledProvider, credentials []byte) (keys []throttleKey) {
+	throttle := s.auth.loginThrottle
+	if account := provider.Account(credentials); account != "" {
+		keys = append(keys, throttleKey{
+			key:          fmt.Sprintf("account:%s:%s", provider.Name(), account),
+			lockoutAfter: throttle.LockoutAfter,
+			provider:     provider.Name(),
+			account:      account,
+		})
+	}
+	if s.clientIP != "" {
+		keys = append(keys, throttleKey{
+			key:          "ip:" + s.clientIP,
+			lockoutAfter: throttle.IPLockoutAfter,
+			provider:     provider.Name(),
+		})
+	}
+	return
+}
+
+// checkThrottle 登录前检查是否被锁定、是否须等待
+// 存储出错时不阻止登录，只记录日志
+func (s *Service) checkThrottle(keys []throttleKey) error {
+	now := s.auth.now()
+	for _, k := range keys {
+		attempt, err := s.auth.loginAttempts.Get(k.key)
+		if err != nil {
+			log.Printf("auth: 查询登录失败记录出错: %s", err)
+			continue
+		}
+		if now.Before(attempt.LockedUntil) {
+			return ErrLoginLocked
+		}
+		if now.Before(attempt.LastFailedAt.Add(s.auth.loginThrottle.delay(attempt.Failures))) {
+			return ErrLoginThrottled
+		}
+	}
+	return nil
+}
+
+// failThrottle 记录登录失败，达到次数则锁定
+func (s *Service) failThrottle(keys []throttleKey) {
+	throttle := s.auth.loginThrottle
+	now := s.auth.now()
+	for _, k := range keys {
+		attempt, err := s.auth.loginAttempts.Fail(k.key, now, throttle.Window)
+		if err != nil {
+			log.Printf("auth: 记录登录失败出错: %s", err)
+			continue
+		}
+		if k.lockoutAfter <= 0 || attempt.Failures < k.lockoutAfter {
+			continue
+		}
+		if err := s.auth.loginAttempts.Lock(k.key, now.Add(throttle.LockoutDuration)); err != nil {
+			log.Printf("auth: 锁定登录出错: %s", err)
+			continue
+		}
+
+		// 记录到被锁定的用户名下（如果账号存在）
+		var userID uint64
+		if k.account != "" {
+			if identity, err := s.repository().FindIdentity(k.provider, k.account); err == nil {
+				userID = identity.UserID
+			}
+		}
+		s.record(userID, ActionLoginLocked, fmt.Sprintf(
+			"%s, failures: %d, until: %s", k.key, attempt.Failures, now.Add(throttle.LockoutDuration).Format("2006-01-02 15:04:05"),
+		))
+	}
+}
+
+// resetThrottle 登录成功，清除账号的失败记录
+// IP的失败记录保留，避免攻击者用自己的账号登录来重置计数
+func (s *Service) resetThrottle(keys []throttleKey) {
+	for _, k := range keys {
+		if k.account == "" {
+			continue
+		}
+		if err := s.auth.loginAttempts.Reset(k.key); err != nil {
+			log.Printf("auth: 清除登录失败记录出错: %s", err)
+		}
+	}
+}
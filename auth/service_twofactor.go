@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TwoFactorProvider 两步验证（TOTP）的数据保存在UserIdentity里，Provider为"totp"，OpenID为用户ID
+// 它不是LoginProvider，不能直接用来登录
+const TwoFactorProvider = "totp"
+
+// recoveryCodeCount 恢复码个数
+const recoveryCodeCount = 10
+
+// twoFactorData UserIdentity.Data
+type twoFactorData struct {
+	Secret        string   `json:"secret"`         // 加密后的密钥
+	Enabled       bool     `json:"enabled"`        // 已确认启用
+	RecoveryCodes []string `json:"recovery_codes"` // 恢复码的sha256，用过即删
+	LastStep      int64    `json:"last_step"`      // 最后使用的TOTP周期，防止验证码被重复使用
+	// UsedChallenges 已经用过的challenge（nonce => 过期时间），防止challenge被重复使用
+	UsedChallenges map[string]int64 `json:"used_challenges,omitempty"`
+}
+
+// twoFactorChallenge 登录第一步通过后颁发的challenge（加密后交给客户端）
+type twoFactorChallenge struct {
+	Nonce    string `json:"i"`
+	UserID   uint64 `json:"u"`
+	Provider string `json:"p"`
+	Remember bool   `json:"r"`
+	Device   string `json:"d"`
+	Remark   string `json:"n"`
+	Expires  int64  `json:"e"`
+}
+
+// TwoFactorEnabled 用户是否已启用两步验证
+func (s *Service) TwoFactorEnabled(user *User) (bool, error) {
+	_, data, err := s.findTwoFactor(user)
+	if err == ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return data.Enabled, nil
+}
+
+// EnrollTOTP 开始启用两步验证：生成密钥，返回密钥和otpauth URI（转成二维码给App扫描）
+// 须再调用ConfirmTOTP，验证App生成的验证码之后才会启用
+func (s *Service) EnrollTOTP(user *User) (secret, uri string, err error) {
+	identity, data, err := s.findTwoFactor(user)
+	if err != nil && err != ErrRecordNotFound {
+		return "", "", err
+	}
+	if data != nil && data.Enabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret = newTOTPSecret()
+	data = &twoFactorData{Secret: base64.RawURLEncoding.EncodeToString(s.auth.twoFactorKeys.seal([]byte(secret)))}
+	if identity == nil {
+		_, err = s.repository().CreateIdentity(user.ID, TwoFactorProvider, strconv.FormatUint(user.ID, 10), data)
+	} else {
+		err = s.saveTwoFactor(identity, data)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(secret, s.auth.twoFactorIssuer, user.Username), nil
+}
+
+// ConfirmTOTP 验证App生成的验证码，启用两步验证，返回恢复码（只显示这一次）
+func (s *Service) ConfirmTOTP(user *User, code string) (recoveryCodes []string, err error) {
+	identity, data, err := s.findTwoFactor(user)
+	if err == ErrRecordNotFound || (err == nil && data.Secret == "") {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if _, err = s.verifyTwoFactor(user, data, code); err != nil {
+		return nil, err
+	}
+
+	data.Enabled = true
+	recoveryCodes, data.RecoveryCodes = newRecoveryCodes()
+	if err = s.saveTwoFactor(identity, data); err != nil {
+		return nil, err
+	}
+	s.record(user.ID, ActionTwoFactorEnabled, "")
+	return recoveryCodes, nil
+}
+
+// DisableTOTP 停用两步验证，须提供验证码或恢复码
+func (s *Service) DisableTOTP(user *User, code string) (err error) {
+	identity, data, err := s.findEnabledTwoFactor(user)
+	if err != nil {
+		return err
+	}
+	if _, err = s.verifyTwoFactor(user, data, code); err != nil {
+		return err
+	}
+	if err = s.saveTwoFactor(identity, &twoFactorData{}); err != nil {
+		return err
+	}
+	s.record(user.ID, ActionTwoFactorDisabled, "")
+	return nil
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（原来的作废），须提供验证码或恢复码
+func (s *Service) RegenerateRecoveryCodes(user *User, code string) (recoveryCodes []string, err error) {
+	identity, data, err := s.findEnabledTwoFactor(user)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.verifyTwoFactor(user, data, code); err != nil {
+		return nil, err
+	}
+	recoveryCodes, data.RecoveryCodes = newRecoveryCodes()
+	if err = s.saveTwoFactor(identity, data); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// VerifyTwoFactor 登录第二步：凭Login返回的challenge和验证码（或恢复码）换取token
+func (s *Service) VerifyTwoFactor(challenge, code string) (tokens *TokenResponse, err error) {
+	c, err := s.openChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repository().Find(c.UserID)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	identity, data, err := s.findEnabledTwoFactor(user)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := data.UsedChallenges[c.Nonce]; ok {
+		return nil, ErrInvalidChallenge
+	}
+	method, err := s.verifyTwoFactor(user, data, code)
+	if err != nil {
+		return nil, err
+	}
+	// 标记challenge已用（与验证码一起保存）
+	data.useChallenge(c, s.auth.now())
+	if err = s.saveTwoFactor(identity, data); err != nil {
+		return nil, err
+	}
+
+	// issue tokens
+	tokens, err = s.issueTokens(user, c.Remember, c.Device, c.Remark)
+	if err != nil {
+		return nil, err
+	}
+	s.record(user.ID, ActionLogin, fmt.Sprintf("provider: %s, device: %s, 2fa: %s", c.Provider, c.Device, method))
+	return
+}
+
+// issueChallenge 颁发challenge
+func (s *Service) issueChallenge(
+	user *User, providerName string, remember bool, device, remark string,
+) (tokens *TokenResponse, err error) {
+	expires := s.auth.now().Add(s.auth.challengeLife)
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(&twoFactorChallenge{
+		Nonce:    base64.RawURLEncoding.EncodeToString(nonce),
+		UserID:   user.ID,
+		Provider: providerName,
+		Remember: remember,
+		Device:   device,
+		Remark:   remark,
+		Expires:  expires.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		Challenge:        base64.RawURLEncoding.EncodeToString(s.auth.challengeKeys.seal(payload)),
+		ChallengeExpires: &expires,
+	}, nil
+}
+
+// openChallenge 解密并检查有效期
+func (s *Service) openChallenge(challenge string) (*twoFactorChallenge, error) {
+	data, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	payload, err := s.auth.challengeKeys.open(data)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	c := &twoFactorChallenge{}
+	if err = json.Unmarshal(payload, c); err != nil || c.UserID == 0 || c.Nonce == "" {
+		return nil, ErrInvalidChallenge
+	}
+	if s.auth.now().Unix() > c.Expires {
+		return nil, ErrInvalidChallenge
+	}
+	return c, nil
+}
+
+// verifyTwoFactor 验证TOTP验证码或恢复码，返回验证方式（totp、recovery_code）
+// 与密码登录一样，限制失败次数
+// 通过后只修改data（LastStep、删除用过的恢复码），由调用方连同其他修改一起saveTwoFactor
+func (s *Service) verifyTwoFactor(
+	user *User, data *twoFactorData, code string,
+) (method string, err error) {
+	keys := s.twoFactorThrottleKeys(user)
+	if err = s.checkThrottle(keys); err != nil {
+		return "", err
+	}
+
+	code = strings.ToUpper(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	if len(code) == totpDigits {
+		// TOTP
+		method = "totp"
+		secret, err := s.auth.twoFactorKeys.open(decodeBase64(data.Secret))
+		if err != nil {
+			return "", err
+		}
+		if step, ok := verifyTOTP(string(secret), code, s.auth.now(), data.LastStep); ok {
+			data.LastStep = step
+			// 用当前key重新加密（随data一起保存），轮换后旧key加密的逐步迁移
+			data.Secret = base64.RawURLEncoding.EncodeToString(s.auth.twoFactorKeys.seal(secret))
+			s.resetThrottle(keys)
+			return method, nil
+		}
+	} else if data.Enabled {
+		// 恢复码
+		method = "recovery_code"
+		hash := hashRecoveryCode(code)
+		for i, stored := range data.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				data.RecoveryCodes = append(data.RecoveryCodes[:i:i], data.RecoveryCodes[i+1:]...)
+				s.resetThrottle(keys)
+				return method, nil
+			}
+		}
+	}
+
+	s.failThrottle(keys)
+	s.record(user.ID, ActionTwoFactorFailed, method)
+	return "", ErrInvalidTwoFactorCode
+}
+
+// twoFactorThrottleKeys 两步验证的失败次数限制（按用户、IP）
+func (s *Service) twoFactorThrottleKeys(user *User) (keys []throttleKey) {
+	account := strconv.FormatUint(user.ID, 10)
+	keys = append(keys, throttleKey{
+		key:          fmt.Sprintf("account:%s:%s", TwoFactorProvider, account),
+		lockoutAfter: s.auth.loginThrottle.LockoutAfter,
+		provider:     TwoFactorProvider,
+		account:      account,
+	})
+	if s.clientIP != "" {
+		keys = append(keys, throttleKey{
+			key:          "ip:" + s.clientIP,
+			lockoutAfter: s.auth.loginThrottle.IPLockoutAfter,
+			provider:     TwoFactorProvider,
+		})
+	}
+	return
+}
+
+// findTwoFactor 查找两步验证数据
+func (s *Service) findTwoFactor(user *User) (identity *UserIdentity, data *twoFactorData, err error) {
+	identity, err = s.repository().FindIdentityByUser(user.ID, TwoFactorProvider)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = &twoFactorData{}
+	if identity.Data != nil {
+		if err = json.Unmarshal(*identity.Data, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return identity, data, nil
+}
+
+// findEnabledTwoFactor 查找已启用的两步验证数据
+func (s *Service) findEnabledTwoFactor(user *User) (identity *UserIdentity, data *twoFactorData, err error) {
+	identity, data, err = s.findTwoFactor(user)
+	if err == ErrRecordNotFound || (err == nil && !data.Enabled) {
+		return nil, nil, ErrTwoFactorNotEnabled
+	}
+	return
+}
+
+// saveTwoFactor 保存两步验证数据
+// 读取之后已经被并发的请求修改过的（例如同一个验证码、恢复码、challenge同时使用），保存失败，返回ErrInvalidTwoFactorCode
+func (s *Service) saveTwoFactor(identity *UserIdentity, data *twoFactorData) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ok, err := s.auth.storage.CompareAndUpdateIdentityData(identity, json.RawMessage(bytes))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// useChallenge 标记challenge已用，并清理已过期的
+func (data *twoFactorData) useChallenge(c *twoFactorChallenge, now time.Time) {
+	used := map[string]int64{c.Nonce: c.Expires}
+	for nonce, expires := range data.UsedChallenges {
+		if expires >= now.Unix() {
+			used[nonce] = expires
+		}
+	}
+	data.UsedChallenges = used
+}
+
+// newRecoveryCodes 生成恢复码，返回明文（给用户）和sha256（保存）
+// 格式：XXXXX-XXXXX
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			panic(err.Error())
+		}
+		code := totpEncoding.EncodeToString(b)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return
+}
+
+// hashRecoveryCode 恢复码是高熵随机数，sha256即可
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeBase64 解码失败返回nil（随后解密会失败）
+func decodeBase64(s string) []byte {
+	data, _ := base64.RawURLEncoding.DecodeString(s)
+	return data
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Storage 存储后端
+// Repository 的数据读写都通过它完成，
+// 包内提供了两种实现：
+//   - NewGormStorage(db) 数据库
+//   - NewMemoryStorage() 内存（适合测试、单机小服务）
+//
+// 找不到记录时，须返回 ErrRecordNotFound
+type Storage interface {
+	// AutoMigrate 创建数据表
+	AutoMigrate() error
+
+	// User
+	FindUser(id uint64) (*User, error)
+	FindUserByUsername(username string) (*User, error)
+	CreateUser(user *User) error
+	UpdateUser(user *User, update User) error   // 只更新非零值字段，并写回user
+	ListUsers(query UserQuery) ([]*User, error) // 按ID排序
+	CountUsers(query UserQuery) (int, error)    // 忽略Offset、Limit
+	// MergeUsers 在一个事务里：删除drop，把source的UserIdentity、Token、APIKey、UserRole移到target，然后删除source
+	// 两个用户有同一个provider的UserIdentity的，回滚并返回ErrMergeConflict
+	MergeUsers(targetID, sourceID uint64, drop []*UserIdentity) error
+
+	// UserIdentity
+	FindIdentity(provider, openID string) (*UserIdentity, error)
+	FindIdentityByUser(userID uint64, provider string) (*UserIdentity, error)
+	CreateIdentity(identity *UserIdentity) error
+	UpdateIdentityData(identity *UserIdentity, data json.RawMessage) error
+	// CompareAndUpdateIdentityData 存储的Data仍与identity.Data一致才更新（乐观锁），否则返回false
+	CompareAndUpdateIdentityData(identity *UserIdentity, data json.RawMessage) (bool, error)
+	UpdateIdentityUser(identity *UserIdentity, userID uint64) error
+	ListIdentitiesByUser(userID uint64) ([]*UserIdentity, error) // 按provider排序
+	DeleteIdentity(provider, openID string) error
+
+	// Token
+	FindToken(id uint64) (*Token, error)
+	CreateToken(token *Token) error
+	DeleteTokens(userID uint64, device string) error
+	// RotateToken 在一个事务里：标记为已轮换（family改为successor的），并创建successor
+	// 已经标记过的返回false，不创建
+	RotateToken(id uint64, at time.Time, successor *Token) (bool, error)
+	DeleteTokenFamily(family string) error
+	ListTokens(userID uint64) ([]*Token, error) // 按颁发时间倒序
+	UpdateTokenRemark(id uint64, remark string) error
+	DeleteToken(id uint64) error
+	DeleteTokensExcept(userID uint64, exceptID uint64) error
+
+	// UserLog
+	CreateLog(log *UserLog) error
+	ListLogs(query LogQuery) ([]*UserLog, error) // 按时间倒序
+	CountLogs(query LogQuery) (int, error)       // 忽略Offset、Limit
+
+	// Role、Permission
+	FindRole(name string) (*Role, error)
+	ListRoles() ([]*Role, error)
+	CreateRole(role *Role) error
+	DeleteRole(name string) error // 同时解除用户、权限的关联
+	FindPermission(name string) (*Permission, error)
+	CreatePermission(permission *Permission) error
+	CreateRolePermission(role, permission string) error // 已存在则忽略
+	DeleteRolePermission(role, permission string) error
+	CreateUserRole(userID uint64, role string) error // 已存在则忽略
+	DeleteUserRole(userID uint64, role string) error
+	FindRolesByUser(userID uint64) ([]string, error)
+	FindPermissionsByUser(userID uint64) ([]string, error)
+
+	// OAuthClient、OAuthCode
+	FindOAuthClient(id string) (*OAuthClient, error)
+	ListOAuthClients() ([]*OAuthClient, error) // 按ID排序
+	CreateOAuthClient(client *OAuthClient) error
+	DeleteOAuthClient(id string) error // 同时删除未使用的授权码
+	CreateOAuthCode(code *OAuthCode) error
+	TakeOAuthCode(hash string) (*OAuthCode, error) // 取出并删除（只能取一次）
+	DeleteOAuthCodes(before time.Time) error       // 删除过期的授权码
+
+	// APIKey
+	FindAPIKey(hash string) (*APIKey, error)
+	ListAPIKeys(userID uint64) ([]*APIKey, error) // 按创建时间倒序
+	CreateAPIKey(key *APIKey) error
+	TouchAPIKey(id uint64, at time.Time) error // 更新LastUsedAt
+	DeleteAPIKey(userID, id uint64) error      // 不属于该用户的返回ErrRecordNotFound
+	DeleteAPIKeys(userID uint64) error         // 删除用户的全部API key
+}
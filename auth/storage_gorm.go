@@ -0,0 +1,482 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// NewGormStorage 数据库存储
+func NewGormStorage(db *gorm.DB) Storage {
+	if db == nil {
+		panic("NewGormStorage 缺少有效的*gorm.DB对象")
+	}
+	return &gormStorage{db: db}
+}
+
+// gormStorage 基于gorm的存储
+type gormStorage struct {
+	db *gorm.DB
+}
+
+// AutoMigrate 创建数据表
+func (s *gormStorage) AutoMigrate() error {
+	return s.db.AutoMigrate(
+		&User{}, &UserIdentity{}, &Token{}, &UserLog{},
+		&Role{}, &Permission{}, &UserRole{}, &RolePermission{},
+		&Revocation{}, &OAuthClient{}, &OAuthCode{}, &APIKey{},
+	).Error
+}
+
+// User ...
+
+func (s *gormStorage) FindUser(id uint64) (user *User, err error) {
+	user = &User{}
+	err = s.db.Where("id = ?", id).Take(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) FindUserByUsername(username string) (user *User, err error) {
+	user = &User{}
+	err = s.db.Where("username = ?", username).Take(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) CreateUser(user *User) error {
+	return s.db.Create(user).Error
+}
+
+func (s *gormStorage) UpdateUser(user *User, update User) error {
+	return s.db.Model(user).Updates(update).Error
+}
+
+func (s *gormStorage) ListUsers(query UserQuery) (users []*User, err error) {
+	db := s.userQuery(query).Order("id").Offset(query.Offset)
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err = db.Find(&users).Error
+	return
+}
+
+func (s *gormStorage) CountUsers(query UserQuery) (count int, err error) {
+	err = s.userQuery(query).Count(&count).Error
+	return
+}
+
+func (s *gormStorage) MergeUsers(targetID, sourceID uint64, drop []*UserIdentity) error {
+	tx := s.db.Begin()
+	for _, identity := range drop {
+		if err := tx.Where("provider = ? and open_id = ?", identity.Provider, identity.OpenID).Delete(&UserIdentity{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// 同一个provider
+	var conflicts int
+	if err := tx.Model(&UserIdentity{}).
+		Where("user_id = ? and provider IN (?)", targetID,
+			tx.Model(&UserIdentity{}).Select("provider").Where("user_id = ?", sourceID).QueryExpr()).
+		Count(&conflicts).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if conflicts > 0 {
+		tx.Rollback()
+		return ErrMergeConflict
+	}
+	if err := tx.Model(&UserIdentity{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&Token{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&APIKey{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 角色取并集
+	roles := []string{}
+	if err := tx.Model(&UserRole{}).Where("user_id = ?", sourceID).Pluck("role", &roles).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, role := range roles {
+		ur := &UserRole{UserID: targetID, Role: role}
+		if err := tx.Where(ur).FirstOrCreate(ur).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Where("user_id = ?", sourceID).Delete(&UserRole{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("id = ?", sourceID).Delete(&User{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *gormStorage) userQuery(query UserQuery) *gorm.DB {
+	db := s.db.Model(&User{})
+	if len(query.IDs) > 0 {
+		db = db.Where("id IN (?)", query.IDs)
+	}
+	if query.Username != "" {
+		db = db.Where("username = ?", query.Username)
+	}
+	if query.AfterID != 0 {
+		db = db.Where("id > ?", query.AfterID)
+	}
+	if query.BeforeID != 0 {
+		db = db.Where("id < ?", query.BeforeID)
+	}
+	return db
+}
+
+// UserIdentity ...
+
+func (s *gormStorage) FindIdentity(provider, openID string) (identity *UserIdentity, err error) {
+	identity = &UserIdentity{}
+	err = s.db.Where("provider = ? and open_id = ?", provider, openID).Take(identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) FindIdentityByUser(userID uint64, provider string) (identity *UserIdentity, err error) {
+	identity = &UserIdentity{}
+	err = s.db.Where("user_id = ? and provider = ?", userID, provider).Take(identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) CreateIdentity(identity *UserIdentity) error {
+	return s.db.Create(identity).Error
+}
+
+func (s *gormStorage) UpdateIdentityData(identity *UserIdentity, data json.RawMessage) error {
+	return s.db.Model(identity).Update("data", data).Error
+}
+
+func (s *gormStorage) CompareAndUpdateIdentityData(identity *UserIdentity, data json.RawMessage) (bool, error) {
+	db := s.db.Model(&UserIdentity{}).Where("provider = ? and open_id = ?", identity.Provider, identity.OpenID)
+	if identity.Data == nil {
+		db = db.Where("data IS NULL")
+	} else {
+		db = db.Where("data = ?", []byte(*identity.Data))
+	}
+	db = db.Update("data", data)
+	if db.Error != nil {
+		return false, db.Error
+	}
+	if db.RowsAffected != 1 {
+		return false, nil
+	}
+	identity.Data = &data
+	return true, nil
+}
+
+func (s *gormStorage) UpdateIdentityUser(identity *UserIdentity, userID uint64) error {
+	return s.db.Model(identity).Update("user_id", userID).Error
+}
+
+func (s *gormStorage) ListIdentitiesByUser(userID uint64) (identities []*UserIdentity, err error) {
+	err = s.db.Where("user_id = ?", userID).Order("provider, open_id").Find(&identities).Error
+	return
+}
+
+func (s *gormStorage) DeleteIdentity(provider, openID string) error {
+	return s.db.Where("provider = ? and open_id = ?", provider, openID).Delete(&UserIdentity{}).Error
+}
+
+// Token ...
+
+func (s *gormStorage) FindToken(id uint64) (token *Token, err error) {
+	token = &Token{}
+	err = s.db.Where("id = ?", id).Take(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) CreateToken(token *Token) error {
+	return s.db.Create(token).Error
+}
+
+func (s *gormStorage) DeleteTokens(userID uint64, device string) error {
+	return s.db.Where("user_id = ? and device = ?", userID, device).Delete(&Token{}).Error
+}
+
+func (s *gormStorage) RotateToken(id uint64, at time.Time, successor *Token) (bool, error) {
+	tx := s.db.Begin()
+	db := tx.Model(&Token{}).
+		Where("id = ? and rotated_at IS NULL", id).
+		Updates(map[string]interface{}{"rotated_at": at, "family": successor.Family})
+	if db.Error != nil {
+		tx.Rollback()
+		return false, db.Error
+	}
+	if db.RowsAffected != 1 {
+		tx.Rollback()
+		return false, nil
+	}
+	if err := tx.Create(successor).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit().Error
+}
+
+func (s *gormStorage) DeleteTokenFamily(family string) error {
+	return s.db.Where("family = ?", family).Delete(&Token{}).Error
+}
+
+func (s *gormStorage) ListTokens(userID uint64) (tokens []*Token, err error) {
+	err = s.db.Where("user_id = ?", userID).Order("issued_at desc, id desc").Find(&tokens).Error
+	return
+}
+
+func (s *gormStorage) UpdateTokenRemark(id uint64, remark string) error {
+	return s.db.Model(&Token{}).Where("id = ?", id).Update("remark", remark).Error
+}
+
+func (s *gormStorage) DeleteToken(id uint64) error {
+	return s.db.Where("id = ?", id).Delete(&Token{}).Error
+}
+
+func (s *gormStorage) DeleteTokensExcept(userID uint64, exceptID uint64) error {
+	return s.db.Where("user_id = ? and id <> ?", userID, exceptID).Delete(&Token{}).Error
+}
+
+// UserLog ...
+
+func (s *gormStorage) CreateLog(log *UserLog) error {
+	return s.db.Create(log).Error
+}
+
+func (s *gormStorage) ListLogs(query LogQuery) (logs []*UserLog, err error) {
+	db := s.logQuery(query).Order("created_at desc, id desc").Offset(query.Offset)
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err = db.Find(&logs).Error
+	return
+}
+
+func (s *gormStorage) CountLogs(query LogQuery) (count int, err error) {
+	err = s.logQuery(query).Count(&count).Error
+	return
+}
+
+func (s *gormStorage) logQuery(query LogQuery) *gorm.DB {
+	db := s.db.Model(&UserLog{})
+	if query.UserID != 0 {
+		db = db.Where("user_id = ?", query.UserID)
+	}
+	if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("created_at < ?", query.Until)
+	}
+	return db
+}
+
+// Role、Permission ...
+
+func (s *gormStorage) FindRole(name string) (role *Role, err error) {
+	role = &Role{}
+	err = s.db.Where("name = ?", name).Take(role).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) ListRoles() (roles []*Role, err error) {
+	err = s.db.Order("name").Find(&roles).Error
+	return
+}
+
+func (s *gormStorage) CreateRole(role *Role) error {
+	return s.db.Create(role).Error
+}
+
+func (s *gormStorage) DeleteRole(name string) error {
+	tx := s.db.Begin()
+	if err := tx.Where("role = ?", name).Delete(&UserRole{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("role = ?", name).Delete(&RolePermission{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("name = ?", name).Delete(&Role{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *gormStorage) FindPermission(name string) (permission *Permission, err error) {
+	permission = &Permission{}
+	err = s.db.Where("name = ?", name).Take(permission).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) CreatePermission(permission *Permission) error {
+	return s.db.Create(permission).Error
+}
+
+func (s *gormStorage) CreateRolePermission(role, permission string) error {
+	rp := &RolePermission{Role: role, Permission: permission}
+	return s.db.Where(rp).FirstOrCreate(rp).Error
+}
+
+func (s *gormStorage) DeleteRolePermission(role, permission string) error {
+	return s.db.Where("role = ? and permission = ?", role, permission).Delete(&RolePermission{}).Error
+}
+
+func (s *gormStorage) CreateUserRole(userID uint64, role string) error {
+	ur := &UserRole{UserID: userID, Role: role}
+	return s.db.Where(ur).FirstOrCreate(ur).Error
+}
+
+func (s *gormStorage) DeleteUserRole(userID uint64, role string) error {
+	return s.db.Where("user_id = ? and role = ?", userID, role).Delete(&UserRole{}).Error
+}
+
+func (s *gormStorage) FindRolesByUser(userID uint64) (roles []string, err error) {
+	roles = []string{}
+	err = s.db.Model(&UserRole{}).Where("user_id = ?", userID).Order("role").Pluck("role", &roles).Error
+	return
+}
+
+func (s *gormStorage) FindPermissionsByUser(userID uint64) (permissions []string, err error) {
+	permissions = []string{}
+	err = s.db.Model(&RolePermission{}).
+		Joins("JOIN user_roles ON user_roles.role = role_permissions.role").
+		Where("user_roles.user_id = ?", userID).
+		Order("role_permissions.permission").
+		Pluck("DISTINCT role_permissions.permission", &permissions).Error
+	return
+}
+
+// OAuthClient、OAuthCode ...
+
+func (s *gormStorage) FindOAuthClient(id string) (client *OAuthClient, err error) {
+	client = &OAuthClient{}
+	err = s.db.Where("id = ?", id).Take(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) ListOAuthClients() (clients []*OAuthClient, err error) {
+	err = s.db.Order("id").Find(&clients).Error
+	return
+}
+
+func (s *gormStorage) CreateOAuthClient(client *OAuthClient) error {
+	return s.db.Create(client).Error
+}
+
+func (s *gormStorage) DeleteOAuthClient(id string) error {
+	tx := s.db.Begin()
+	if err := tx.Where("client_id = ?", id).Delete(&OAuthCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("id = ?", id).Delete(&OAuthClient{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *gormStorage) CreateOAuthCode(code *OAuthCode) error {
+	return s.db.Create(code).Error
+}
+
+func (s *gormStorage) TakeOAuthCode(hash string) (code *OAuthCode, err error) {
+	code = &OAuthCode{}
+	if err = s.db.Where("hash = ?", hash).Take(code).Error; err != nil {
+		return nil, err
+	}
+	// 并发兑换同一个授权码时，只有删除成功的那个算数
+	db := s.db.Where("hash = ?", hash).Delete(&OAuthCode{})
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if db.RowsAffected != 1 {
+		return nil, ErrRecordNotFound
+	}
+	return
+}
+
+func (s *gormStorage) DeleteOAuthCodes(before time.Time) error {
+	return s.db.Where("expired_at < ?", before).Delete(&OAuthCode{}).Error
+}
+
+// APIKey ...
+
+func (s *gormStorage) FindAPIKey(hash string) (key *APIKey, err error) {
+	key = &APIKey{}
+	err = s.db.Where("hash = ?", hash).Take(key).Error
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *gormStorage) ListAPIKeys(userID uint64) (keys []*APIKey, err error) {
+	err = s.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&keys).Error
+	return
+}
+
+func (s *gormStorage) CreateAPIKey(key *APIKey) error {
+	return s.db.Create(key).Error
+}
+
+func (s *gormStorage) TouchAPIKey(id uint64, at time.Time) error {
+	return s.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
+
+func (s *gormStorage) DeleteAPIKeys(userID uint64) error {
+	return s.db.Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
+
+func (s *gormStorage) DeleteAPIKey(userID, id uint64) error {
+	db := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
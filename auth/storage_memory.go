@@ -0,0 +1,821 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStorageMaxLogs 内存存储最多保留的行为记录条数，超出则删除最早的
+const memoryStorageMaxLogs = 10000
+
+// NewMemoryStorage 内存存储
+// 进程重启后数据丢失，多实例之间也不共享，只适合测试或单实例的小服务
+// 行为记录只保留最近的10000条，长期保存请用NewGormStorage
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		maxLogs:         memoryStorageMaxLogs,
+		users:           map[uint64]*User{},
+		identities:      map[string]*UserIdentity{},
+		tokens:          map[uint64]*Token{},
+		roles:           map[string]*Role{},
+		permissions:     map[string]*Permission{},
+		userRoles:       map[uint64]map[string]bool{},
+		rolePermissions: map[string]map[string]bool{},
+		oauthClients:    map[string]*OAuthClient{},
+		oauthCodes:      map[string]*OAuthCode{},
+		apiKeys:         map[uint64]*APIKey{},
+	}
+}
+
+// memoryStorage 基于内存的存储
+// 返回的都是副本，修改返回值不会影响存储的数据
+type memoryStorage struct {
+	mu sync.RWMutex
+
+	users           map[uint64]*User
+	identities      map[string]*UserIdentity // provider + "\x00" + openID
+	tokens          map[uint64]*Token
+	logs            []*UserLog // 按时间顺序，最多maxLogs条
+	maxLogs         int
+	roles           map[string]*Role
+	permissions     map[string]*Permission
+	userRoles       map[uint64]map[string]bool
+	rolePermissions map[string]map[string]bool
+	oauthClients    map[string]*OAuthClient
+	oauthCodes      map[string]*OAuthCode // hash => code
+	apiKeys         map[uint64]*APIKey
+
+	lastUserID   uint64
+	lastTokenID  uint64
+	lastLogID    uint64
+	lastAPIKeyID uint64
+}
+
+// AutoMigrate 内存存储无需建表
+func (s *memoryStorage) AutoMigrate() error {
+	return nil
+}
+
+// User ...
+
+func (s *memoryStorage) FindUser(id uint64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryStorage) FindUserByUsername(username string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user := s.findUserByUsername(username)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryStorage) findUserByUsername(username string) *User {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.Username == "" {
+		return fmt.Errorf("username不能为空")
+	}
+	if s.findUserByUsername(user.Username) != nil {
+		return ErrDuplicateKey
+	}
+	s.lastUserID++
+	now := time.Now()
+	user.ID = s.lastUserID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	copied := *user
+	s.users[user.ID] = &copied
+	return nil
+}
+
+func (s *memoryStorage) UpdateUser(user *User, update User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if update.Username != "" && update.Username != stored.Username {
+		if s.findUserByUsername(update.Username) != nil {
+			return ErrDuplicateKey
+		}
+		stored.Username = update.Username
+	}
+	if update.Name != "" {
+		stored.Name = update.Name
+	}
+	if update.Avatar != "" {
+		stored.Avatar = update.Avatar
+	}
+	stored.UpdatedAt = time.Now()
+	*user = *stored
+	return nil
+}
+
+func (s *memoryStorage) MergeUsers(targetID, sourceID uint64, drop []*UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[targetID]; !ok {
+		return ErrRecordNotFound
+	}
+	if _, ok := s.users[sourceID]; !ok {
+		return ErrRecordNotFound
+	}
+	// 先检查同一个provider，再修改（相当于回滚）
+	dropped := map[string]bool{}
+	for _, identity := range drop {
+		dropped[identityKey(identity.Provider, identity.OpenID)] = true
+	}
+	providers := map[uint64]map[string]bool{targetID: {}, sourceID: {}}
+	for key, identity := range s.identities {
+		if !dropped[key] && providers[identity.UserID] != nil {
+			providers[identity.UserID][identity.Provider] = true
+		}
+	}
+	for provider := range providers[sourceID] {
+		if providers[targetID][provider] {
+			return ErrMergeConflict
+		}
+	}
+	for key := range dropped {
+		delete(s.identities, key)
+	}
+	for _, identity := range s.identities {
+		if identity.UserID == sourceID {
+			identity.UserID = targetID
+		}
+	}
+	for _, token := range s.tokens {
+		if token.UserID == sourceID {
+			token.UserID = targetID
+		}
+	}
+	for _, key := range s.apiKeys {
+		if key.UserID == sourceID {
+			key.UserID = targetID
+		}
+	}
+	if len(s.userRoles[sourceID]) > 0 && s.userRoles[targetID] == nil {
+		s.userRoles[targetID] = map[string]bool{}
+	}
+	for role := range s.userRoles[sourceID] {
+		s.userRoles[targetID][role] = true
+	}
+	delete(s.userRoles, sourceID)
+	delete(s.users, sourceID)
+	return nil
+}
+
+func (s *memoryStorage) ListUsers(query UserQuery) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := s.filterUsers(query)
+	if query.Offset > len(users) {
+		query.Offset = len(users)
+	}
+	users = users[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(users) {
+		users = users[:query.Limit]
+	}
+	return users, nil
+}
+
+func (s *memoryStorage) CountUsers(query UserQuery) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.filterUsers(query)), nil
+}
+
+// filterUsers 按条件过滤，按ID排序
+func (s *memoryStorage) filterUsers(query UserQuery) []*User {
+	var ids map[uint64]bool
+	if len(query.IDs) > 0 {
+		ids = map[uint64]bool{}
+		for _, id := range query.IDs {
+			ids[id] = true
+		}
+	}
+	users := []*User{}
+	for _, user := range s.users {
+		if ids != nil && !ids[user.ID] {
+			continue
+		}
+		if query.Username != "" && user.Username != query.Username {
+			continue
+		}
+		if query.AfterID != 0 && user.ID <= query.AfterID {
+			continue
+		}
+		if query.BeforeID != 0 && user.ID >= query.BeforeID {
+			continue
+		}
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// UserIdentity ...
+
+func identityKey(provider, openID string) string {
+	return provider + "\x00" + openID
+}
+
+func (s *memoryStorage) FindIdentity(provider, openID string) (*UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identity, ok := s.identities[identityKey(provider, openID)]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *identity
+	return &copied, nil
+}
+
+func (s *memoryStorage) FindIdentityByUser(userID uint64, provider string) (*UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, identity := range s.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (s *memoryStorage) CreateIdentity(identity *UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := identityKey(identity.Provider, identity.OpenID)
+	if _, ok := s.identities[key]; ok {
+		return ErrDuplicateKey
+	}
+	copied := *identity
+	s.identities[key] = &copied
+	return nil
+}
+
+func (s *memoryStorage) UpdateIdentityData(identity *UserIdentity, data json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.identities[identityKey(identity.Provider, identity.OpenID)]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	copied := append(json.RawMessage{}, data...)
+	stored.Data = &copied
+	identity.Data = &data
+	return nil
+}
+
+func (s *memoryStorage) CompareAndUpdateIdentityData(identity *UserIdentity, data json.RawMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.identities[identityKey(identity.Provider, identity.OpenID)]
+	if !ok {
+		return false, ErrRecordNotFound
+	}
+	if (stored.Data == nil) != (identity.Data == nil) ||
+		(stored.Data != nil && !bytes.Equal(*stored.Data, *identity.Data)) {
+		return false, nil
+	}
+	copied := append(json.RawMessage{}, data...)
+	stored.Data = &copied
+	identity.Data = &data
+	return true, nil
+}
+
+func (s *memoryStorage) UpdateIdentityUser(identity *UserIdentity, userID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.identities[identityKey(identity.Provider, identity.OpenID)]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	stored.UserID = userID
+	identity.UserID = userID
+	return nil
+}
+
+func (s *memoryStorage) ListIdentitiesByUser(userID uint64) ([]*UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identities := []*UserIdentity{}
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			copied := *identity
+			identities = append(identities, &copied)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].Provider == identities[j].Provider {
+			return identities[i].OpenID < identities[j].OpenID
+		}
+		return identities[i].Provider < identities[j].Provider
+	})
+	return identities, nil
+}
+
+func (s *memoryStorage) DeleteIdentity(provider, openID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.identities, identityKey(provider, openID))
+	return nil
+}
+
+// Token ...
+
+func (s *memoryStorage) FindToken(id uint64) (*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (s *memoryStorage) CreateToken(token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastTokenID++
+	token.ID = s.lastTokenID
+	copied := *token
+	s.tokens[token.ID] = &copied
+	return nil
+}
+
+func (s *memoryStorage) DeleteTokens(userID uint64, device string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.UserID == userID && token.Device == device {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) RotateToken(id uint64, at time.Time, successor *Token) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.RotatedAt != nil {
+		return false, nil
+	}
+	token.RotatedAt = &at
+	token.Family = successor.Family
+	s.lastTokenID++
+	successor.ID = s.lastTokenID
+	copied := *successor
+	s.tokens[successor.ID] = &copied
+	return true, nil
+}
+
+func (s *memoryStorage) DeleteTokenFamily(family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.Family == family {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) ListTokens(userID uint64) ([]*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := []*Token{}
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].IssuedAt.Equal(tokens[j].IssuedAt) {
+			return tokens[i].ID > tokens[j].ID
+		}
+		return tokens[i].IssuedAt.After(tokens[j].IssuedAt)
+	})
+	return tokens, nil
+}
+
+func (s *memoryStorage) UpdateTokenRemark(id uint64, remark string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token, ok := s.tokens[id]; ok {
+		token.Remark = remark
+	}
+	return nil
+}
+
+func (s *memoryStorage) DeleteToken(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *memoryStorage) DeleteTokensExcept(userID uint64, exceptID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.UserID == userID && id != exceptID {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// UserLog ...
+
+func (s *memoryStorage) CreateLog(log *UserLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastLogID++
+	log.ID = s.lastLogID
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+	copied := *log
+	// 超出则删除最早的
+	if n := len(s.logs) + 1 - s.maxLogs; n > 0 {
+		copy(s.logs, s.logs[n:])
+		for i := len(s.logs) - n; i < len(s.logs); i++ {
+			s.logs[i] = nil
+		}
+		s.logs = s.logs[:len(s.logs)-n]
+	}
+	s.logs = append(s.logs, &copied)
+	return nil
+}
+
+func (s *memoryStorage) ListLogs(query LogQuery) ([]*UserLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	logs := s.filterLogs(query)
+	if query.Offset > len(logs) {
+		query.Offset = len(logs)
+	}
+	logs = logs[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(logs) {
+		logs = logs[:query.Limit]
+	}
+	return logs, nil
+}
+
+func (s *memoryStorage) CountLogs(query LogQuery) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.filterLogs(query)), nil
+}
+
+// filterLogs 按条件过滤，按时间倒序
+func (s *memoryStorage) filterLogs(query LogQuery) []*UserLog {
+	logs := []*UserLog{}
+	for i := len(s.logs) - 1; i >= 0; i-- {
+		log := s.logs[i]
+		if query.UserID != 0 && log.UserID != query.UserID {
+			continue
+		}
+		if query.Action != "" && log.Action != query.Action {
+			continue
+		}
+		if !query.Since.IsZero() && log.CreatedAt.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && !log.CreatedAt.Before(query.Until) {
+			continue
+		}
+		copied := *log
+		logs = append(logs, &copied)
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].CreatedAt.After(logs[j].CreatedAt) })
+	return logs
+}
+
+// Role、Permission ...
+
+func (s *memoryStorage) FindRole(name string) (*Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	role, ok := s.roles[name]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *role
+	return &copied, nil
+}
+
+func (s *memoryStorage) ListRoles() ([]*Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roles := []*Role{}
+	for _, role := range s.roles {
+		copied := *role
+		roles = append(roles, &copied)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (s *memoryStorage) CreateRole(role *Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[role.Name]; ok {
+		return ErrDuplicateKey
+	}
+	role.CreatedAt = time.Now()
+	copied := *role
+	s.roles[role.Name] = &copied
+	return nil
+}
+
+func (s *memoryStorage) DeleteRole(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, roles := range s.userRoles {
+		delete(roles, name)
+	}
+	delete(s.rolePermissions, name)
+	delete(s.roles, name)
+	return nil
+}
+
+func (s *memoryStorage) FindPermission(name string) (*Permission, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	permission, ok := s.permissions[name]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *permission
+	return &copied, nil
+}
+
+func (s *memoryStorage) CreatePermission(permission *Permission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.permissions[permission.Name]; ok {
+		return ErrDuplicateKey
+	}
+	permission.CreatedAt = time.Now()
+	copied := *permission
+	s.permissions[permission.Name] = &copied
+	return nil
+}
+
+func (s *memoryStorage) CreateRolePermission(role, permission string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rolePermissions[role] == nil {
+		s.rolePermissions[role] = map[string]bool{}
+	}
+	s.rolePermissions[role][permission] = true
+	return nil
+}
+
+func (s *memoryStorage) DeleteRolePermission(role, permission string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rolePermissions[role], permission)
+	return nil
+}
+
+func (s *memoryStorage) CreateUserRole(userID uint64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.userRoles[userID] == nil {
+		s.userRoles[userID] = map[string]bool{}
+	}
+	s.userRoles[userID][role] = true
+	return nil
+}
+
+func (s *memoryStorage) DeleteUserRole(userID uint64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.userRoles[userID], role)
+	return nil
+}
+
+func (s *memoryStorage) FindRolesByUser(userID uint64) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roles := []string{}
+	for role := range s.userRoles[userID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (s *memoryStorage) FindPermissionsByUser(userID uint64) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owned := map[string]bool{}
+	for role := range s.userRoles[userID] {
+		for permission := range s.rolePermissions[role] {
+			owned[permission] = true
+		}
+	}
+	permissions := []string{}
+	for permission := range owned {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// OAuthClient、OAuthCode ...
+
+func (s *memoryStorage) FindOAuthClient(id string) (*OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.oauthClients[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+func (s *memoryStorage) ListOAuthClients() ([]*OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := []*OAuthClient{}
+	for _, client := range s.oauthClients {
+		copied := *client
+		clients = append(clients, &copied)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, nil
+}
+
+func (s *memoryStorage) CreateOAuthClient(client *OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.oauthClients[client.ID]; ok {
+		return ErrDuplicateKey
+	}
+	client.CreatedAt = time.Now()
+	copied := *client
+	s.oauthClients[client.ID] = &copied
+	return nil
+}
+
+func (s *memoryStorage) DeleteOAuthClient(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, code := range s.oauthCodes {
+		if code.ClientID == id {
+			delete(s.oauthCodes, hash)
+		}
+	}
+	delete(s.oauthClients, id)
+	return nil
+}
+
+func (s *memoryStorage) CreateOAuthCode(code *OAuthCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.oauthCodes[code.Hash]; ok {
+		return ErrDuplicateKey
+	}
+	copied := *code
+	s.oauthCodes[code.Hash] = &copied
+	return nil
+}
+
+func (s *memoryStorage) TakeOAuthCode(hash string) (*OAuthCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.oauthCodes[hash]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	delete(s.oauthCodes, hash)
+	return code, nil
+}
+
+func (s *memoryStorage) DeleteOAuthCodes(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, code := range s.oauthCodes {
+		if code.ExpiredAt.Before(before) {
+			delete(s.oauthCodes, hash)
+		}
+	}
+	return nil
+}
+
+// APIKey ...
+
+func (s *memoryStorage) FindAPIKey(hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.apiKeys {
+		if key.Hash == hash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (s *memoryStorage) ListAPIKeys(userID uint64) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []*APIKey{}
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID > keys[j].ID
+		}
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *memoryStorage) CreateAPIKey(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.apiKeys {
+		if existing.Hash == key.Hash {
+			return ErrDuplicateKey
+		}
+	}
+	s.lastAPIKeyID++
+	key.ID = s.lastAPIKeyID
+	key.CreatedAt = time.Now()
+	copied := *key
+	s.apiKeys[key.ID] = &copied
+	return nil
+}
+
+func (s *memoryStorage) TouchAPIKey(id uint64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.apiKeys[id]; ok {
+		key.LastUsedAt = &at
+	}
+	return nil
+}
+
+func (s *memoryStorage) DeleteAPIKeys(userID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, key := range s.apiKeys {
+		if key.UserID == userID {
+			delete(s.apiKeys, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) DeleteAPIKey(userID, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrRecordNotFound
+	}
+	delete(s.apiKeys, id)
+	return nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStorageUsers(t *testing.T) {
+	s := NewMemoryStorage()
+	for _, username := range []string{"tom", "jerry", "spike"} {
+		if err := s.CreateUser(&User{Username: username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重复用户名
+	if err := s.CreateUser(&User{Username: "tom"}); err != ErrDuplicateKey {
+		t.Fatalf("重复用户名理应返回ErrDuplicateKey，当前：%v", err)
+	}
+	// 找不到
+	if _, err := s.FindUser(100); err != ErrRecordNotFound {
+		t.Fatalf("理应返回ErrRecordNotFound，当前：%v", err)
+	}
+
+	// 条件
+	users, err := s.ListUsers(UserQuery{AfterID: 1, BeforeID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "jerry" {
+		t.Fatalf("查询结果不对：%+v", users)
+	}
+	count, err := s.CountUsers(UserQuery{IDs: []uint64{1, 3, 100}, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("统计结果不对：%d", count)
+	}
+	users, _ = s.ListUsers(UserQuery{Username: "spike"})
+	if len(users) != 1 || users[0].ID != 3 {
+		t.Fatalf("按用户名查询结果不对：%+v", users)
+	}
+	// 分页
+	users, err = s.ListUsers(UserQuery{Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != 2 {
+		t.Fatalf("分页结果不对：%+v", users)
+	}
+
+	// 返回的是副本
+	user, _ := s.FindUser(1)
+	user.Name = "changed"
+	if user, _ := s.FindUser(1); user.Name == "changed" {
+		t.Fatal("修改返回值不应影响存储")
+	}
+}
+
+func TestMemoryStorageRotateToken(t *testing.T) {
+	s := NewMemoryStorage()
+	// 旧数据没有family
+	legacy := &Token{UserID: 1, Device: "pc"}
+	if err := s.CreateToken(legacy); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now()
+	ok, err := s.RotateToken(legacy.ID, at, &Token{UserID: 1, Device: "pc", Family: "family"})
+	if err != nil || !ok {
+		t.Fatalf("轮换失败：%t, %v", ok, err)
+	}
+	token, _ := s.FindToken(legacy.ID)
+	if token.RotatedAt == nil || token.Family != "family" {
+		t.Fatalf("旧token理应标记为已轮换，并归入新的family：%+v", token)
+	}
+	tokens, _ := s.ListTokens(1)
+	if len(tokens) != 2 {
+		t.Fatalf("理应创建新的token：%d", len(tokens))
+	}
+
+	// 重复轮换，不创建
+	if ok, _ := s.RotateToken(legacy.ID, at, &Token{UserID: 1, Device: "pc", Family: "family"}); ok {
+		t.Fatal("已轮换的理应返回false")
+	}
+	if tokens, _ := s.ListTokens(1); len(tokens) != 2 {
+		t.Fatalf("重复轮换不应创建新的token：%d", len(tokens))
+	}
+}
+
+func TestMemoryStorageMergeUsers(t *testing.T) {
+	s := NewMemoryStorage()
+	target, source := &User{Username: "target"}, &User{Username: "source"}
+	s.CreateUser(target)
+	s.CreateUser(source)
+	s.CreateIdentity(&UserIdentity{UserID: target.ID, Provider: "password", OpenID: "target"})
+	s.CreateIdentity(&UserIdentity{UserID: source.ID, Provider: "password", OpenID: "source"})
+
+	// 同一个provider，不修改
+	if err := s.MergeUsers(target.ID, source.ID, nil); err != ErrMergeConflict {
+		t.Fatalf("理应返回ErrMergeConflict，当前：%v", err)
+	}
+	if identity, _ := s.FindIdentity("password", "source"); identity.UserID != source.ID {
+		t.Fatal("冲突时不应移动UserIdentity")
+	}
+
+	// 删除冲突的，再合并
+	if err := s.MergeUsers(target.ID, source.ID, []*UserIdentity{{Provider: "password", OpenID: "target"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindIdentity("password", "target"); err != ErrRecordNotFound {
+		t.Fatalf("drop理应已删除：%v", err)
+	}
+	if identity, _ := s.FindIdentity("password", "source"); identity.UserID != target.ID {
+		t.Fatal("UserIdentity理应移到target")
+	}
+}
+
+func TestMemoryStorageLogs(t *testing.T) {
+	s := NewMemoryStorage().(*memoryStorage)
+	s.maxLogs = 3
+	for _, action := range []string{"a", "b", "c", "d", "e"} {
+		if err := s.CreateLog(&UserLog{UserID: 1, Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 只保留最近的
+	if count, _ := s.CountLogs(LogQuery{UserID: 1}); count != 3 {
+		t.Fatalf("理应只保留3条：%d", count)
+	}
+	logs, _ := s.ListLogs(LogQuery{UserID: 1})
+	if len(logs) != 3 || logs[0].Action != "e" || logs[2].Action != "c" {
+		t.Fatalf("理应删除最早的：%+v", logs)
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/goodwong/go-x/crypto"
+)
+
+// TokenKey refresh token的加密密钥
+type TokenKey struct {
+	ID  string // 1~32字节，会写入token，须唯一
+	Key []byte // 32 bytes
+}
+
+// tokenKeys refresh token加密密钥环
+// 第一个key用于加密新token，解密时按token里的key ID选择密钥
+//
+// token格式：
+//
+//	|-len(1)-|-keyID(len)-|-NaCL密文-|
+//
+// 没有key ID的旧格式（只有NaCL密文），依次尝试所有key解密
+type tokenKeys struct {
+	current *TokenKey
+	keys    map[string]*TokenKey
+	list    []*TokenKey
+}
+
+// newTokenKeys 创建
+// 没有配置密钥环时，使用secretKey（不写入key ID，与旧token兼容）
+func newTokenKeys(secretKey []byte, keys []TokenKey) *tokenKeys {
+	k := &tokenKeys{keys: map[string]*TokenKey{}}
+	if len(keys) == 0 {
+		keys = []TokenKey{{ID: "", Key: secretKey}}
+	} else {
+		for _, key := range keys {
+			if len(key.ID) == 0 || len(key.ID) > 32 {
+				panic(fmt.Sprintf("TokenKey ID长度须为1~32字节: %q", key.ID))
+			}
+			if len(key.Key) != 32 {
+				panic(fmt.Sprintf("TokenKey(%s) 须为32字节", key.ID))
+			}
+		}
+	}
+	for i := range keys {
+		key := keys[i]
+		if _, ok := k.keys[key.ID]; ok {
+			panic(fmt.Sprintf("TokenKey ID重复: %s", key.ID))
+		}
+		k.keys[key.ID] = &key
+		k.list = append(k.list, &key)
+	}
+	k.current = k.list[0]
+	return k
+}
+
+// derive 派生用于其他用途的密钥环（HMAC-SHA256，key ID不变）
+// 不同用途的密文互不相通，例如challenge不能当作refresh token解密
+func (k *tokenKeys) derive(purpose string) *tokenKeys {
+	derived := &tokenKeys{keys: map[string]*TokenKey{}}
+	for _, key := range k.list {
+		mac := hmac.New(sha256.New, key.Key)
+		mac.Write([]byte("go-x/auth " + purpose))
+		d := &TokenKey{ID: key.ID, Key: mac.Sum(nil)}
+		derived.keys[d.ID] = d
+		derived.list = append(derived.list, d)
+	}
+	derived.current = derived.list[0]
+	return derived
+}
+
+// seal 用当前key加密
+func (k *tokenKeys) seal(plaintext []byte) []byte {
+	encrypted := crypto.NewNaCL(k.current.Key).Encrypt(plaintext)
+	if k.current.ID == "" {
+		return encrypted
+	}
+	data := make([]byte, 0, 1+len(k.current.ID)+len(encrypted))
+	data = append(data, byte(len(k.current.ID)))
+	data = append(data, k.current.ID...)
+	return append(data, encrypted...)
+}
+
+// open 解密
+func (k *tokenKeys) open(data []byte) (plaintext []byte, err error) {
+	// 带key ID的格式
+	if len(data) > 1 {
+		size := int(data[0])
+		if size > 0 && len(data) > 1+size {
+			if key, ok := k.keys[string(data[1:1+size])]; ok {
+				if plaintext, err = crypto.NewNaCL(key.Key).Decrypt(data[1+size:]); err == nil {
+					return plaintext, nil
+				}
+			}
+		}
+	}
+
+	// 旧格式
+	for _, key := range k.list {
+		if plaintext, err = crypto.NewNaCL(key.Key).Decrypt(data); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 附录B的测试数据（SHA1，取后6位）
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := GenerateTOTP(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Fatalf("T=%d 验证码理应是%s，却是%s", unix, expected, code)
+		}
+	}
+
+	// 前后各容忍1个周期，已用过的周期不再接受
+	at := time.Unix(1234567890, 0)
+	code, _ := GenerateTOTP(secret, at.Add(-totpPeriod*time.Second))
+	step, ok := verifyTOTP(secret, code, at, 0)
+	if !ok || step != totpStep(at)-1 {
+		t.Fatal("上一个周期的验证码理应有效")
+	}
+	if _, ok := verifyTOTP(secret, code, at, step); ok {
+		t.Fatal("已用过的验证码不应再次有效")
+	}
+	code, _ = GenerateTOTP(secret, at.Add(-2*totpPeriod*time.Second))
+	if _, ok := verifyTOTP(secret, code, at, 0); ok {
+		t.Fatal("过期的验证码不应有效")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("JBSWY3DPEHPK3PXP", "My Site", "alice@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/My%20Site:alice@example.com?") ||
+		!strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") ||
+		!strings.Contains(uri, "issuer=My+Site") {
+		t.Fatalf("URI不对：%s", uri)
+	}
+}
@@ -1,33 +1,56 @@
 package auth_test
 
 import (
-	"fmt"
+	"sync"
 	"time"
 
 	"github.com/goodwong/go-x/auth"
-	"github.com/jinzhu/gorm"
 )
 
 var (
 	secretKey  = []byte("aasdfkjksjdfaaasdfkjksjdfa123405") // 32 bytes
 	auths      *auth.Auth
 	repository *auth.Repository
-	db         *gorm.DB
+)
+
+// 缩短时间，方便测试
+const (
+	tokenLife       = 50 * time.Millisecond
+	cleanupInterval = 50 * time.Millisecond
 )
 
 func init() {
-	dsn := fmt.Sprintf("host=db port=5432 user=app dbname=app password=app sslmode=disable")
-	var err error
-	db, err = gorm.Open("postgres", dsn)
-	if err != nil {
-		panic(err)
-	}
-	//db.LogMode(true)
-
-	// 缩短时间，方便测试
-	auth.CleanupInterval = 50 * time.Millisecond
-	auth.DefaultTokenLife = 50 * time.Millisecond
-
-	auths = auth.New(auth.Config{DB: db, SecretKey: secretKey})
+	// 使用内存存储，无需数据库
+	auths = auth.New(auth.Config{
+		Storage:         auth.NewMemoryStorage(),
+		SecretKey:       secretKey,
+		TokenLife:       tokenLife,
+		CleanupInterval: cleanupInterval,
+	})
 	repository = auths.Repository
 }
+
+// testClock 可调的时钟，用于Config.Now
+// 后台goroutine（清理注销记录）也会读取，须加锁
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{now: now}
+}
+
+// Now 当前时间
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Add 拨快时钟
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
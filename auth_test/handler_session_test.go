@@ -0,0 +1,111 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+// 模拟已登录的请求
+func sessionRequest(method, url string, body []byte, user *auth.User, tokens *auth.TokenResponse) *http.Request {
+	req := httptest.NewRequest(method, url, bytes.NewBuffer(body))
+	ctx := auth.NewContext(req.Context()).WithUserID(user.ID).WithSessionID(tokens.SessionID)
+	return ctx.AttachRequest(req)
+}
+
+func TestSessions(t *testing.T) {
+	// 单独的实例，不影响其他测试的登录记录
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	loginUser, err := fake.Login([]byte(`{"id": "testsessions"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 两台设备登录
+	credentials := []byte(`{"id": "testsessions"}`)
+	phone, err := instance.Service.Login("fake", credentials, true, "test_phone", "手机")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := instance.Service.Login("fake", credentials, true, "test_laptop", "电脑")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone.SessionID == 0 || laptop.SessionID == 0 {
+		t.Fatal("记住登录时，理应返回SessionID")
+	}
+
+	// 列出
+	w := httptest.NewRecorder()
+	instance.Handler.HandleSessions(w, sessionRequest("GET", "http://localhost/api/sessions", nil, loginUser, laptop))
+	var sessions []*auth.Session
+	if err := json.NewDecoder(w.Result().Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	current := 0
+	for _, session := range sessions {
+		if session.Current {
+			current++
+			if session.ID != laptop.SessionID {
+				t.Fatalf("当前会话不对：%+v", session)
+			}
+		}
+	}
+	if len(sessions) < 2 || current != 1 {
+		t.Fatalf("会话列表不对：%d个，当前%d个", len(sessions), current)
+	}
+
+	// 修改备注
+	w = httptest.NewRecorder()
+	url := fmt.Sprintf("http://localhost/api/sessions?id=%d", phone.SessionID)
+	instance.Handler.HandleSessionRename(w, sessionRequest("PATCH", url, []byte(`{"remark":"旧手机"}`), loginUser, laptop))
+	if code := w.Result().StatusCode; code != http.StatusOK {
+		t.Fatalf("非200响应：%d", code)
+	}
+
+	// 别人的会话
+	other := &auth.User{ID: loginUser.ID + 1000}
+	if err := instance.Service.RevokeSession(other, phone.SessionID); err != auth.ErrSessionNotFound {
+		t.Fatalf("不能注销别人的会话，当前：%v", err)
+	}
+
+	// 注销某个会话
+	w = httptest.NewRecorder()
+	instance.Handler.HandleSessionRevoke(w, sessionRequest("DELETE", url, nil, loginUser, laptop))
+	if code := w.Result().StatusCode; code != http.StatusOK {
+		t.Fatalf("非200响应：%d", code)
+	}
+	if _, err := instance.Repository.FindToken(*phone.RefreshToken); err == nil {
+		t.Fatal("注销后，RefreshToken理应失效")
+	}
+	w = httptest.NewRecorder()
+	instance.Handler.HandleSessionRevoke(w, sessionRequest("DELETE", url, nil, loginUser, laptop))
+	if code := w.Result().StatusCode; code != http.StatusNotFound {
+		t.Fatalf("重复注销理应404，当前：%d", code)
+	}
+
+	// 注销其他所有会话
+	if _, err := instance.Service.Login("fake", credentials, true, "test_phone"); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	instance.Handler.HandleSessionRevoke(w, sessionRequest("DELETE", "http://localhost/api/sessions?others=1", nil, loginUser, laptop))
+	if code := w.Result().StatusCode; code != http.StatusOK {
+		t.Fatalf("非200响应：%d", code)
+	}
+	sessions, err = instance.Service.Sessions(loginUser, laptop.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("理应只剩下当前会话：%+v", sessions)
+	}
+}
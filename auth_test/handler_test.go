@@ -127,6 +127,8 @@ func TestLogout2(t *testing.T) {
 	// 加上jwt令牌
 	url := fmt.Sprintf("http://localhost/api/login?jwt=%s", testHandlerTokens.Token)
 	req := httptest.NewRequest("DELETE", url, nil)
+	// jwt只有tokenLife（50ms），前面的测试慢了（如-race）就过期了，由refresh token续约
+	req.AddCookie(&http.Cookie{Name: auth.DefaultRefreshTokenCookie, Value: *testHandlerTokens.RefreshToken})
 	w := httptest.NewRecorder()
 
 	// 加上middleware
@@ -0,0 +1,91 @@
+package auth_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestCookiePolicy(t *testing.T) {
+	instance := auth.New(auth.Config{
+		SecretKey:      secretKey,
+		CookieDomain:   "example.com",
+		CookieSecure:   true,
+		CookieSameSite: http.SameSiteStrictMode,
+	})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+
+	buffer := bytes.NewBufferString(`{"id": "testcookie"}`)
+	req := httptest.NewRequest("POST", "http://localhost/api/login?provider=fake&remember=1&device=gotest", buffer)
+	w := httptest.NewRecorder()
+	instance.Handler.Mux().ServeHTTP(w, req)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("理应设置jwt、refresh_token两个cookie：%v", cookies)
+	}
+	for _, cookie := range cookies {
+		if !cookie.HttpOnly || !cookie.Secure || cookie.Domain != "example.com" || cookie.SameSite != http.SameSiteStrictMode {
+			t.Fatalf("cookie属性不对：%s", cookie)
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	handler := auths.Middleware.CSRF(auths.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	serve := func(method string, cookies []*http.Cookie, header string) *http.Response {
+		req := httptest.NewRequest(method, "http://localhost/api/posts", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set(auth.CSRFHeader, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// 首次访问，下发csrf cookie
+	resp := serve("GET", nil, "")
+	if resp.StatusCode != http.StatusOK || len(resp.Cookies()) != 1 || resp.Cookies()[0].Name != auth.DefaultCSRFCookie {
+		t.Fatalf("理应下发csrf cookie：%d %v", resp.StatusCode, resp.Cookies())
+	}
+	csrf := resp.Cookies()[0]
+	if csrf.HttpOnly {
+		t.Fatal("csrf cookie需要前端读取，不能是HttpOnly")
+	}
+	session := &http.Cookie{Name: auth.DefaultJWTCookie, Value: "whatever"}
+
+	// 没有登录cookie，不校验
+	if resp := serve("POST", []*http.Cookie{csrf}, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("没有登录cookie，不应拦截：%d", resp.StatusCode)
+	}
+
+	// 带登录cookie，缺少或错误的csrf token
+	if resp := serve("POST", []*http.Cookie{csrf, session}, ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("缺少csrf token，理应403：%d", resp.StatusCode)
+	}
+	if resp := serve("DELETE", []*http.Cookie{csrf, session}, "forged"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("错误的csrf token，理应403：%d", resp.StatusCode)
+	}
+	if resp := serve("POST", []*http.Cookie{session}, csrf.Value); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("没有csrf cookie，理应403：%d", resp.StatusCode)
+	}
+
+	// 正确的csrf token
+	if resp := serve("POST", []*http.Cookie{csrf, session}, csrf.Value); resp.StatusCode != http.StatusOK {
+		t.Fatalf("csrf token正确，不应拦截：%d", resp.StatusCode)
+	}
+
+	// 安全请求不校验
+	if resp := serve("GET", []*http.Cookie{csrf, session}, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET请求不应拦截：%d", resp.StatusCode)
+	}
+}
@@ -1,6 +1,54 @@
 package auth_test
 
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
 // 在handler_test.go文件的TestLogout2方法中，
 // 已经测试过这两个方法了，无需重复测试：
 // - Authenticated
 // - AuthenticatedWithUser
+
+// 测试角色、权限拦截
+func TestAuthorizedMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(handler http.Handler, ctx *auth.ContextRepository) int {
+		req := httptest.NewRequest("GET", "http://localhost/api/admin", nil)
+		if ctx != nil {
+			req = ctx.AttachRequest(req)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+	req := httptest.NewRequest("GET", "http://localhost/api/admin", nil)
+
+	// 未登录
+	if code := serve(auths.Middleware.Authorized("admin")(ok), nil); code != http.StatusUnauthorized {
+		t.Errorf("未登录理应401，当前响应：%d", code)
+	}
+
+	// 角色
+	ctx := auth.NewContext(req.Context()).WithUserID(1).WithRoles("editor")
+	if code := serve(auths.Middleware.Authorized("admin")(ok), ctx); code != http.StatusForbidden {
+		t.Errorf("没有角色理应403，当前响应：%d", code)
+	}
+	if code := serve(auths.Middleware.Authorized("admin", "editor")(ok), ctx); code != http.StatusOK {
+		t.Errorf("拥有角色理应200，当前响应：%d", code)
+	}
+
+	// 权限
+	ctx = auth.NewContext(req.Context()).WithUserID(1).WithPermissions("post.edit")
+	if code := serve(auths.Middleware.Can("post.edit", "post.delete")(ok), ctx); code != http.StatusForbidden {
+		t.Errorf("没有全部权限理应403，当前响应：%d", code)
+	}
+	if code := serve(auths.Middleware.Can("post.edit")(ok), ctx); code != http.StatusOK {
+		t.Errorf("拥有权限理应200，当前响应：%d", code)
+	}
+}
@@ -6,23 +6,27 @@ import (
 	"github.com/goodwong/go-x/auth"
 )
 
-var (
-	identity *auth.UserIdentity
-)
-
-func init() {
-	// 准备
-	db.Delete(&auth.UserIdentity{}, "provider IN (?)", []string{"test"})
-}
+func TestIdentity(t *testing.T) {
+	// 准备，每次运行都用新的存储
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	repository := instance.Repository
+	user, err := repository.Create("test", "测试号")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := repository.Create("test_other", "另一个号")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestIdentityCreate(t *testing.T) {
 	// 创建
 	userID, provider, openID := user.ID, "test", "test_user"
 	data := map[string]string{
 		"password": "123456",
 		"爱好":       "打球",
 	}
-	identity, err = repository.CreateIdentity(userID, provider, openID, data)
+	identity, err := repository.CreateIdentity(userID, provider, openID, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,23 +36,16 @@ func TestIdentityCreate(t *testing.T) {
 	if err == nil {
 		t.Fatal(err)
 	}
-}
 
-func TestIdentityUpdate(t *testing.T) {
+	// 更新
 	repository.UpdateIdentityData(identity, nil)
-	repository.UpdateIdentityUser(identity, &auth.User{ID: 1})
-}
+	repository.UpdateIdentityUser(identity, other)
 
-func TestIdentityFind(t *testing.T) {
-	provider, openID := "test", "test_user"
-	identity, err = repository.FindIdentity(provider, openID)
-	if err != nil {
+	// 查找
+	if _, err := repository.FindIdentity(provider, openID); err != nil {
 		t.Fatal(err)
 	}
-
-	userID := uint64(1)
-	identity, err = repository.FindIdentityByUser(userID, provider)
-	if err != nil {
+	if _, err := repository.FindIdentityByUser(other.ID, provider); err != nil {
 		t.Fatal(err)
 	}
 }
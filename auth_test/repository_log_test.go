@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestLogList(t *testing.T) {
+	// 准备，每次运行都用新的存储
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	repository := instance.Repository
+
+	// 创建
+	for _, action := range []string{"test_a", "test_b", "test_a"} {
+		err := repository.CreateLog(&auth.UserLog{UserID: 99, Action: action, IP: "127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 按用户、行为
+	logs, total, err := repository.ListLogs(auth.LogQuery{UserID: 99, Action: "test_a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(logs) != 2 {
+		t.Fatalf("理应有2条记录，当前：%d, %d", total, len(logs))
+	}
+
+	// 分页
+	logs, total, err = repository.ListLogs(auth.LogQuery{UserID: 99, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(logs) != 1 || logs[0].Action != "test_b" {
+		t.Fatalf("分页结果不对：%d, %+v", total, logs)
+	}
+
+	// 时间范围
+	_, total, err = repository.ListLogs(auth.LogQuery{UserID: 99, Until: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Fatalf("一小时前理应没有记录，当前：%d", total)
+	}
+
+	// 管理后台
+	req := httptest.NewRequest("GET", "http://localhost/api/admin/user-logs?user_id=99&limit=2", nil)
+	w := httptest.NewRecorder()
+	instance.Handler.HandleLogs(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("非200响应：%d", resp.StatusCode)
+	}
+	var result struct {
+		Total int             `json:"total"`
+		Logs  []*auth.UserLog `json:"logs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 3 || len(result.Logs) != 2 {
+		t.Fatalf("非期望的响应：%+v", result)
+	}
+
+	// 无效参数
+	req = httptest.NewRequest("GET", "http://localhost/api/admin/user-logs?since=yesterday", nil)
+	w = httptest.NewRecorder()
+	instance.Handler.HandleLogs(w, req)
+	if code := w.Result().StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("无效参数理应400，当前：%d", code)
+	}
+}
+
+// handler_test.go 里通过HandleLogin登录过，理应有带IP、UA的记录
+func TestLogLogin(t *testing.T) {
+	logs, _, err := repository.ListLogs(auth.LogQuery{Action: auth.ActionLogin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) == 0 {
+		t.Fatal("登录后理应有记录")
+	}
+	if logs[len(logs)-1].IP != "192.0.2.1" { // httptest.NewRequest 的 RemoteAddr
+		t.Fatalf("IP不对：%+v", logs[len(logs)-1])
+	}
+}
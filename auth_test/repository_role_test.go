@@ -0,0 +1,108 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestRole(t *testing.T) {
+	// 准备，每次运行都用新的存储
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	repository := instance.Repository
+	user, err := repository.Create("test", "测试号")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 创建
+	if _, err := repository.CreateRole("test_admin", "管理员"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.CreateRole("test_editor"); err != nil {
+		t.Fatal(err)
+	}
+	// 重复创建
+	if _, err := repository.CreateRole("test_admin"); err == nil {
+		t.Fatal("重复角色名理应报错，却没有报")
+	}
+
+	if _, err := repository.CreatePermission("test_post_edit", "编辑文章"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.CreatePermission("test_post_delete"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 权限
+	if err := repository.GrantPermission("test_admin", "test_post_edit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.GrantPermission("test_admin", "test_post_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.GrantPermission("test_editor", "test_post_edit"); err != nil {
+		t.Fatal(err)
+	}
+	// 重复授予
+	if err := repository.GrantPermission("test_editor", "test_post_edit"); err != nil {
+		t.Fatal(err)
+	}
+	// 不存在的权限
+	if err := repository.GrantPermission("test_editor", "test_not_exists"); err == nil {
+		t.Fatal("不存在的权限理应报错，却没有报")
+	}
+
+	// 角色
+	if err := repository.GrantRole(user.ID, "test_editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.GrantRole(user.ID, "test_not_exists"); err == nil {
+		t.Fatal("不存在的角色理应报错，却没有报")
+	}
+	roles, err := repository.FindRolesByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != "test_editor" {
+		t.Fatalf("角色不对：%v", roles)
+	}
+	permissions, err := repository.FindPermissionsByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || permissions[0] != "test_post_edit" {
+		t.Fatalf("权限不对：%v", permissions)
+	}
+
+	// 撤销
+	if err := repository.GrantRole(user.ID, "test_admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.RevokeRole(user.ID, "test_editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.RevokePermission("test_admin", "test_post_delete"); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err = repository.FindPermissionsByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || permissions[0] != "test_post_edit" {
+		t.Fatalf("权限不对：%v", permissions)
+	}
+
+	// 删除角色
+	if err := repository.DeleteRole("test_admin"); err != nil {
+		t.Fatal(err)
+	}
+	roles, err = repository.FindRolesByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 0 {
+		t.Fatalf("删除角色后，理应没有角色：%v", roles)
+	}
+}
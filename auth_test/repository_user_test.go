@@ -6,21 +6,14 @@ import (
 	"github.com/goodwong/go-x/auth"
 )
 
-var (
-	user *auth.User
-	err  error
-)
-
-func init() {
-	// 准备
-	db.Delete(&auth.User{}, "username IN (?)", []string{"test_user", "new_username", "test"})
-	user, err = repository.Create("test", "测试号")
-}
-
-func TestUserCreate(t *testing.T) {
+func TestUser(t *testing.T) {
+	// 准备，每次运行都用新的存储
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	repository := instance.Repository
 
 	// 创建
-	user, err = repository.Create("test_user", "老小王")
+	user, err := repository.Create("test_user", "老小王")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,9 +29,7 @@ func TestUserCreate(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestUserUpdate(t *testing.T) {
 	// 更新
 	err = repository.Update(user, auth.User{Username: "bad_username", Name: "小威廉"})
 	if err != nil {
@@ -64,9 +55,7 @@ func TestUserUpdate(t *testing.T) {
 	//!if user.Username == "test_user" {
 	//!	t.Error("错误更新重复的Username")
 	//!}
-}
 
-func TestUserFind(t *testing.T) {
 	// 按ID
 	user, err = repository.Find(user.ID)
 	if err != nil {
@@ -80,22 +69,15 @@ func TestUserFind(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Logf("FindByUsername: % v\n", user)
-}
 
-func TestUserList(t *testing.T) {
-	offset := 0
-	limit := 10
-	users, err := repository.List(offset, limit, "id < 10")
-	if err != nil {
-		t.Fatal(err)
-	}
-	users, err = repository.List(offset, limit, "id < ?", 10)
+	// 列表
+	users, err := repository.List(auth.UserQuery{BeforeID: 10, Offset: 0, Limit: 10})
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Logf("List() %d 人:\n", len(users))
 
-	count, err := repository.Count("id < ?", 10)
+	count, err := repository.Count(auth.UserQuery{BeforeID: 10})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,223 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestAPIKey(t *testing.T) {
+	clock := newTestClock(time.Now())
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now:       clock.Now,
+	})
+	defer instance.Close()
+	user, err := instance.Repository.Create("testapikey", "API key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 创建
+	key, secret, err := instance.Service.CreateAPIKey(user, "CI", "repo:read repo:read deploy", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, key.Prefix) || key.Scopes != "repo:read deploy" || key.ExpiredAt == nil {
+		t.Fatalf("API key不对：%+v", key)
+	}
+	if _, _, err := instance.Service.CreateAPIKey(user, "", "", 0); err == nil {
+		t.Fatal("名称不能为空")
+	}
+
+	// 访问
+	var ctx *auth.ContextRepository
+	handler := instance.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = auth.NewContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(handler http.Handler, secret string) int {
+		ctx = nil
+		req := httptest.NewRequest("GET", "http://localhost/api/repos", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+	serve(handler, secret)
+	if ctx.UserID() != user.ID || ctx.APIKeyID() != key.ID {
+		t.Fatalf("理应带上用户、API key：%d, %d", ctx.UserID(), ctx.APIKeyID())
+	}
+	if !ctx.HasScope("repo:read") || ctx.HasScope("repo:write") {
+		t.Fatalf("scope不对：%v", ctx.Scopes())
+	}
+	keys, err := instance.Service.APIKeys(user)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("理应记录使用时间：%+v, %v", keys, err)
+	}
+
+	// Scoped
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if code := serve(instance.Middleware.ParseToken(instance.Middleware.Scoped("deploy")(ok)), secret); code != http.StatusOK {
+		t.Fatalf("授权了的scope理应通过：%d", code)
+	}
+	if code := serve(instance.Middleware.ParseToken(instance.Middleware.Scoped("repo:write")(ok)), secret); code != http.StatusForbidden {
+		t.Fatalf("未授权的scope理应403：%d", code)
+	}
+
+	// 不能用API key管理API key
+	if code := serve(instance.Handler.APIKeysMux(), secret); code != http.StatusForbidden {
+		t.Fatalf("API key不能管理API key：%d", code)
+	}
+
+	// 无效的key
+	serve(handler, secret+"x")
+	if ctx.UserID() != 0 {
+		t.Fatal("无效的key不应登录")
+	}
+
+	// 过期
+	clock.Add(24 * time.Hour)
+	serve(handler, secret)
+	if ctx.UserID() != 0 {
+		t.Fatal("过期的key不应登录")
+	}
+
+	// 吊销
+	_, secret, err = instance.Service.CreateAPIKey(user, "永久", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(handler, secret)
+	if ctx.UserID() != user.ID || ctx.Scopes() == nil || len(ctx.Scopes()) != 0 {
+		t.Fatalf("没有scope的key只能访问不限scope的接口：%v", ctx.Scopes())
+	}
+	keys, _ = instance.Service.APIKeys(user)
+	if err := instance.Service.RevokeAPIKey(&auth.User{ID: user.ID + 1}, keys[0].ID); err != auth.ErrAPIKeyNotFound {
+		t.Fatalf("不能吊销别人的key：%v", err)
+	}
+	if err := instance.Service.RevokeAPIKey(user, keys[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	serve(handler, secret)
+	if ctx.UserID() != 0 {
+		t.Fatal("吊销的key不应登录")
+	}
+}
+
+func TestAPIKeyOnlyScoped(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testapikeyscoped"}`), true, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := instance.Repository.FindByOpenID("fake", "testapikeyscoped")
+	_, secret, err := instance.Service.CreateAPIKey(user, "CI", "deploy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(handler http.Handler, method, url string) int {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 只加了Authenticated的路由（包括auth自己的各个Mux）不接受API key
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for url, handler := range map[string]http.Handler{
+		"/api/me":         instance.Middleware.ParseToken(instance.Middleware.AuthenticatedWithUser(ok)),
+		"/api/2fa":        instance.Handler.TwoFactorMux(),
+		"/api/sessions":   instance.Handler.SessionsMux(),
+		"/api/identities": instance.Handler.IdentitiesMux(),
+	} {
+		if code := serve(handler, "GET", "http://localhost"+url); code != http.StatusForbidden {
+			t.Fatalf("%s不应接受API key：%d", url, code)
+		}
+	}
+	if code := serve(instance.Handler.SessionsMux(), "DELETE", "http://localhost/api/sessions?others=1"); code != http.StatusForbidden {
+		t.Fatalf("API key不能注销会话：%d", code)
+	}
+	if code := serve(instance.Handler.Mux(), "DELETE", "http://localhost/api/login"); code != http.StatusForbidden {
+		t.Fatalf("API key不能登出：%d", code)
+	}
+	if _, err := instance.Repository.FindToken(*tokens.RefreshToken); err != nil {
+		t.Fatal("会话理应还在", err)
+	}
+
+	// Scoped在前的，可以
+	scoped := instance.Middleware.ParseToken(instance.Middleware.Scoped("deploy")(instance.Middleware.AuthenticatedWithUser(ok)))
+	if code := serve(scoped, "POST", "http://localhost/api/deploy"); code != http.StatusOK {
+		t.Fatalf("Scoped的路由理应接受API key：%d", code)
+	}
+
+	// 注销全部会话，API key一并吊销
+	if err := instance.Service.RevokeAllSessions(user, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance.Repository.FindAPIKey(secret); err != auth.ErrInvalidToken {
+		t.Fatalf("注销全部会话后API key理应无效：%v", err)
+	}
+}
+
+func TestAPIKeysMux(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	user, err := instance.Repository.Create("testapikeymux", "API key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, url string, body []byte) *http.Response {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer(body))
+		req = auth.NewContext(req.Context()).WithUserID(user.ID).AttachRequest(req)
+		w := httptest.NewRecorder()
+		switch method {
+		case "GET":
+			instance.Handler.HandleAPIKeys(w, req)
+		case "POST":
+			instance.Handler.HandleAPIKeyCreate(w, req)
+		case "DELETE":
+			instance.Handler.HandleAPIKeyRevoke(w, req)
+		}
+		return w.Result()
+	}
+
+	// 创建
+	res := serve("POST", "http://localhost/api/keys", []byte(`{"name":"CI","scope":"deploy","expires_in":3600}`))
+	var created struct {
+		Key    *auth.APIKey `json:"key"`
+		Secret string       `json:"secret"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil || created.Secret == "" {
+		t.Fatalf("理应返回secret：%+v, %v", created, err)
+	}
+
+	// 列出，不返回hash
+	res = serve("GET", "http://localhost/api/keys", nil)
+	var body bytes.Buffer
+	body.ReadFrom(res.Body)
+	if !strings.Contains(body.String(), `"name":"CI"`) || strings.Contains(body.String(), "hash") {
+		t.Fatalf("列表不对：%s", body.String())
+	}
+
+	// 吊销
+	if res := serve("DELETE", "http://localhost/api/keys?id=999", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("不存在的key理应404：%d", res.StatusCode)
+	}
+	if res := serve("DELETE", "http://localhost/api/keys?id="+strconv.FormatUint(created.Key.ID, 10), nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("理应吊销成功：%d", res.StatusCode)
+	}
+	if _, err := instance.Repository.FindAPIKey(created.Secret); err != auth.ErrInvalidToken {
+		t.Fatalf("吊销后理应无效：%v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestJWTClaims(t *testing.T) {
+	var enrichErr error
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Issuer:    "https://auth.example.com",
+		Audience:  "api",
+		EnrichClaims: func(user *auth.User) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"username": user.Username,
+				"tenant":   "acme",
+				"roles":    []string{"editor"},
+				"sub":      "hijack", // 保留的，理应忽略
+			}, enrichErr
+		},
+	})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testclaims"}`), true, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ctx *auth.ContextRepository
+	handler := instance.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = auth.NewContext(r.Context())
+	}))
+	serve := func(jwt string, refreshToken string) {
+		ctx = nil
+		req := httptest.NewRequest("GET", "http://localhost/api/me", nil)
+		if jwt != "" {
+			req.Header.Set("Authorization", "Bearer "+jwt)
+		}
+		if refreshToken != "" {
+			req.AddCookie(&http.Cookie{Name: auth.DefaultRefreshTokenCookie, Value: refreshToken})
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// 自定义claims
+	serve(tokens.Token, "")
+	claims := ctx.Claims()
+	if claims == nil || ctx.UserID() == 0 {
+		t.Fatal("理应带上claims")
+	}
+	if claims["username"] != "testclaims@fake" || claims["tenant"] != "acme" || len(claims["roles"].([]interface{})) != 1 {
+		t.Fatalf("自定义claims不对：%v", claims)
+	}
+	if claims["sub"] != float64(ctx.UserID()) {
+		t.Fatalf("保留的claims不能覆盖：%v", claims["sub"])
+	}
+	if claims["iss"] != "https://auth.example.com" || claims["aud"] != "api" {
+		t.Fatalf("理应带上iss、aud：%v", claims)
+	}
+
+	// 续约的也有
+	serve("", *tokens.RefreshToken)
+	if claims := ctx.Claims(); claims == nil || claims["tenant"] != "acme" {
+		t.Fatalf("续约后理应带上claims：%v", claims)
+	}
+
+	// iss、aud不匹配
+	for _, config := range []auth.Config{
+		{SecretKey: secretKey, Issuer: "https://other.example.com", Audience: "api"},
+		{SecretKey: secretKey, Issuer: "https://auth.example.com", Audience: "admin"},
+	} {
+		other := auth.New(config)
+		defer other.Close()
+		handler := other.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx = auth.NewContext(r.Context())
+		}))
+		req := httptest.NewRequest("GET", "http://localhost/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if ctx.UserID() != 0 || ctx.Claims() != nil {
+			t.Fatalf("iss、aud不匹配不应登录：%+v", config)
+		}
+	}
+
+	// 出错则登录失败
+	enrichErr = errors.New("查询租户失败")
+	if _, err := instance.Service.Login("fake", []byte(`{"id": "testclaims"}`), false, "phone"); err != enrichErr {
+		t.Fatalf("EnrichClaims出错理应登录失败：%v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+)
+
+// fakeProvider 模拟第三方登录，凭证为{"id": "xxx"}
+type fakeProvider struct {
+	auth *auth.Auth
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) Identify(payload []byte) (openID string, data interface{}, err error) {
+	credentials := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(payload, &credentials); err != nil || credentials.ID == "" {
+		return "", nil, errors.New("无效的凭证")
+	}
+	return credentials.ID, nil, nil
+}
+
+func (p *fakeProvider) Login(payload []byte) (user *auth.User, err error) {
+	openID, _, err := p.Identify(payload)
+	if err != nil {
+		return nil, err
+	}
+	user, err = p.auth.Repository.FindByOpenID(p.Name(), openID)
+	if err != auth.ErrRecordNotFound {
+		return user, err
+	}
+	if user, err = p.auth.Repository.Create(openID+"@fake", ""); err != nil {
+		return nil, err
+	}
+	_, err = p.auth.Repository.CreateIdentity(user.ID, p.Name(), openID)
+	return user, err
+}
+
+func TestLinkUnlink(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	passwords := password.NewProvider(&password.Config{Auth: instance})
+	instance.RegisterProvider(passwords)
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+
+	// 通过第三方登录的用户
+	user, err := fake.Login([]byte(`{"id": "alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fake.Login([]byte(`{"id": "bob"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 唯一的登录方式不能解绑
+	if err := instance.Service.Unlink(user, "fake"); err != auth.ErrLastIdentity {
+		t.Fatalf("理应不能解绑唯一的登录方式：%v", err)
+	}
+	if err := instance.Service.Unlink(user, "password"); err != auth.ErrIdentityNotFound {
+		t.Fatalf("理应未绑定：%v", err)
+	}
+
+	// 绑定密码
+	if err := instance.Service.Link(user, "password", []byte(`{"username":"alice", "password":"weak"}`)); err == nil {
+		t.Fatal("弱密码不应绑定成功")
+	}
+	if err := instance.Service.Link(user, "password", []byte(`{"username":"alice", "password":"testpassWord123,"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Service.Link(user, "password", []byte(`{"username":"alice2", "password":"testpassWord123,"}`)); err != auth.ErrIdentityLinked {
+		t.Fatalf("同一种登录方式只能绑定一个：%v", err)
+	}
+	credentials := []byte(`{"username":"alice", "password":"testpassWord123,"}`)
+	if _, err := instance.Service.Login("password", credentials, false, "gotest"); err != nil {
+		t.Fatal("绑定后理应可以用密码登录", err)
+	}
+
+	// 已绑定别人的不能抢
+	if err := instance.Service.Link(other, "password", []byte(`{"username":"alice", "password":"testpassWord123,"}`)); err != auth.ErrIdentityTaken {
+		t.Fatalf("已绑定其他用户的不应绑定成功：%v", err)
+	}
+
+	// 解绑第三方，剩下密码
+	if err := instance.Service.Unlink(user, "fake"); err != nil {
+		t.Fatal(err)
+	}
+	identities, _ := instance.Service.Identities(user)
+	if len(identities) != 1 || identities[0].Provider != "password" {
+		t.Fatalf("理应只剩密码：%+v", identities)
+	}
+	if err := instance.Service.Unlink(user, "password"); err != auth.ErrLastIdentity {
+		t.Fatalf("理应不能解绑唯一的登录方式：%v", err)
+	}
+}
+
+func TestHandleIdentities(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "carol"}`), false, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance.Service.Login("fake", []byte(`{"id": "dave"}`), false, "gotest"); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, url, body string) *http.Response {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "BEARER "+tokens.Token)
+		w := httptest.NewRecorder()
+		instance.Handler.IdentitiesMux().ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	if resp := serve("POST", "http://localhost/api/me/identities?provider=fake", `{"id": "dave"}`); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("已绑定过同一种登录方式，理应409：%d", resp.StatusCode)
+	}
+	if resp := serve("DELETE", "http://localhost/api/me/identities?provider=fake", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("唯一的登录方式，理应400：%d", resp.StatusCode)
+	}
+	resp := serve("GET", "http://localhost/api/me/identities", "")
+	var identities []*auth.Identity
+	if err := json.NewDecoder(resp.Body).Decode(&identities); err != nil || len(identities) != 1 || identities[0].OpenID != "carol" {
+		t.Fatalf("理应列出已绑定的登录方式：%+v, %v", identities, err)
+	}
+}
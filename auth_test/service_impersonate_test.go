@@ -0,0 +1,228 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestImpersonate(t *testing.T) {
+	clock := newTestClock(time.Now())
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now:       clock.Now,
+	})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+	adminTokens, err := instance.Service.Login("fake", []byte(`{"id": "testimpersonate-admin"}`), true, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _ := instance.Repository.FindByOpenID("fake", "testimpersonate-admin")
+	targetTokens, err := instance.Service.Login("fake", []byte(`{"id": "testimpersonate-target"}`), true, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := instance.Repository.FindByOpenID("fake", "testimpersonate-target")
+
+	// 颁发
+	if _, err := instance.Service.Impersonate(admin, admin); err != auth.ErrImpersonateSelf {
+		t.Fatalf("不能模拟自己：%v", err)
+	}
+	tokens, err := instance.Service.Impersonate(admin, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.RefreshToken != nil || !tokens.TokenExpires.Equal(clock.Now().Add(auth.DefaultImpersonationLife)) {
+		t.Fatalf("理应只有短期的jwt：%+v", tokens)
+	}
+
+	// 访问
+	var ctx *auth.ContextRepository
+	handler := instance.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = auth.NewContext(r.Context())
+	}))
+	serve := func(handler http.Handler, jwt, refreshToken string) *httptest.ResponseRecorder {
+		ctx = nil
+		req := httptest.NewRequest("GET", "http://localhost/api/orders", nil)
+		req.AddCookie(&http.Cookie{Name: auth.DefaultJWTCookie, Value: jwt})
+		if refreshToken != "" {
+			req.AddCookie(&http.Cookie{Name: auth.DefaultRefreshTokenCookie, Value: refreshToken})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	serve(handler, tokens.Token, "")
+	if ctx.UserID() != target.ID || ctx.ActorID() != admin.ID || ctx.RealUserID() != admin.ID {
+		t.Fatalf("理应是被模拟的用户，并带上管理员：%d, %d", ctx.UserID(), ctx.ActorID())
+	}
+	logs, total, err := instance.Repository.ListLogs(auth.LogQuery{UserID: target.ID, Action: auth.ActionImpersonatedRequest})
+	if err != nil || total != 1 || logs[0].Remark != fmt.Sprintf("admin: %d, GET /api/orders", admin.ID) {
+		t.Fatalf("理应记录模拟的请求：%d, %v", total, err)
+	}
+	if _, total, _ := instance.Repository.ListLogs(auth.LogQuery{UserID: admin.ID, Action: auth.ActionImpersonationStarted}); total != 1 {
+		t.Fatal("理应记录开始模拟")
+	}
+
+	// 不能再模拟，不能管理API key、会话、两步验证、登录方式
+	impersonate := instance.Middleware.ParseToken(http.HandlerFunc(instance.Handler.HandleImpersonate))
+	req := httptest.NewRequest("POST", fmt.Sprintf("http://localhost/api/admin/impersonate?user_id=%d", admin.ID), nil)
+	req.AddCookie(&http.Cookie{Name: auth.DefaultJWTCookie, Value: tokens.Token})
+	w := httptest.NewRecorder()
+	impersonate.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("模拟登录中不能再模拟：%d", w.Code)
+	}
+	for name, mux := range map[string]http.Handler{
+		"API key": instance.Handler.APIKeysMux(),
+		"会话":      instance.Handler.SessionsMux(),
+		"两步验证":    instance.Handler.TwoFactorMux(),
+		"登录方式":    instance.Handler.IdentitiesMux(),
+	} {
+		if w := serve(mux, tokens.Token, ""); w.Code != http.StatusForbidden {
+			t.Fatalf("模拟登录不能管理%s：%d", name, w.Code)
+		}
+	}
+
+	// API key不能发起模拟
+	_, secret, err := instance.Service.CreateAPIKey(admin, "CI", "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := instance.Middleware.ParseToken(instance.Middleware.Scoped("admin")(http.HandlerFunc(instance.Handler.HandleImpersonate)))
+	req = httptest.NewRequest("POST", fmt.Sprintf("http://localhost/api/admin/impersonate?user_id=%d", target.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	w = httptest.NewRecorder()
+	scoped.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("API key不能发起模拟：%d", w.Code)
+	}
+
+	// 不能给OAuth client授权（access token没有act，会逃过时长限制和审计）
+	client := &auth.OAuthClient{
+		Name:         "第三方",
+		RedirectURIs: "https://tool.example.com/callback",
+		Scopes:       "profile",
+		Trusted:      true,
+	}
+	if _, err := instance.Repository.CreateOAuthClient(client); err != nil {
+		t.Fatal(err)
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"scope":                 {"profile"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	req = httptest.NewRequest("GET", "http://localhost/oauth/authorize?"+params.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: auth.DefaultJWTCookie, Value: tokens.Token})
+	w = httptest.NewRecorder()
+	instance.Handler.OAuthAuthorize().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("模拟登录不能给OAuth client授权：%d", w.Code)
+	}
+
+	// 结束，续约回管理员
+	end := instance.Middleware.ParseToken(http.HandlerFunc(instance.Handler.HandleImpersonationEnd))
+	if w := serve(end, adminTokens.Token, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("不是模拟登录理应400：%d", w.Code)
+	}
+	w = serve(end, tokens.Token, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Set-Cookie"), auth.DefaultJWTCookie+"=;") {
+		t.Fatalf("理应结束并删除jwt cookie：%d, %s", w.Code, w.Header().Get("Set-Cookie"))
+	}
+	clock.Add(time.Second)
+	serve(handler, tokens.Token, "")
+	if ctx.UserID() != 0 {
+		t.Fatal("结束后模拟的jwt理应失效")
+	}
+	serve(handler, tokens.Token, *adminTokens.RefreshToken)
+	if ctx.UserID() != admin.ID || ctx.ActorID() != 0 {
+		t.Fatalf("理应续约回管理员：%d, %d", ctx.UserID(), ctx.ActorID())
+	}
+
+	// handler颁发；过期
+	req = httptest.NewRequest("POST", fmt.Sprintf("http://localhost/api/admin/impersonate?user_id=%d", target.ID), nil)
+	req = auth.NewContext(req.Context()).WithUserID(admin.ID).AttachRequest(req)
+	w = httptest.NewRecorder()
+	instance.Handler.HandleImpersonate(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Set-Cookie"), auth.DefaultJWTCookie+"=") {
+		t.Fatalf("理应设置jwt cookie：%d", w.Code)
+	}
+	token := strings.TrimPrefix(strings.Split(w.Header().Get("Set-Cookie"), ";")[0], auth.DefaultJWTCookie+"=")
+	serve(handler, token, "")
+	if ctx.ActorID() != admin.ID {
+		t.Fatal("理应是模拟登录")
+	}
+	clock.Add(auth.DefaultImpersonationLife + time.Second)
+	serve(handler, token, "")
+	if ctx.UserID() != 0 {
+		t.Fatal("过期后理应失效")
+	}
+
+	// 模拟登录中登出，只结束模拟，被模拟的用户不受影响
+	if tokens, err = instance.Service.Impersonate(admin, target); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("DELETE", "http://localhost/api/login", nil)
+	req.AddCookie(&http.Cookie{Name: auth.DefaultJWTCookie, Value: tokens.Token})
+	w = httptest.NewRecorder()
+	instance.Handler.Mux().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Header().Get("Set-Cookie"), auth.DefaultRefreshTokenCookie) {
+		t.Fatalf("理应只结束模拟：%d, %s", w.Code, w.Header().Get("Set-Cookie"))
+	}
+	serve(handler, tokens.Token, "")
+	if ctx.UserID() != 0 {
+		t.Fatal("登出后模拟的jwt理应失效")
+	}
+	serve(handler, targetTokens.Token, "")
+	if ctx.UserID() != target.ID {
+		t.Fatal("被模拟用户的jwt理应仍然有效")
+	}
+	if _, err := instance.Repository.FindToken(*targetTokens.RefreshToken); err != nil {
+		t.Fatal("被模拟用户的会话理应还在", err)
+	}
+}
+
+func TestImpersonationRevocationCleanup(t *testing.T) {
+	clock := newTestClock(time.Now())
+	instance := auth.New(auth.Config{
+		SecretKey:         secretKey,
+		Now:               clock.Now,
+		TokenLife:         time.Minute,
+		ImpersonationLife: time.Hour,
+		CleanupInterval:   cleanupInterval,
+	})
+	defer instance.Close()
+	admin, _ := instance.Repository.Create("testimpersonatecleanup-admin", "管理员")
+	target, _ := instance.Repository.Create("testimpersonatecleanup", "被模拟")
+	tokens, err := instance.Service.Impersonate(admin, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Service.EndImpersonation(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// 过了TokenLife，注销记录仍须保留到模拟的jwt过期
+	var ctx *auth.ContextRepository
+	handler := instance.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = auth.NewContext(r.Context())
+	}))
+	clock.Add(2 * time.Minute)
+	time.Sleep(3 * cleanupInterval)
+	req := httptest.NewRequest("GET", "http://localhost/api/orders", nil)
+	req.AddCookie(&http.Cookie{Name: auth.DefaultJWTCookie, Value: tokens.Token})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if ctx.UserID() != 0 {
+		t.Fatal("结束后模拟的jwt不应因清理注销记录而恢复")
+	}
+}
@@ -0,0 +1,153 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+)
+
+func TestMergeUsers(t *testing.T) {
+	var hookErr error
+	var merged [2]uint64
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		OnMergeUsers: func(target, source *auth.User) error {
+			merged = [2]uint64{target.ID, source.ID}
+			return hookErr
+		},
+	})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+
+	// 同一个人，两个用户
+	target, err := instance.Repository.Create("testmerge", "testmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testmerge"}`), true, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := instance.Repository.FindByOpenID("fake", "testmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance.Repository.CreateRole("merge-editor", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Repository.GrantRole(source.ID, "merge-editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不能合并自己
+	if err := instance.Service.MergeUsers(target, target); err != auth.ErrMergeSameUser {
+		t.Fatalf("不能合并自己：%v", err)
+	}
+
+	// hook出错，取消合并
+	hookErr = errors.New("应用数据迁移失败")
+	if err := instance.Service.MergeUsers(target, source); err != hookErr {
+		t.Fatalf("hook出错理应取消合并：%v", err)
+	}
+	if _, err := instance.Repository.Find(source.ID); err != nil {
+		t.Fatal("取消合并后source理应还在", err)
+	}
+
+	// 合并
+	hookErr = nil
+	if err := instance.Service.MergeUsers(target, source); err != nil {
+		t.Fatal(err)
+	}
+	if merged != [2]uint64{target.ID, source.ID} {
+		t.Fatalf("hook参数不对：%v", merged)
+	}
+	if _, err := instance.Repository.Find(source.ID); err != auth.ErrRecordNotFound {
+		t.Fatalf("source理应已删除：%v", err)
+	}
+
+	// 登录方式、refresh token、角色都归target
+	if user, err := fake.Login([]byte(`{"id": "testmerge"}`)); err != nil || user.ID != target.ID {
+		t.Fatalf("第三方登录理应成为target：%+v, %v", user, err)
+	}
+	if user, _, err := instance.Service.Renew(*tokens.RefreshToken); err != nil || user.ID != target.ID {
+		t.Fatalf("续约理应成为target：%+v, %v", user, err)
+	}
+	if roles, _ := instance.Repository.FindRolesByUser(target.ID); len(roles) != 1 || roles[0] != "merge-editor" {
+		t.Fatalf("角色理应合并：%v", roles)
+	}
+
+	// 审计日志
+	logs, _, _ := instance.Repository.ListLogs(auth.LogQuery{UserID: target.ID, Action: auth.ActionUserMerged})
+	if len(logs) != 1 {
+		t.Fatalf("理应记录合并日志：%+v", logs)
+	}
+}
+
+func TestMergeUsersConflict(t *testing.T) {
+	var hooked bool
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		OnMergeUsers: func(target, source *auth.User) error {
+			hooked = true
+			return nil
+		},
+	})
+	defer instance.Close()
+	passwords := password.NewProvider(&password.Config{Auth: instance})
+	instance.RegisterProvider(passwords)
+	target, err := passwords.Register("testmergeconflict1", "testpassWord123,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := passwords.Register("testmergeconflict2", "testpassWord123,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 都有密码，不能合并
+	if err := instance.Service.MergeUsers(target, source); err != auth.ErrMergeConflict {
+		t.Fatalf("同一个provider理应返回ErrMergeConflict：%v", err)
+	}
+	if hooked {
+		t.Fatal("冲突时不应迁移应用的数据")
+	}
+	if _, err := instance.Repository.Find(source.ID); err != nil {
+		t.Fatal("冲突时source理应还在", err)
+	}
+
+	// 两步验证只保留一个（已启用的）
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	if _, err := instance.Service.Login("fake", []byte(`{"id": "testmergeconflict"}`), false, "phone"); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := instance.Repository.FindByOpenID("fake", "testmergeconflict")
+	if _, _, err := instance.Service.EnrollTOTP(target); err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := instance.Service.EnrollTOTP(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := auth.GenerateTOTP(secret, time.Now())
+	if _, err := instance.Service.ConfirmTOTP(other, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Service.MergeUsers(target, other); err != nil {
+		t.Fatal(err)
+	}
+	identities, _ := instance.Repository.ListIdentitiesByUser(target.ID)
+	var totps int
+	for _, identity := range identities {
+		if identity.Provider == auth.TwoFactorProvider {
+			totps++
+		}
+	}
+	if enabled, _ := instance.Service.TwoFactorEnabled(target); totps != 1 || !enabled {
+		t.Fatalf("理应只保留已启用的两步验证：%d, %t", totps, enabled)
+	}
+}
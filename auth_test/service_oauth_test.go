@@ -0,0 +1,316 @@
+package auth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+// newOAuthServer 授权服务器 + 一个受scope保护的API
+func newOAuthServer(instance *auth.Auth) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/oauth/authorize", instance.Handler.OAuthAuthorize())
+	mux.HandleFunc("/oauth/token", instance.Handler.HandleOAuthToken)
+	api := func(scope string) http.Handler {
+		return instance.Middleware.ParseToken(instance.Middleware.Scoped(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := auth.NewContext(r.Context())
+			fmt.Fprintf(w, "%d %s", ctx.UserID(), ctx.ClientID())
+		})))
+	}
+	mux.Handle("/api/profile", api("profile"))
+	mux.Handle("/api/email", api("email"))
+	// 没有Scoped，只给用户自己登录的
+	mux.Handle("/api/me", instance.Middleware.ParseToken(instance.Middleware.Authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+	// Scoped放在Authenticated前面
+	mux.Handle("/api/login", instance.Handler.Mux())
+	mux.Handle("/api/orders", instance.Middleware.ParseToken(instance.Middleware.Scoped("profile")(instance.Middleware.AuthenticatedWithUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))))
+	return httptest.NewServer(mux)
+}
+
+// noRedirect 不跟随跳转，以便拿到授权码
+var noRedirect = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	instance := auth.New(auth.Config{
+		SecretKey:     secretKey,
+		OAuthLoginURL: "/login",
+		// 用户在确认页只勾选了profile
+		OAuthConsent: func(r *http.Request, user *auth.User, client *auth.OAuthClient, scopes []string) ([]string, error) {
+			if r.URL.Query().Get("approve") != "1" {
+				return nil, auth.ErrAccessDenied
+			}
+			return []string{"profile"}, nil
+		},
+	})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	user, err := fake.Login([]byte(`{"id": "testoauth"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := instance.Service.Login("fake", []byte(`{"id": "testoauth"}`), false, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &auth.OAuthClient{
+		Name:         "内部工具",
+		RedirectURIs: "https://tool.example.com/callback",
+		Scopes:       "profile email",
+	}
+	secret, err := instance.Repository.CreateOAuthClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newOAuthServer(instance)
+	defer server.Close()
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	authorize := func(params url.Values, loggedIn bool) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/oauth/authorize?"+params.Encode(), nil)
+		if loggedIn {
+			req.Header.Set("Authorization", "BEARER "+login.Token)
+		}
+		resp, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"scope":                 {"profile email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"approve":               {"1"},
+	}
+	newCode := func() string {
+		resp := authorize(params, true)
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || location.Query().Get("code") == "" || location.Query().Get("state") != "xyz" {
+			t.Fatalf("理应跳转回客户端并带上code、state：%d %s", resp.StatusCode, location)
+		}
+		return location.Query().Get("code")
+	}
+	exchange := func(code, verifier string) *http.Response {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://tool.example.com/callback"},
+			"code_verifier": {verifier},
+		}
+		req, _ := http.NewRequest("POST", server.URL+"/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// 未登录，跳转到登录页
+	if resp := authorize(params, false); resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), "/login?redirect=") {
+		t.Fatalf("未登录理应跳转到登录页：%d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	// redirect_uri未登记，不能跳转
+	wrong := url.Values{"redirect_uri": {"https://evil.example.com/"}}
+	for key, values := range params {
+		wrong[key] = append(wrong[key], values...)
+	}
+	if resp := authorize(wrong, true); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("redirect_uri未登记理应400：%d", resp.StatusCode)
+	}
+	// 没有PKCE、用户拒绝，带上error跳转回客户端
+	noPKCE := url.Values{}
+	denied := url.Values{}
+	for key, values := range params {
+		if key != "code_challenge" {
+			noPKCE[key] = values
+		}
+		if key != "approve" {
+			denied[key] = values
+		}
+	}
+	for errorCode, params := range map[string]url.Values{"invalid_request": noPKCE, "access_denied": denied} {
+		resp := authorize(params, true)
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if location.Query().Get("error") != errorCode || location.Query().Get("state") != "xyz" {
+			t.Fatalf("理应跳转回客户端并带上error=%s：%s", errorCode, location)
+		}
+	}
+
+	// code_verifier不对
+	if resp := exchange(newCode(), "wrong-verifier-wrong-verifier-wrong-verifier"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("code_verifier不对理应400：%d", resp.StatusCode)
+	}
+
+	// 换取access token
+	code := newCode()
+	resp := exchange(code, verifier)
+	tokens := &auth.OAuthTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokens); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("理应换取access token：%d %v", resp.StatusCode, err)
+	}
+	if tokens.TokenType != "Bearer" || tokens.Scope != "profile" {
+		t.Fatalf("理应只授权了用户同意的scope：%+v", tokens)
+	}
+	// 授权码只能用一次
+	if resp := exchange(code, verifier); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("授权码理应只能用一次：%d", resp.StatusCode)
+	}
+	// secret错误
+	req, _ := http.NewRequest("POST", server.URL+"/oauth/token", strings.NewReader("grant_type=authorization_code&code="+newCode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.ID, "wrong")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("secret错误理应401：%d", resp.StatusCode)
+	}
+
+	// 调用API
+	call := func(path string) (int, string) {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Authorization", "BEARER "+tokens.AccessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, body := call("/api/profile"); status != http.StatusOK || body != fmt.Sprintf("%d %s", user.ID, client.ID) {
+		t.Fatalf("access token理应代表用户：%d %s", status, body)
+	}
+	if status, _ := call("/api/email"); status != http.StatusForbidden {
+		t.Fatalf("未授权的scope理应403：%d", status)
+	}
+	if status, _ := call("/api/me"); status != http.StatusForbidden {
+		t.Fatalf("没有Scoped的路由，access token理应403：%d", status)
+	}
+	if status, _ := call("/api/orders"); status != http.StatusOK {
+		t.Fatalf("Scoped在前的路由，access token理应可以访问：%d", status)
+	}
+	// 不能用access token登出（吊销用户全部的jwt）
+	req, _ = http.NewRequest("DELETE", server.URL+"/api/login", nil)
+	req.Header.Set("Authorization", "BEARER "+tokens.AccessToken)
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("access token不能登出：%d", resp.StatusCode)
+	}
+	if status, _ := call("/api/profile"); status != http.StatusOK {
+		t.Fatalf("access token理应仍然有效：%d", status)
+	}
+	// 不能用access token给client授权
+	login.Token = tokens.AccessToken
+	if resp := authorize(params, true); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("access token不能用来授权：%d", resp.StatusCode)
+	}
+}
+
+func TestOAuthConsentRequired(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+	login, err := instance.Service.Login("fake", []byte(`{"id": "testoauthconsent"}`), false, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newOAuthServer(instance)
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+	for _, trusted := range []bool{false, true} {
+		client := &auth.OAuthClient{Name: "第三方", RedirectURIs: "https://tool.example.com/callback", Scopes: "profile", Trusted: trusted}
+		if _, err := instance.Repository.CreateOAuthClient(client); err != nil {
+			t.Fatal(err)
+		}
+		params := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ID},
+			"scope":                 {"profile"},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+			"code_challenge_method": {"S256"},
+		}
+		req, _ := http.NewRequest("GET", server.URL+"/oauth/authorize?"+params.Encode(), nil)
+		req.Header.Set("Authorization", "BEARER "+login.Token)
+		resp, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if denied := location.Query().Get("error") == "access_denied"; denied == trusted {
+			t.Fatalf("没有配置OAuthConsent，只有Trusted的client可以授权（trusted=%t）：%s", trusted, location)
+		}
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	client := &auth.OAuthClient{
+		Name:   "报表服务",
+		Scopes: "profile",
+		Grants: "client_credentials",
+	}
+	secret, err := instance.Repository.CreateOAuthClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 公开客户端没有secret，不能用client_credentials
+	if _, err := instance.Repository.CreateOAuthClient(&auth.OAuthClient{Name: "App", Grants: "client_credentials", Public: true}); err == nil {
+		t.Fatal("公开客户端不应允许client_credentials")
+	}
+	server := newOAuthServer(instance)
+	defer server.Close()
+
+	token := func(form url.Values) *http.Response {
+		resp, err := http.PostForm(server.URL+"/oauth/token", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ID}, "client_secret": {secret}}
+	if resp := token(url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ID}, "client_secret": {secret}, "scope": {"admin"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("未允许的scope理应400：%d", resp.StatusCode)
+	}
+	if resp := token(url.Values{"grant_type": {"authorization_code"}, "client_id": {client.ID}, "client_secret": {secret}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("未允许的grant_type理应400：%d", resp.StatusCode)
+	}
+	resp := token(form)
+	tokens := &auth.OAuthTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokens); err != nil || tokens.AccessToken == "" || tokens.Scope != "profile" {
+		t.Fatalf("理应颁发access token：%d %+v %v", resp.StatusCode, tokens, err)
+	}
+
+	// 没有用户，只有client
+	req, _ := http.NewRequest("GET", server.URL+"/api/profile", nil)
+	req.Header.Set("Authorization", "BEARER "+tokens.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "0 "+client.ID {
+		t.Fatalf("理应以client身份调用：%d %s", resp.StatusCode, body)
+	}
+}
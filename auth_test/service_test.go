@@ -1,8 +1,14 @@
 package auth_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/goodwong/go-x/auth"
 	"github.com/goodwong/go-x/auth/providers/password"
 )
@@ -14,15 +20,11 @@ var (
 func init() {
 	// 注册password登陆
 	passwords := password.NewProvider(&password.Config{
-		Auth:      auths,
-		SecretKey: secretKey,
+		Auth: auths,
 	})
 	auths.RegisterProvider(passwords)
 
 	// 准备数据
-	db.Delete(&auth.User{}, "username IN (?)", []string{"testpassword"})
-	db.Delete(&auth.UserIdentity{}, "open_id IN (?)", []string{"testpassword"})
-	db.Unscoped().Delete(&auth.Token{}, "device IN (?)", []string{"test", "gotest"})
 	username, password := "testpassword", "testpassWord123,"
 	var err error
 	loginUser, err = passwords.Register(username, password)
@@ -38,6 +40,7 @@ func TestLogin(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Logf("%+v", tokens)
+	firstRefreshToken := *tokens.RefreshToken
 
 	// 再登陆一次
 	tokens, err = auths.Service.Login("password", credentials, true, "gotest")
@@ -50,10 +53,8 @@ func TestLogin(t *testing.T) {
 	t.Logf("%+v", tokens)
 
 	// 多次登陆，只保留最新的
-	count := 0
-	db.Model(&auth.Token{}).Where(&auth.Token{Device: "gotest", UserID: loginUser.ID}).Count(&count)
-	if count != 1 {
-		t.Fatal("多次登陆，理应只有一个有效Token，现在却是：", count)
+	if _, err := repository.FindToken(firstRefreshToken); err == nil {
+		t.Fatal("多次登陆，理应只有一个有效Token，旧Token却仍然有效")
 	}
 
 	// Renew
@@ -70,41 +71,214 @@ func TestLogin(t *testing.T) {
 	}
 
 	// Logout
-	//jwtToken, err := auths.jwtauth.Decode(tokens.Token)
-	//if err != nil {
-	//	t.Fatal(err)
-	//}
-	//if needRelogin := auths.Service.JwtInvalid(jwtToken); needRelogin {
-	//	t.Fatal("不需要重新登录")
-	//}
-	//if err := auths.Service.Logout(loginUser, "gotest"); err != nil {
-	//	t.Fatal(err)
-	//}
-	//if needRelogin := auths.Service.JwtInvalid(jwtToken); !needRelogin {
-	//	t.Fatal("理应需要重新登录")
-	//}
+	// 测试里jwt有效期很短，这里跳过exp检查
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	jwtToken, err := parser.Parse(tokens.Token, func(*jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needRelogin := auths.Service.JwtInvalid(jwtToken); needRelogin {
+		t.Fatal("不需要重新登录")
+	}
+	if err := auths.Service.Logout(loginUser, "gotest"); err != nil {
+		t.Fatal(err)
+	}
+	if needRelogin := auths.Service.JwtInvalid(jwtToken); !needRelogin {
+		t.Fatal("理应需要重新登录")
+	}
 }
 
 func TestClearLogoutsLoop(t *testing.T) {
-	//count := 0
-	//
-	//// 第一次测量
-	//auths.Service.logouts.Range(func(k, v interface{}) bool {
-	//	count++
-	//	return true
-	//})
-	//if count != 1 {
-	//	t.Fatalf("logouts条目应该是1条，测试的数据%d条，不对\n", count)
-	//}
-	//
-	//// 清理后的测量
-	//time.Sleep(2 * auths.CleanupInterval)
-	//count = 0
-	//auths.Service.logouts.Range(func(k, v interface{}) bool {
-	//	count++
-	//	return true
-	//})
-	//if count != 0 {
-	//	t.Fatalf("清理后的logouts条目应该是0条，测试的数据%d条，不对\n", count)
-	//}
+	revocations := auth.NewMemoryRevocationStore()
+	instance := auth.New(auth.Config{
+		SecretKey:         secretKey,
+		Revocations:       revocations,
+		TokenLife:         tokenLife,
+		ImpersonationLife: tokenLife,
+		CleanupInterval:   cleanupInterval,
+	})
+	defer instance.Close()
+
+	// 第一次测量
+	if err := instance.Service.Logout(&auth.User{ID: 1}, "gotest"); err != nil {
+		t.Fatal(err)
+	}
+	if at, _ := revocations.RevokedAt(1); at.IsZero() {
+		t.Fatal("注销记录应该是1条，却没有找到")
+	}
+
+	// 清理后的测量
+	time.Sleep(2*cleanupInterval + tokenLife)
+	if at, _ := revocations.RevokedAt(1); !at.IsZero() {
+		t.Fatal("清理后的注销记录应该是0条，却仍然存在")
+	}
+}
+
+func TestRenewRotation(t *testing.T) {
+	instance := auth.New(auth.Config{
+		SecretKey:              secretKey,
+		RotateRefreshTokens:    true,
+		RefreshTokenReuseGrace: time.Nanosecond, // 不要宽限期
+	})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+
+	// 登陆（第一次登录即创建用户）
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testrotation"}`), true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := *tokens.RefreshToken
+
+	// 续约，返回新的RefreshToken
+	_, tokens, err = instance.Service.Renew(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.RefreshToken == nil || *tokens.RefreshToken == first {
+		t.Fatal("轮换模式下，Renew理应生成新的RefreshToken")
+	}
+	second := *tokens.RefreshToken
+	if _, err := instance.Repository.FindToken(first); err == nil {
+		t.Fatal("已轮换的RefreshToken理应失效")
+	}
+
+	// 旧的RefreshToken再次使用，整个family被吊销
+	if _, _, err := instance.Service.Renew(first); err != auth.ErrTokenReused {
+		t.Fatalf("理应返回ErrTokenReused，当前：%v", err)
+	}
+	if _, _, err := instance.Service.Renew(second); err == nil {
+		t.Fatal("盗用检测后，同一family的RefreshToken理应全部失效")
+	}
+}
+
+func TestRenewReuseGrace(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey, RotateRefreshTokens: true})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testreusegrace"}`), true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := *tokens.RefreshToken
+	_, rotated, err := instance.Service.Renew(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 宽限期内（并发的请求）只颁发jwt，会话是新的token
+	_, tokens, err = instance.Service.Renew(first)
+	if err != nil {
+		t.Fatalf("宽限期内不应视为盗用：%v", err)
+	}
+	if tokens.RefreshToken != nil || tokens.Token == "" || tokens.SessionID != rotated.SessionID {
+		t.Fatalf("宽限期内理应只颁发jwt：%+v", tokens)
+	}
+	if _, err := instance.Repository.FindToken(*rotated.RefreshToken); err != nil {
+		t.Fatalf("新的RefreshToken理应仍然有效：%v", err)
+	}
+
+	// 新的token注销后，旧的在宽限期内也不能续约
+	if err := instance.Repository.DeleteTokenByID(rotated.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := instance.Service.Renew(first); err != auth.ErrInvalidToken {
+		t.Fatalf("理应返回ErrInvalidToken，当前：%v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		instance := auth.New(auth.Config{SecretKey: secretKey})
+		if err := instance.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// 重复调用
+		if err := instance.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Close返回时后台goroutine已经退出
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutine泄漏：之前%d个，之后%d个", before, after)
+	}
+}
+
+func TestShutdownStopsCleanup(t *testing.T) {
+	revocations := auth.NewMemoryRevocationStore()
+	instance := auth.New(auth.Config{
+		SecretKey:       secretKey,
+		Revocations:     revocations,
+		TokenLife:       tokenLife,
+		CleanupInterval: cleanupInterval,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := instance.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭后不再清理，但仍可正常使用
+	if err := instance.Service.Logout(&auth.User{ID: 1}, "gotest"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2*cleanupInterval + tokenLife)
+	if at, _ := revocations.RevokedAt(1); at.IsZero() {
+		t.Fatal("关闭后不应再清理注销记录")
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := newTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	instance := auth.New(auth.Config{
+		SecretKey:        secretKey,
+		TokenLife:        time.Hour,
+		RefreshTokenLife: 24 * time.Hour,
+		JWTCookie:        "my_jwt",
+		Now:              clock.Now,
+	})
+	defer instance.Close()
+	instance.RegisterProvider(&fakeProvider{auth: instance})
+
+	tokens, err := instance.Service.Login("fake", []byte(`{"id": "testclock"}`), true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.TokenExpires.Equal(clock.Now().Add(time.Hour)) || !tokens.RefreshTokenExpires.Equal(clock.Now().Add(24*time.Hour)) {
+		t.Fatalf("有效期不对：%s, %s", tokens.TokenExpires, *tokens.RefreshTokenExpires)
+	}
+
+	// 用配置的cookie名称传递jwt
+	userID := func() uint64 {
+		var id uint64
+		handler := instance.Middleware.ParseToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id = auth.NewContext(r.Context()).UserID()
+		}))
+		req := httptest.NewRequest("GET", "http://localhost/", nil)
+		req.AddCookie(&http.Cookie{Name: "my_jwt", Value: tokens.Token})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return id
+	}
+	if userID() == 0 {
+		t.Fatal("jwt理应有效")
+	}
+
+	// jwt过期
+	clock.Add(time.Hour + time.Second)
+	if userID() != 0 {
+		t.Fatal("jwt理应已过期")
+	}
+	if _, err := instance.Repository.FindToken(*tokens.RefreshToken); err != nil {
+		t.Fatal("refresh token理应有效", err)
+	}
+
+	// refresh token过期
+	clock.Add(24 * time.Hour)
+	if _, err := instance.Repository.FindToken(*tokens.RefreshToken); err != auth.ErrInvalidToken {
+		t.Fatal("refresh token理应已过期", err)
+	}
 }
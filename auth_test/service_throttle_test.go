@@ -0,0 +1,109 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+	"github.com/goodwong/go-x/auth/providers/password"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	clock := newTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	instance := auth.New(auth.Config{
+		SecretKey: secretKey,
+		Now:       clock.Now,
+		LoginThrottle: &auth.LoginThrottle{
+			FreeAttempts:    2,
+			Delay:           time.Second,
+			MaxDelay:        2 * time.Second,
+			LockoutAfter:    5,
+			IPLockoutAfter:  8,
+			LockoutDuration: time.Minute,
+			Window:          time.Hour,
+		},
+	})
+	defer instance.Close()
+	passwords := password.NewProvider(&password.Config{Auth: instance})
+	instance.RegisterProvider(passwords)
+	user, err := passwords.Register("testthrottle", "testpassWord123,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "http://localhost/api/login", nil)
+	req.RemoteAddr = "192.0.2.9:1234"
+	service := instance.Service.WithRequest(req)
+	login := func(username, pwd string) error {
+		credentials := []byte(fmt.Sprintf(`{"username":"%s", "password":"%s"}`, username, pwd))
+		_, err := service.Login("password", credentials, false, "gotest")
+		return err
+	}
+
+	// 前2次失败不限制
+	for i := 0; i < 2; i++ {
+		if err := login("testthrottle", "wrong"); err == nil || err == auth.ErrLoginThrottled {
+			t.Fatalf("第%d次理应是密码错误：%v", i+1, err)
+		}
+	}
+
+	// 之后须等待，密码正确也不行
+	if err := login("testthrottle", "testpassWord123,"); err != auth.ErrLoginThrottled {
+		t.Fatalf("理应须等待：%v", err)
+	}
+	clock.Add(time.Second)
+	if err := login("testthrottle", "wrong"); err == nil || err == auth.ErrLoginThrottled {
+		t.Fatalf("等待后理应可以再试：%v", err)
+	}
+
+	// 等待时间翻倍
+	clock.Add(time.Second)
+	if err := login("testthrottle", "wrong"); err != auth.ErrLoginThrottled {
+		t.Fatalf("理应须等待2秒：%v", err)
+	}
+	clock.Add(time.Second)
+	if err := login("testthrottle", "wrong"); err == nil || err == auth.ErrLoginThrottled {
+		t.Fatalf("等待后理应可以再试：%v", err)
+	}
+
+	// 第5次失败，锁定
+	clock.Add(2 * time.Second)
+	if err := login("testthrottle", "wrong"); err == nil || err == auth.ErrLoginThrottled {
+		t.Fatalf("等待后理应可以再试：%v", err)
+	}
+	clock.Add(2 * time.Second)
+	if err := login("testthrottle", "testpassWord123,"); err != auth.ErrLoginLocked {
+		t.Fatalf("理应已锁定：%v", err)
+	}
+	logs, _, err := instance.Repository.ListLogs(auth.LogQuery{UserID: user.ID, Action: auth.ActionLoginLocked})
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("理应记录锁定日志：%v, %v", logs, err)
+	}
+
+	// 锁定期满，登录成功后重新计数
+	clock.Add(time.Minute)
+	if err := login("testthrottle", "testpassWord123,"); err != nil {
+		t.Fatalf("锁定期满理应可以登录：%v", err)
+	}
+	if err := login("testthrottle", "wrong"); err == nil || err == auth.ErrLoginThrottled {
+		t.Fatalf("登录成功后理应重新计数：%v", err)
+	}
+
+	// 同一IP换用户名，累计失败达到IPLockoutAfter后，IP被锁定
+	for i := 0; i < 2; i++ {
+		clock.Add(time.Minute)
+		if err := login(fmt.Sprintf("nobody%d", i), "wrong"); err == nil || err == auth.ErrLoginLocked {
+			t.Fatalf("IP尚未锁定：%v", err)
+		}
+	}
+	clock.Add(time.Second)
+	if err := login("nobody9", "wrong"); err != auth.ErrLoginLocked {
+		t.Fatalf("IP理应已锁定：%v", err)
+	}
+	logs, _, _ = instance.Repository.ListLogs(auth.LogQuery{Action: auth.ActionLoginLocked})
+	if len(logs) != 2 || logs[0].UserID != 0 || logs[0].IP != "192.0.2.9" {
+		t.Fatalf("理应记录IP锁定日志：%+v", logs)
+	}
+}
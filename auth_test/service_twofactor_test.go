@@ -0,0 +1,234 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goodwong/go-x/auth"
+)
+
+func TestTwoFactor(t *testing.T) {
+	clock := newTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	instance := auth.New(auth.Config{
+		SecretKey:       secretKey,
+		TwoFactorIssuer: "gotest",
+		Now:             clock.Now,
+	})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	user, err := fake.Login([]byte(`{"id": "testtwofactor"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := []byte(`{"id": "testtwofactor"}`)
+
+	// 启用
+	secret, uri, err := instance.Service.EnrollTOTP(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(uri)
+	if enabled, _ := instance.Service.TwoFactorEnabled(user); enabled {
+		t.Fatal("确认之前不应启用")
+	}
+	if _, err := instance.Service.ConfirmTOTP(user, "000000"); err != auth.ErrInvalidTwoFactorCode {
+		t.Fatalf("错误的验证码不应通过：%v", err)
+	}
+	code, _ := auth.GenerateTOTP(secret, clock.Now())
+	recoveryCodes, err := instance.Service.ConfirmTOTP(user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("理应返回10个恢复码：%v", recoveryCodes)
+	}
+
+	// 登录只返回challenge
+	tokens, err := instance.Service.Login("fake", credentials, true, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token != "" || tokens.RefreshToken != nil || tokens.Challenge == "" {
+		t.Fatalf("理应只返回challenge：%+v", tokens)
+	}
+	challenge := tokens.Challenge
+
+	// 同一个验证码不能再用
+	if _, err := instance.Service.VerifyTwoFactor(challenge, code); err != auth.ErrInvalidTwoFactorCode {
+		t.Fatalf("用过的验证码不应再次通过：%v", err)
+	}
+
+	// 下一个周期的验证码
+	clock.Add(30 * time.Second)
+	code, _ = auth.GenerateTOTP(secret, clock.Now())
+	tokens, err = instance.Service.VerifyTwoFactor(challenge, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == nil {
+		t.Fatalf("理应颁发token：%+v", tokens)
+	}
+
+	// challenge只能用一次
+	clock.Add(30 * time.Second)
+	code, _ = auth.GenerateTOTP(secret, clock.Now())
+	if _, err := instance.Service.VerifyTwoFactor(challenge, code); err != auth.ErrInvalidChallenge {
+		t.Fatalf("用过的challenge不应再次通过：%v", err)
+	}
+
+	// 恢复码，只能用一次
+	tokens, _ = instance.Service.Login("fake", credentials, false, "gotest")
+	if _, err := instance.Service.VerifyTwoFactor(tokens.Challenge, recoveryCodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	tokens, _ = instance.Service.Login("fake", credentials, false, "gotest")
+	if _, err := instance.Service.VerifyTwoFactor(tokens.Challenge, recoveryCodes[0]); err != auth.ErrInvalidTwoFactorCode {
+		t.Fatalf("用过的恢复码不应再次通过：%v", err)
+	}
+
+	// 并发使用同一个challenge、验证码，只有一个通过
+	clock.Add(30 * time.Second)
+	code, _ = auth.GenerateTOTP(secret, clock.Now())
+	var wg sync.WaitGroup
+	var passed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := instance.Service.VerifyTwoFactor(tokens.Challenge, code); err == nil {
+				atomic.AddInt32(&passed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if passed != 1 {
+		t.Fatalf("理应只有一个通过：%d", passed)
+	}
+
+	// challenge过期、伪造
+	clock.Add(auth.DefaultChallengeLife + time.Second)
+	code, _ = auth.GenerateTOTP(secret, clock.Now())
+	if _, err := instance.Service.VerifyTwoFactor(tokens.Challenge, code); err != auth.ErrInvalidChallenge {
+		t.Fatalf("过期的challenge不应通过：%v", err)
+	}
+	if _, err := instance.Service.VerifyTwoFactor("forged", code); err != auth.ErrInvalidChallenge {
+		t.Fatalf("伪造的challenge不应通过：%v", err)
+	}
+
+	// 停用
+	if err := instance.Service.DisableTOTP(user, recoveryCodes[1]); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err = instance.Service.Login("fake", credentials, false, "gotest")
+	if err != nil || tokens.Token == "" {
+		t.Fatalf("停用后理应直接颁发token：%+v, %v", tokens, err)
+	}
+}
+
+func TestTwoFactorKeyRotation(t *testing.T) {
+	clock := newTestClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage := auth.NewMemoryStorage()
+	tokenKey1 := auth.TokenKey{ID: "2020-01", Key: []byte("11111111111111111111111111111111")}
+	tokenKey2 := auth.TokenKey{ID: "2020-06", Key: []byte("22222222222222222222222222222222")}
+	key1 := auth.TokenKey{ID: "totp-1", Key: []byte("33333333333333333333333333333333")}
+	key2 := auth.TokenKey{ID: "totp-2", Key: []byte("44444444444444444444444444444444")}
+	newInstance := func(tokenKeys, twoFactorKeys []auth.TokenKey) *auth.Auth {
+		return auth.New(auth.Config{
+			Storage:       storage,
+			SecretKey:     secretKey,
+			TokenKeys:     tokenKeys,
+			TwoFactorKeys: twoFactorKeys,
+			Now:           clock.Now,
+		})
+	}
+	instance := newInstance([]auth.TokenKey{tokenKey1}, []auth.TokenKey{key1})
+	defer instance.Close()
+	user, err := instance.Repository.Create("testtwofactorkeys", "两步验证密钥")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := instance.Service.EnrollTOTP(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := auth.GenerateTOTP(secret, clock.Now())
+	if _, err := instance.Service.ConfirmTOTP(user, code); err != nil {
+		t.Fatal(err)
+	}
+	verify := func(instance *auth.Auth) error {
+		clock.Add(30 * time.Second)
+		code, _ := auth.GenerateTOTP(secret, clock.Now())
+		_, err := instance.Service.RegenerateRecoveryCodes(user, code)
+		return err
+	}
+
+	// 移除旧的TokenKey，不影响两步验证
+	rotated := newInstance([]auth.TokenKey{tokenKey2}, []auth.TokenKey{key1})
+	defer rotated.Close()
+	if err := verify(rotated); err != nil {
+		t.Fatalf("TokenKeys轮换不应影响两步验证：%v", err)
+	}
+
+	// 轮换TwoFactorKeys，旧key仍可解密，验证成功后用新key重新加密
+	rotated = newInstance([]auth.TokenKey{tokenKey2}, []auth.TokenKey{key2, key1})
+	defer rotated.Close()
+	if err := verify(rotated); err != nil {
+		t.Fatalf("旧key加密的TOTP密钥理应仍可解密：%v", err)
+	}
+	rotated = newInstance([]auth.TokenKey{tokenKey2}, []auth.TokenKey{key2})
+	defer rotated.Close()
+	if err := verify(rotated); err != nil {
+		t.Fatalf("验证成功后理应已用新key重新加密：%v", err)
+	}
+
+	// 已经用新key重新加密，只有旧key的无法解密
+	if err := verify(instance); err == nil {
+		t.Fatal("旧key理应无法解密新key加密的TOTP密钥")
+	}
+}
+
+func TestHandleTwoFactorLogin(t *testing.T) {
+	instance := auth.New(auth.Config{SecretKey: secretKey})
+	defer instance.Close()
+	fake := &fakeProvider{auth: instance}
+	instance.RegisterProvider(fake)
+	user, err := fake.Login([]byte(`{"id": "testtwofactorhandler"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, _ := instance.Service.EnrollTOTP(user)
+	code, _ := auth.GenerateTOTP(secret, time.Now())
+	recoveryCodes, err := instance.Service.ConfirmTOTP(user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一步：不设置cookie，只返回challenge
+	buffer := bytes.NewBufferString(`{"id": "testtwofactorhandler"}`)
+	req := httptest.NewRequest("POST", "http://localhost/api/login?provider=fake&remember=1&device=gotest", buffer)
+	w := httptest.NewRecorder()
+	instance.Handler.HandleLogin(w, req)
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatalf("第一步不应设置cookie：%v", w.Result().Cookies())
+	}
+	tokens := &auth.TokenResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(tokens); err != nil || tokens.Challenge == "" {
+		t.Fatalf("理应返回challenge：%+v, %v", tokens, err)
+	}
+
+	// 第二步
+	body, _ := json.Marshal(map[string]string{"challenge": tokens.Challenge, "code": recoveryCodes[0]})
+	req = httptest.NewRequest("POST", "http://localhost/api/login/2fa", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	instance.Handler.HandleTwoFactorLogin(w, req)
+	if w.Result().StatusCode != http.StatusOK || len(w.Result().Cookies()) != 2 {
+		t.Fatalf("理应登录成功并设置cookie：%d %v", w.Result().StatusCode, w.Result().Cookies())
+	}
+}